@@ -0,0 +1,94 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/application-research/edge-ur/core"
+	"github.com/labstack/echo/v4"
+)
+
+const authResultKey = "auth"
+
+// authMiddleware lets the requests through whose api key the authenticator of the node validates, the result is kept
+// on the context for the scope checks and the handlers.
+func authMiddleware(ln *core.LightNode) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authParts := strings.Split(c.Request().Header.Get("Authorization"), " ")
+			if len(authParts) != 2 || authParts[1] == "" {
+				return authError(c, http.StatusUnauthorized, "Invalid Authorization Header")
+			}
+
+			authResult, err := ln.Auth.Authenticate(c.Request().Context(), authParts[1])
+			if err != nil {
+				log.Errorf("handler error: %s", err)
+				return authError(c, http.StatusServiceUnavailable, "The api key could not be checked, try again later")
+			}
+			if !authResult.Validated {
+				return authError(c, http.StatusUnauthorized, authResult.Details)
+			}
+			c.Set(authResultKey, authResult)
+			return next(c)
+		}
+	}
+}
+
+// requireScope lets the requests through whose api key has scope, it runs after authMiddleware.
+func requireScope(scope string) echo.MiddlewareFunc {
+	return scopeByMethod(scope, scope)
+}
+
+// scopeByMethod requires readScope for the GET and HEAD requests of a group and writeScope for the others.
+func scopeByMethod(readScope string, writeScope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			scope := writeScope
+			if c.Request().Method == http.MethodGet || c.Request().Method == http.MethodHead {
+				scope = readScope
+			}
+			authResult, ok := c.Get(authResultKey).(core.AuthResult)
+			if !ok {
+				return authError(c, http.StatusUnauthorized, "Invalid Authorization Header")
+			}
+			if !authResult.HasScope(scope) {
+				return authError(c, http.StatusForbidden, "The "+authResult.Role+" role of the api key does not have the "+scope+" scope")
+			}
+			return next(c)
+		}
+	}
+}
+
+// authError answers a request that is not authenticated (401) or not allowed (403).
+func authError(c echo.Context, code int, details string) error {
+	return c.JSON(code, HttpErrorResponse{
+		Error: HttpError{
+			Code:    code,
+			Reason:  http.StatusText(code),
+			Details: details,
+		},
+	})
+}
+
+// requestKeyHash returns the hash of the api key the request was made with, the contents and the other records of the
+// key are kept under it.
+func requestKeyHash(c echo.Context) string {
+	authResult, _ := c.Get(authResultKey).(core.AuthResult)
+	return authResult.KeyHash
+}
+
+// requestApiKey returns the api key the request was made with. It is only used to derive the keys of encrypted
+// contents and is never stored.
+func requestApiKey(c echo.Context) string {
+	authParts := strings.Split(c.Request().Header.Get("Authorization"), " ")
+	if len(authParts) != 2 {
+		return ""
+	}
+	return authParts[1]
+}
+
+// isAdminRequest tells if the request was made with an admin api key.
+func isAdminRequest(c echo.Context) bool {
+	authResult, ok := c.Get(authResultKey).(core.AuthResult)
+	return ok && authResult.Role == core.RoleAdmin
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/application-research/edge-ur/core"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/xerrors"
+)
+
+// failingAuthenticator stands in for an auth backend that can't be reached.
+type failingAuthenticator struct{}
+
+func (failingAuthenticator) Authenticate(ctx context.Context, apiKey string) (core.AuthResult, error) {
+	return core.AuthResult{}, xerrors.New("auth backend unreachable")
+}
+
+// newAuthTest returns a node with the local auth backend and an api key of every role.
+func newAuthTest(t *testing.T) (*core.LightNode, map[string]string) {
+	t.Helper()
+	var cfg config.EdgeConfig
+	cfg.Auth.Backend = core.AuthBackendLocal
+	cfg.Auth.DefaultRole = core.RoleUploader
+	cfg.Node.ApiKeySaltFile = filepath.Join(t.TempDir(), "api-key-salt")
+	node := newTestNode(t, cfg)
+
+	hasher, err := core.NewApiKeyHasher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Keys = hasher
+	if node.Auth, err = core.NewAuthenticator(cfg, node.DB, hasher); err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]string{}
+	for _, role := range []string{core.RoleAdmin, core.RoleUploader, core.RoleReadOnly, core.RoleSP} {
+		apiKey, _, err := core.CreateApiKey(node.DB, hasher, role+"-owner", role, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[role] = apiKey
+	}
+	return node, keys
+}
+
+func authRequest(e *echo.Echo, method string, target string, authorization string) int {
+	req := httptest.NewRequest(method, target, nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestAdminRoutesAuth(t *testing.T) {
+	node, keys := newAuthTest(t)
+	e := echo.New()
+	ConfigureBucketsRouter(e.Group(""), node)
+	ConfigureCollectionsRouter(e.Group(""), node)
+
+	routes := []struct {
+		method string
+		target string
+	}{
+		{http.MethodPost, "/buckets/create"},
+		{http.MethodDelete, "/buckets/bucket-uuid"},
+		{http.MethodGet, "/collections/create"},
+		{http.MethodPost, "/collections/modify"},
+	}
+	for _, route := range routes {
+		name := route.method + " " + route.target
+		for _, authorization := range []string{"", "Bearer", "Bearer unknown-key", "Bearer " + keys[core.RoleAdmin] + " extra"} {
+			if code := authRequest(e, route.method, route.target, authorization); code != http.StatusUnauthorized {
+				t.Fatalf("%s with %q got %d", name, authorization, code)
+			}
+		}
+		for _, role := range []string{core.RoleUploader, core.RoleReadOnly, core.RoleSP} {
+			if code := authRequest(e, route.method, route.target, "Bearer "+keys[role]); code != http.StatusForbidden {
+				t.Fatalf("%s as %s got %d", name, role, code)
+			}
+		}
+		if code := authRequest(e, route.method, route.target, "Bearer "+keys[core.RoleAdmin]); code == http.StatusUnauthorized || code == http.StatusForbidden {
+			t.Fatalf("%s as admin got %d", name, code)
+		}
+	}
+
+	// an auth backend that can't be reached doesn't let anything through
+	node.Auth = failingAuthenticator{}
+	if code := authRequest(e, http.MethodPost, "/buckets/create", "Bearer "+keys[core.RoleAdmin]); code != http.StatusServiceUnavailable {
+		t.Fatalf("request with an unreachable auth backend got %d", code)
+	}
+}
+
+func TestScopeByMethod(t *testing.T) {
+	node, keys := newAuthTest(t)
+	e := echo.New()
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	api := e.Group("/api/v1", authMiddleware(node))
+	content := api.Group("/content", scopeByMethod(core.ScopeRead, core.ScopeUpload))
+	content.GET("/list", ok)
+	content.HEAD("/list", ok)
+	content.POST("/add", ok)
+	api.GET("/claims", ok, requireScope(core.ScopeClaim))
+
+	cases := []struct {
+		role   string
+		method string
+		target string
+		code   int
+	}{
+		{core.RoleReadOnly, http.MethodGet, "/api/v1/content/list", http.StatusOK},
+		{core.RoleReadOnly, http.MethodHead, "/api/v1/content/list", http.StatusOK},
+		{core.RoleReadOnly, http.MethodPost, "/api/v1/content/add", http.StatusForbidden},
+		{core.RoleUploader, http.MethodPost, "/api/v1/content/add", http.StatusOK},
+		{core.RoleSP, http.MethodPost, "/api/v1/content/add", http.StatusForbidden},
+		{core.RoleSP, http.MethodGet, "/api/v1/claims", http.StatusOK},
+		{core.RoleUploader, http.MethodGet, "/api/v1/claims", http.StatusForbidden},
+		{core.RoleAdmin, http.MethodGet, "/api/v1/claims", http.StatusOK},
+		{core.RoleAdmin, http.MethodPost, "/api/v1/content/add", http.StatusOK},
+	}
+	for _, tc := range cases {
+		if code := authRequest(e, tc.method, tc.target, "Bearer "+keys[tc.role]); code != tc.code {
+			t.Fatalf("%s %s as %s got %d, expected %d", tc.method, tc.target, tc.role, code, tc.code)
+		}
+	}
+
+	// the scope checks refuse requests that were not authenticated
+	unauthenticated := echo.New()
+	unauthenticated.GET("/list", ok, requireScope(core.ScopeRead))
+	if code := authRequest(unauthenticated, http.MethodGet, "/list", "Bearer "+keys[core.RoleAdmin]); code != http.StatusUnauthorized {
+		t.Fatalf("request without authentication got %d", code)
+	}
+}
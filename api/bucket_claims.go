@@ -0,0 +1,149 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/application-research/edge-ur/core"
+	"github.com/labstack/echo/v4"
+)
+
+type ClaimBucketRequest struct {
+	Miner      string `json:"miner"`
+	BucketUuid string `json:"bucket_uuid"` // optional, the oldest claimable bucket is claimed without it
+}
+
+type BucketClaimResponse struct {
+	Claim  core.BucketClaim `json:"claim"`
+	Bucket BucketsResponse  `json:"bucket"`
+}
+
+// ConfigureBucketClaimsRouter configures the routes storage providers use to claim ready buckets. A claim is a lease
+// on the bucket that the storage provider confirms once it took the deal, or releases when it won't.
+func ConfigureBucketClaimsRouter(e *echo.Group, node *core.LightNode) {
+	buckets := e.Group("/buckets", scopeByMethod(core.ScopeRead, core.ScopeClaim))
+	buckets.POST("/claim", handleClaimBucket(node))
+	buckets.GET("/claims", handleListBucketClaims(node))
+	buckets.POST("/claims/:id/confirm", handleConfirmBucketClaim(node))
+	buckets.POST("/claims/:id/release", handleReleaseBucketClaim(node))
+}
+
+// The function `handleClaimBucket` leases a ready bucket to a storage provider for the configured lease time.
+func handleClaimBucket(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var req ClaimBucketRequest
+		if err := c.Bind(&req); err != nil || req.Miner == "" {
+			return c.JSON(400, map[string]interface{}{
+				"message": "Please provide the miner claiming the bucket",
+			})
+		}
+
+		lease := time.Duration(node.Config.Common.BucketClaimLease) * time.Second
+		if lease <= 0 {
+			lease = 24 * time.Hour
+		}
+		claim, bucket, err := node.Claims.Claim(req.Miner, req.BucketUuid, requestKeyHash(c), lease)
+		switch {
+		case errors.Is(err, core.ErrNoClaimableBucket), errors.Is(err, core.ErrBucketNotReady):
+			return c.JSON(404, map[string]interface{}{
+				"message": err.Error(),
+			})
+		case errors.Is(err, core.ErrBucketFullyClaimed), errors.Is(err, core.ErrAlreadyClaimed):
+			return c.JSON(409, map[string]interface{}{
+				"message": err.Error(),
+			})
+		case err != nil:
+			return c.JSON(500, map[string]interface{}{
+				"message": "Error claiming the bucket: " + err.Error(),
+			})
+		}
+
+		return c.JSON(200, BucketClaimResponse{
+			Claim:  claim,
+			Bucket: newBucketsResponse(node, bucket),
+		})
+	}
+}
+
+// The function `handleListBucketClaims` lists the claims made with the api key of the request, the admin api key sees
+// all the claims. The claims can be filtered by `miner` and `status`.
+func handleListBucketClaims(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		query := node.DB.Model(&core.BucketClaim{})
+		if !isAdminRequest(c) {
+			query = query.Where("api_key_hash = ?", requestKeyHash(c))
+		}
+		if miner := c.QueryParam("miner"); miner != "" {
+			query = query.Where("miner = ?", miner)
+		}
+		if status := c.QueryParam("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+
+		var claims []core.BucketClaim
+		query.Order("id desc").Find(&claims)
+		return c.JSON(200, claims)
+	}
+}
+
+// The function `handleConfirmBucketClaim` confirms a leased claim, the bucket slot is then held for good.
+func handleConfirmBucketClaim(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		claim, err := findBucketClaim(c, node)
+		if err != nil {
+			return c.JSON(404, map[string]interface{}{
+				"message": "Claim not found",
+			})
+		}
+
+		if err := node.Claims.Confirm(&claim); err != nil {
+			if errors.Is(err, core.ErrLeaseExpired) {
+				return c.JSON(409, map[string]interface{}{
+					"message": "The lease of the claim ran out or the claim was released, please claim the bucket again",
+				})
+			}
+			return err
+		}
+		return c.JSON(200, claim)
+	}
+}
+
+// The function `handleReleaseBucketClaim` releases a leased claim so the bucket can be claimed by another storage
+// provider.
+func handleReleaseBucketClaim(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		claim, err := findBucketClaim(c, node)
+		if err != nil {
+			return c.JSON(404, map[string]interface{}{
+				"message": "Claim not found",
+			})
+		}
+
+		if err := node.Claims.Release(&claim); err != nil {
+			if errors.Is(err, core.ErrLeaseExpired) {
+				return c.JSON(409, map[string]interface{}{
+					"message": "Only leased claims can be released",
+				})
+			}
+			return err
+		}
+		return c.JSON(200, claim)
+	}
+}
+
+// findBucketClaim loads the claim of the request. Claims can only be changed with the api key that made them, or with
+// the admin api key.
+func findBucketClaim(c echo.Context, node *core.LightNode) (core.BucketClaim, error) {
+	var claim core.BucketClaim
+	claimId, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return claim, err
+	}
+	query := node.DB.Model(&core.BucketClaim{}).Where("id = ?", claimId)
+	if !isAdminRequest(c) {
+		query = query.Where("api_key_hash = ?", requestKeyHash(c))
+	}
+	err = query.First(&claim).Error
+	return claim, err
+}
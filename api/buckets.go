@@ -5,7 +5,6 @@ import (
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -40,8 +39,8 @@ func ConfigureBucketsRouter(e *echo.Group, node *core.LightNode) {
 	//buckets.GET("/get/open", handleGetInProgressBuckets(node))
 	buckets.GET("/get/in-progress", handleGetInProgressBuckets(node))
 	buckets.GET("/get/processing", handleGetInProgressBuckets(node))
-	buckets.POST("/create", handleCreateBucket(node))
-	buckets.DELETE("/:uuid", handleDeleteBucket(node))
+	buckets.POST("/create", handleCreateBucket(node), authMiddleware(node), requireScope(core.ScopeAdmin))
+	buckets.DELETE("/:uuid", handleDeleteBucket(node), authMiddleware(node), requireScope(core.ScopeAdmin))
 }
 
 type CreateBucketRequest struct {
@@ -82,15 +81,12 @@ func handleGetInProgressBuckets(node *core.LightNode) func(c echo.Context) error
 			}
 			response.PieceCommitment.PaddedPieceSize = bucket.PieceSize
 			response.PieceCommitment.PieceCid = bucket.PieceCid
-			response.TransferParameters.URL = node.Api.Scheme + node.Config.Node.GwHost + "/gw/" + bucket.Cid
+			response.TransferParameters.URL = node.Api.Scheme + node.Config.Node.GwHost + "/piece/" + bucket.PieceCid
 			bucketsResponse = append(bucketsResponse, response)
 
 			// get all the content
 			var contents []core.Content
 			node.DB.Model(&core.Content{}).Where("bucket_uuid = ?", bucket.Uuid).Find(&contents)
-			for i := range contents {
-				contents[i].RequestingApiKey = ""
-			}
 			bucketsResponse[len(bucketsResponse)-1].Contents = contents
 		}
 
@@ -109,15 +105,6 @@ func handleGetInProgressBuckets(node *core.LightNode) func(c echo.Context) error
 func handleCreateBucket(node *core.LightNode) func(c echo.Context) error {
 	return func(c echo.Context) error {
 
-		// authorize
-		authorizationString := c.Request().Header.Get("Authorization")
-		authParts := strings.Split(authorizationString, " ")
-		if authParts[1] != node.Config.Node.AdminApiKey {
-			return c.JSON(401, map[string]interface{}{
-				"message": "Unauthorized",
-			})
-		}
-
 		// get the name
 		var createBucketRequest CreateBucketRequest
 		err := c.Bind(&createBucketRequest)
@@ -152,12 +139,12 @@ func handleCreateBucket(node *core.LightNode) func(c echo.Context) error {
 				})
 			}
 			bucket = core.Bucket{
-				Status:           "open",
-				Name:             tagName,
-				RequestingApiKey: authParts[1],
-				Uuid:             bucketUuid.String(),
-				CreatedAt:        time.Now(),
-				UpdatedAt:        time.Now(),
+				Status:     "open",
+				Name:       tagName,
+				ApiKeyHash: requestKeyHash(c),
+				Uuid:       bucketUuid.String(),
+				CreatedAt:  time.Now(),
+				UpdatedAt:  time.Now(),
 			}
 			node.DB.Create(&bucket)
 		}
@@ -169,15 +156,6 @@ func handleCreateBucket(node *core.LightNode) func(c echo.Context) error {
 func handleDeleteBucket(node *core.LightNode) func(c echo.Context) error {
 	return func(c echo.Context) error {
 
-		// check if its being called by the admin api key
-		authorizationString := c.Request().Header.Get("Authorization")
-		authParts := strings.Split(authorizationString, " ")
-		if authParts[1] != node.Config.Node.AdminApiKey {
-			return c.JSON(401, map[string]interface{}{
-				"message": "Unauthorized",
-			})
-		}
-
 		node.DB.Model(&core.Bucket{}).Where("uuid = ?", c.Param("uuid")).Update("status", "deleted")
 		return c.JSON(200, map[string]interface{}{
 			"message": "Bucket deleted",
@@ -186,7 +164,9 @@ func handleDeleteBucket(node *core.LightNode) func(c echo.Context) error {
 	}
 }
 
-// The function `handleGetOpenBuckets` handles the GET request for retrieving a list of open buckets with pagination.
+// The function `handleGetOpenBuckets` handles the GET request for retrieving a list of ready buckets with pagination.
+// Buckets that are claimed by as many storage providers as their policy allows are not listed, and with the `miner`
+// query param neither are the buckets that miner already claimed.
 func handleGetOpenBuckets(node *core.LightNode) func(c echo.Context) error {
 	return func(c echo.Context) error {
 		assignMiner := c.QueryParam("miner")
@@ -203,32 +183,11 @@ func handleGetOpenBuckets(node *core.LightNode) func(c echo.Context) error {
 
 		var buckets []core.Bucket
 		offset := (pageNum - 1) * pageSize
-		node.DB.Model(&core.Bucket{}).Where("status = ?", "ready").Offset(offset).Limit(pageSize).Find(&buckets)
+		core.ClaimableBuckets(node.DB, *node.Config, assignMiner, time.Now()).Select("buckets.*").Order("buckets.id asc").Offset(offset).Limit(pageSize).Find(&buckets)
 
 		var bucketsResponse []BucketsResponse
 		for _, bucket := range buckets {
-			response := BucketsResponse{
-				BucketUUID:     bucket.Uuid,
-				PieceCid:       bucket.PieceCid,
-				PayloadCid:     bucket.Cid,
-				DirCid:         bucket.DirCid,
-				Status:         bucket.Status,
-				CollectionName: bucket.Name,
-				Size:           bucket.Size,
-				CreatedAt:      bucket.CreatedAt,
-				UpdatedAt:      bucket.UpdatedAt,
-				Miner: func() string {
-					if assignMiner != "" {
-						return assignMiner
-					}
-					return ""
-				}(),
-			}
-			response.PieceCommitment.PaddedPieceSize = bucket.PieceSize
-			response.PieceCommitment.PieceCid = bucket.PieceCid
-			response.TransferParameters.URL = node.Api.Scheme + node.Config.Node.GwHost + "/gw/" + bucket.Cid
-			bucketsResponse = append(bucketsResponse, response)
-
+			bucketsResponse = append(bucketsResponse, newBucketsResponse(node, bucket))
 		}
 
 		if len(bucketsResponse) == 0 {
@@ -240,3 +199,23 @@ func handleGetOpenBuckets(node *core.LightNode) func(c echo.Context) error {
 		return c.JSON(200, bucketsResponse)
 	}
 }
+
+// newBucketsResponse describes a ready bucket the way storage providers need it to make a deal.
+func newBucketsResponse(node *core.LightNode, bucket core.Bucket) BucketsResponse {
+	response := BucketsResponse{
+		BucketUUID:     bucket.Uuid,
+		Miner:          bucket.Miner,
+		PieceCid:       bucket.PieceCid,
+		PayloadCid:     bucket.Cid,
+		DirCid:         bucket.DirCid,
+		Status:         bucket.Status,
+		CollectionName: bucket.Name,
+		Size:           bucket.Size,
+		CreatedAt:      bucket.CreatedAt,
+		UpdatedAt:      bucket.UpdatedAt,
+	}
+	response.PieceCommitment.PaddedPieceSize = bucket.PieceSize
+	response.PieceCommitment.PieceCid = bucket.PieceCid
+	response.TransferParameters.URL = node.Api.Scheme + node.Config.Node.GwHost + "/piece/" + bucket.PieceCid
+	return response
+}
@@ -4,7 +4,6 @@ import (
 	"github.com/application-research/edge-ur/core"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"strings"
 	"time"
 )
 
@@ -18,8 +17,8 @@ func ConfigureCollectionsRouter(e *echo.Group, node *core.LightNode) {
 	//var DeltaUploadApi = node.Config.Delta.ApiUrl
 	collections := e.Group("/collections")
 	collections.GET("/get", handleGetCollections(node))
-	collections.GET("/create", handleCreateCollection(node))
-	collections.POST("/modify", handleModifyCollection(node))
+	collections.GET("/create", handleCreateCollection(node), authMiddleware(node), requireScope(core.ScopeAdmin))
+	collections.POST("/modify", handleModifyCollection(node), authMiddleware(node), requireScope(core.ScopeAdmin))
 	//buckets.DELETE("/remove/:collection-name", handleDeleteBucket(node))
 
 }
@@ -54,7 +53,7 @@ func handleGetCollections(node *core.LightNode) func(c echo.Context) error {
 			}
 			response.PieceCommitment.PaddedPieceSize = bucket.PieceSize
 			response.PieceCommitment.PieceCid = bucket.PieceCid
-			response.TransferParameters.URL = node.Api.Scheme + node.Config.Node.GwHost + "/gw/" + bucket.Cid
+			response.TransferParameters.URL = node.Api.Scheme + node.Config.Node.GwHost + "/piece/" + bucket.PieceCid
 			bucketsResponse = append(bucketsResponse, response)
 		}
 
@@ -73,15 +72,6 @@ func handleGetCollections(node *core.LightNode) func(c echo.Context) error {
 func handleCreateCollection(node *core.LightNode) func(c echo.Context) error {
 	return func(c echo.Context) error {
 
-		// authorize
-		authorizationString := c.Request().Header.Get("Authorization")
-		authParts := strings.Split(authorizationString, " ")
-		if authParts[1] != node.Config.Node.AdminApiKey {
-			return c.JSON(401, map[string]interface{}{
-				"message": "Unauthorized",
-			})
-		}
-
 		// get the name
 		var createTagRequest CreateTagRequest
 		err := c.Bind(&createTagRequest)
@@ -116,12 +106,12 @@ func handleCreateCollection(node *core.LightNode) func(c echo.Context) error {
 				})
 			}
 			bucket = core.Bucket{
-				Status:           "open",
-				Name:             tagName,
-				RequestingApiKey: authParts[1],
-				Uuid:             bucketUuid.String(),
-				CreatedAt:        time.Now(),
-				UpdatedAt:        time.Now(),
+				Status:     "open",
+				Name:       tagName,
+				ApiKeyHash: requestKeyHash(c),
+				Uuid:       bucketUuid.String(),
+				CreatedAt:  time.Now(),
+				UpdatedAt:  time.Now(),
 			}
 			node.DB.Create(&bucket)
 		}
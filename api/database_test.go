@@ -0,0 +1,28 @@
+package api
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/application-research/edge-ur/core"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestNode returns a node with a migrated sqlite database, the given config and a staging dir that are removed
+// after the test, without the ipfs node.
+func newTestNode(t *testing.T, cfg config.EdgeConfig) *core.LightNode {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "edge-urid.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	core.ConfigureModels(db)
+	if cfg.Node.StagingDir == "" {
+		cfg.Node.StagingDir = filepath.Join(dir, "staging")
+	}
+	return &core.LightNode{DB: db, Config: &cfg, Quotas: core.NewQuotaKeeper(db, cfg)}
+}
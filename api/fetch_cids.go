@@ -0,0 +1,121 @@
+package api
+
+import (
+	"time"
+
+	"github.com/application-research/edge-ur/core"
+	"github.com/application-research/edge-ur/jobs"
+	"github.com/ipfs/go-cid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type FetchCidResponse struct {
+	Cid     string `json:"cid"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	FetchID int64  `json:"fetch_id,omitempty"`
+}
+
+// The function `handleFetchCids` queues the fetch of each requested CID. The fetch pulls the whole DAG of the CID
+// from the network and adds it to the collection as a content; its progress is available under
+// `/content/fetch/:id`. A CID that is already being fetched for the same key is not queued twice.
+func handleFetchCids(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		keyHash := requestKeyHash(c)
+		cidBodyReq := CidRequest{}
+		if err := c.Bind(&cidBodyReq); err != nil || len(cidBodyReq.Cids) == 0 {
+			return c.JSON(400, map[string]interface{}{
+				"message": "Please provide the cids to fetch",
+			})
+		}
+
+		collectionName := cidBodyReq.CollectionName
+		if collectionName == "" {
+			collectionName = c.FormValue("collection_name")
+		}
+		if collectionName == "" {
+			collectionName = node.Config.Node.DefaultCollectionName
+		}
+		if _, err := node.PolicyForCollection(collectionName); err != nil {
+			return c.JSON(500, map[string]interface{}{
+				"message": err.Error(),
+			})
+		}
+
+		var fetchCidsResponse []FetchCidResponse
+		for _, cidItem := range cidBodyReq.Cids {
+			fetchCidResponse := FetchCidResponse{
+				Cid: cidItem,
+			}
+			cidDc, err := cid.Decode(cidItem)
+			if err != nil {
+				fetchCidResponse.Status = "error"
+				fetchCidResponse.Message = "Error decoding the CID"
+				fetchCidsResponse = append(fetchCidsResponse, fetchCidResponse)
+				continue
+			}
+
+			var fetch core.CidFetch
+			node.DB.Model(&core.CidFetch{}).Where("cid = ? and collection_name = ? and api_key_hash = ? and status in ?",
+				cidDc.String(), collectionName, keyHash, []string{core.FetchStatusQueued, core.FetchStatusFetching}).Find(&fetch)
+			if fetch.ID == 0 {
+				fetch = core.CidFetch{
+					Cid:            cidDc.String(),
+					CollectionName: collectionName,
+					ApiKeyHash:     keyHash,
+					Status:         core.FetchStatusQueued,
+					CreatedAt:      time.Now(),
+					UpdatedAt:      time.Now(),
+				}
+				// a fetch without its job would stay queued forever
+				err = node.DB.Transaction(func(tx *gorm.DB) error {
+					if err := tx.Create(&fetch).Error; err != nil {
+						return err
+					}
+					return jobs.EnqueueTx(node, tx, jobs.NewCidFetcherProcessor(node, fetch))
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			fetchCidResponse.Status = fetch.Status
+			fetchCidResponse.Message = "CID queued for fetching"
+			fetchCidResponse.FetchID = fetch.ID
+			fetchCidsResponse = append(fetchCidsResponse, fetchCidResponse)
+		}
+		return c.JSON(202, fetchCidsResponse)
+	}
+}
+
+// The function `handleGetCidFetch` returns the status and progress of a fetch.
+func handleGetCidFetch(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var fetch core.CidFetch
+		node.DB.Model(&core.CidFetch{}).Where("id = ? and api_key_hash = ?", c.Param("id"), requestKeyHash(c)).Find(&fetch)
+		if fetch.ID == 0 {
+			return c.JSON(404, map[string]interface{}{
+				"message": "Fetch not found",
+			})
+		}
+		return c.JSON(200, fetch)
+	}
+}
+
+// The function `handleGetCidFetches` lists the fetches of the api key, newest first, optionally filtered by `cid` and
+// `status`.
+func handleGetCidFetches(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		query := node.DB.Model(&core.CidFetch{}).Where("api_key_hash = ?", requestKeyHash(c))
+		if cidParam := c.QueryParam("cid"); cidParam != "" {
+			query = query.Where("cid = ?", cidParam)
+		}
+		if status := c.QueryParam("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+		var fetches []core.CidFetch
+		query.Order("id desc").Limit(1000).Find(&fetches)
+		return c.JSON(200, fetches)
+	}
+}
@@ -16,18 +16,17 @@ import (
 	"time"
 
 	"github.com/application-research/edge-ur/core"
+	"github.com/application-research/edge-ur/utils"
 	"github.com/application-research/whypfs-core"
 	"github.com/ipfs/boxo/ipld/merkledag"
 	"github.com/ipfs/boxo/ipld/unixfs"
 	"github.com/ipfs/go-cid"
-	"github.com/ipfs/go-path"
 	"github.com/labstack/echo/v4"
 
 	"github.com/gabriel-vasile/mimetype"
 	uio "github.com/ipfs/boxo/ipld/unixfs/io"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	mdagipld "github.com/ipfs/go-ipld-format"
-	"github.com/ipfs/go-path/resolver"
 	"golang.org/x/xerrors"
 )
 
@@ -36,11 +35,13 @@ var (
 )
 
 type GatewayHandler struct {
-	bs       blockstore.Blockstore
-	dserv    mdagipld.DAGService
-	resolver resolver.Resolver
-	node     *whypfs.Node
-	db       *gorm.DB
+	bs     blockstore.Blockstore
+	dserv  mdagipld.DAGService
+	node   *whypfs.Node
+	db     *gorm.DB
+	signer *core.UrlSigner
+	keys   *core.ApiKeyHasher
+	quotas *core.QuotaKeeper
 }
 
 func ConfigureGatewayRouter(e *echo.Group, node *core.LightNode) {
@@ -48,12 +49,16 @@ func ConfigureGatewayRouter(e *echo.Group, node *core.LightNode) {
 	//	api
 	gatewayHandler.node = node.Node
 	gatewayHandler.bs = node.Node.Blockstore
+	gatewayHandler.dserv = node.Node.DAGService
 	gatewayHandler.db = node.DB
+	gatewayHandler.signer = node.Signer
+	gatewayHandler.keys = node.Keys
+	gatewayHandler.quotas = node.Quotas
 
-	e.GET("/gw/ipfs/:path", GatewayResolverCheckHandlerDirectPath)
-	e.GET("/gw/:path", GatewayResolverCheckHandlerDirectPath)
+	e.GET("/gw/ipfs/*", GatewayResolverCheckHandlerDirectPath)
+	e.GET("/gw/*", GatewayResolverCheckHandlerDirectPath)
 	e.GET("/gw/content/:contentId", GatewayContentResolverCheckHandler)
-	e.GET("/ipfs/:path", GatewayResolverCheckHandlerDirectPath)
+	e.GET("/ipfs/*", GatewayResolverCheckHandlerDirectPath)
 }
 
 func (gw *GatewayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -187,75 +192,23 @@ func (gw *GatewayHandler) serveUnixfsDir(ctx context.Context, n mdagipld.Node, w
 }
 
 func (gw *GatewayHandler) resolvePath(ctx context.Context, p string) (cid.Cid, error) {
-	proto, _, _, err := gw.parsePath(p) // a sanity check
+	proto, cc, segs, err := gw.parsePath(p)
 	if err != nil {
 		return cid.Undef, fmt.Errorf("failed to parse request path: %w", err)
 	}
-	fmt.Println(p)
-	pp, err := path.ParsePath("/" + p)
-	if err != nil {
-		fmt.Println("2")
-		return cid.Undef, fmt.Errorf("failed to parse request path: %w", err)
-	}
-
-	cc, segs, err := gw.resolver.ResolveToLastNode(ctx, pp)
-	if err != nil {
-		return cid.Undef, err
-	}
 
 	switch proto {
 	case "ipfs":
-		if len(segs) > 0 {
-			return cid.Undef, fmt.Errorf("pathing into ipld nodes not supported")
+		nd, err := resolveUnixfsPath(ctx, gw.dserv, cc, segs)
+		if err != nil {
+			return cid.Undef, err
 		}
-		return cc, nil
+		return nd.Cid(), nil
 	default:
 		return cid.Undef, fmt.Errorf("unsupported protocol: %s", proto)
 	}
 }
-func validate(c echo.Context, node *core.LightNode) error {
-	authorizationString := c.Request().Header.Get("Authorization")
-	authParts := strings.Split(authorizationString, " ")
-
-	response, err := http.Post(
-		node.Config.ExternalApi.AuthSvcUrl+"/check-api-key",
-		"application/json",
-		strings.NewReader(fmt.Sprintf(`{"token": "%s"}`, authParts[1])),
-	)
-
-	if err != nil {
-		log.Errorf("handler error: %s", err)
-		return c.JSON(http.StatusInternalServerError, HttpErrorResponse{
-			Error: HttpError{
-				Code:    http.StatusInternalServerError,
-				Reason:  http.StatusText(http.StatusInternalServerError),
-				Details: err.Error(),
-			},
-		})
-	}
 
-	authResp, err := GetAuthResponse(response)
-	if err != nil {
-		log.Errorf("handler error: %s", err)
-		return c.JSON(http.StatusInternalServerError, HttpErrorResponse{
-			Error: HttpError{
-				Code:    http.StatusInternalServerError,
-				Reason:  http.StatusText(http.StatusInternalServerError),
-				Details: err.Error(),
-			},
-		})
-	}
-	if authResp.Result.Validated == false {
-		return c.JSON(http.StatusUnauthorized, HttpErrorResponse{
-			Error: HttpError{
-				Code:    http.StatusUnauthorized,
-				Reason:  http.StatusText(http.StatusUnauthorized),
-				Details: authResp.Result.Details,
-			},
-		})
-	}
-	return nil
-}
 func (gw *GatewayHandler) parsePath(p string) (string, cid.Cid, []string, error) {
 	parts := strings.Split(strings.Trim(p, "/"), "/")
 	if len(parts) < 2 {
@@ -298,14 +251,23 @@ func (gw *GatewayHandler) GatewayDirResolverCheckHandler(c echo.Context) error {
 }
 
 func GatewayContentResolverCheckHandler(c echo.Context) error {
+	p := c.Param("contentId")
+	if c.QueryParam("sig") != "" {
+		return serveSignedContent(c, p)
+	}
+
 	authorizationString := c.Request().Header.Get("Authorization")
 	authParts := strings.Split(authorizationString, " ")
-
-	p := c.Param("contentId")
+	if len(authParts) != 2 {
+		return c.JSON(http.StatusUnauthorized, map[string]interface{}{
+			"message": "An api key or a signed url is required",
+		})
+	}
 
 	// get the cid from the db
+	keyHash := gatewayHandler.keys.Hash(authParts[1])
 	var content core.Content
-	err := gatewayHandler.db.Model(&content).Where("id = ? and requesting_api_key = ?", p, authParts[1]).First(&content).Error
+	err := gatewayHandler.db.Model(&content).Where("id = ? and api_key_hash = ?", p, keyHash).First(&content).Error
 	if err != nil {
 		return err
 	}
@@ -313,38 +275,164 @@ func GatewayContentResolverCheckHandler(c echo.Context) error {
 		return errors.New("content not found")
 	}
 	fmt.Println("cid: " + content.Cid)
-	c.SetParamNames("path")
-	c.SetParamValues(content.Cid)
+	return serveMetered(c, keyHash, func() error {
+		if content.Encrypted {
+			return serveEncryptedContent(c, content, authParts[1])
+		}
+		c.SetParamNames("path")
+		c.SetParamValues(content.Cid)
+
+		return GatewayResolverCheckHandlerDirectPath(c)
+	})
+}
+
+// serveSignedContent serves a content without an api key when the request carries a signed url of the content that
+// is neither expired nor revoked.
+func serveSignedContent(c echo.Context, contentId string) error {
+	meta, err := gatewayHandler.signer.Verify(gatewayHandler.db, contentId, c.QueryParams(), time.Now())
+	if err != nil {
+		return c.JSON(http.StatusForbidden, map[string]interface{}{
+			"message": err.Error(),
+		})
+	}
+
+	var content core.Content
+	gatewayHandler.db.Model(&core.Content{}).Where("id = ?", contentId).Find(&content)
+	if content.ID == 0 || content.Cid == "" || content.Encrypted {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"message": "Content not found",
+		})
+	}
+	// signed urls are served on the egress of the key that issued them
+	return serveMetered(c, meta.ApiKeyHash, func() error {
+		c.SetParamNames("path")
+		c.SetParamValues(content.Cid)
+
+		return GatewayResolverCheckHandlerDirectPath(c)
+	})
+}
+
+// serveMetered serves a content on the daily gateway egress of its api key, keys that used up their egress get a 429.
+func serveMetered(c echo.Context, keyHash string, serve func() error) error {
+	reset, err := gatewayHandler.quotas.CheckEgress(keyHash)
+	if err == core.ErrQuotaEgress {
+		return tooManyRequests(c, reset, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	writer := &countingResponseWriter{ResponseWriter: c.Response().Writer}
+	c.Response().Writer = writer
+	err = serve()
+	if egressErr := gatewayHandler.quotas.AddEgress(keyHash, writer.written); egressErr != nil {
+		log.Errorf("failed to count the gateway egress: %s", egressErr)
+	}
+	return err
+}
+
+// countingResponseWriter counts the bytes of the response body.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	written int64
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
 
-	return GatewayResolverCheckHandlerDirectPath(c)
+// serveEncryptedContent decrypts an encrypted content on the fly with the data key of its envelope. Ranged reads only
+// decrypt the chunks they cover.
+func serveEncryptedContent(c echo.Context, content core.Content, apiKey string) error {
+	ctx := c.Request().Context()
+	contentCid, err := cid.Decode(content.Cid)
+	if err != nil {
+		return err
+	}
+	dek, envelope, err := core.ContentKey(gatewayHandler.db, content.ID, apiKey)
+	if err != nil {
+		return c.JSON(http.StatusForbidden, map[string]interface{}{
+			"message": "The content can not be decrypted with this api key",
+		})
+	}
+
+	nd, err := gatewayHandler.node.Get(ctx, contentCid)
+	if err != nil {
+		return err
+	}
+	dr, err := uio.NewDagReader(ctx, nd, gatewayHandler.node.DAGService)
+	if err != nil {
+		return err
+	}
+	plain, err := utils.NewDecryptingReadSeeker(dek, dr, envelope.PlainSize)
+	if err != nil {
+		return err
+	}
+
+	err = SniffMimeType(c.Response().Writer, plain)
+	if err != nil {
+		return err
+	}
+	http.ServeContent(c.Response().Writer, c.Request(), content.Name, time.Time{}, plain)
+	return nil
 }
 
-// `GatewayResolverCheckHandlerDirectPath` is a function that takes a `echo.Context` and returns an `error`
+// `GatewayResolverCheckHandlerDirectPath` is a function that takes a `echo.Context` and returns an `error`. The path is a
+// cid followed by the unixfs path of a file or directory under it, a missing path segment is a 404. Requests for the
+// car or raw formats get the blocks instead of the deserialized file.
 func GatewayResolverCheckHandlerDirectPath(c echo.Context) error {
 	ctx := c.Request().Context()
 	p := c.Param("path")
+	if p == "" {
+		p = c.Param("*")
+	}
 	req := c.Request().Clone(c.Request().Context())
 	req.URL.Path = p
 
-	sp := strings.Split(p, "/")
+	sp := strings.Split(strings.Trim(p, "/"), "/")
 	cid, err := cid.Decode(sp[0])
 	if err != nil {
-		return err
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": "Invalid cid in path",
+		})
 	}
-	nd, err := gatewayHandler.node.Get(c.Request().Context(), cid)
+	segments, err := pathSegments(c, sp[1:])
 	if err != nil {
-		return err
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": "Invalid path",
+		})
 	}
 
+	format, err := responseFormat(c)
 	if err != nil {
-		panic(err)
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": err.Error(),
+		})
+	}
+	switch format {
+	case formatCar:
+		return serveCar(c, cid, segments)
+	case formatRaw:
+		return serveRawBlock(c, cid, segments)
+	}
+
+	nd, err := resolveUnixfsPath(ctx, gatewayHandler.node.DAGService, cid, segments)
+	if xerrors.Is(err, os.ErrNotExist) {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		return err
 	}
 
 	switch nd := nd.(type) {
 	case *merkledag.ProtoNode:
 		n, err := unixfs.FSNodeFromBytes(nd.Data())
 		if err != nil {
-			panic(err)
+			return err
 		}
 		if n.IsDir() {
 			return ServeDir(ctx, nd, c.Response().Writer, req)
@@ -367,10 +455,57 @@ func GatewayResolverCheckHandlerDirectPath(c echo.Context) error {
 		return err
 	}
 
-	http.ServeContent(c.Response().Writer, req, cid.String(), time.Time{}, dr)
+	http.ServeContent(c.Response().Writer, req, nd.Cid().String(), time.Time{}, dr)
 	return nil
 }
 
+// pathSegments unescapes the segments of a gateway path, echo hands them over escaped when the request path has
+// escapes of its own.
+func pathSegments(c echo.Context, segments []string) ([]string, error) {
+	if c.Request().URL.RawPath == "" {
+		return segments, nil
+	}
+	unescaped := make([]string, len(segments))
+	for i, segment := range segments {
+		var err error
+		if unescaped[i], err = url.PathUnescape(segment); err != nil {
+			return nil, err
+		}
+	}
+	return unescaped, nil
+}
+
+// resolveUnixfsPath walks the unixfs path from root, through basic and sharded directories alike. A segment that
+// doesn't exist, or that goes into a file, is an os.ErrNotExist.
+func resolveUnixfsPath(ctx context.Context, dserv mdagipld.DAGService, root cid.Cid, segments []string) (mdagipld.Node, error) {
+	nd, err := dserv.Get(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	walked := root.String()
+	for _, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		dir, err := uio.NewDirectoryFromNode(dserv, nd)
+		if err == uio.ErrNotADir {
+			return nil, xerrors.Errorf("%s is not a directory: %w", walked, os.ErrNotExist)
+		}
+		if err != nil {
+			return nil, err
+		}
+		walked = gopath.Join(walked, segment)
+		nd, err = dir.Find(ctx, segment)
+		if xerrors.Is(err, os.ErrNotExist) {
+			return nil, xerrors.Errorf("%s not found: %w", walked, os.ErrNotExist)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nd, nil
+}
+
 type Context struct {
 	CustomLinks []CustomLinks
 }
@@ -431,7 +566,7 @@ func ServeDir(ctx context.Context, n mdagipld.Node, w http.ResponseWriter, req *
 	return nil
 }
 
-func SniffMimeType(w http.ResponseWriter, dr uio.DagReader) error {
+func SniffMimeType(w http.ResponseWriter, dr io.ReadSeeker) error {
 	// see kubo https://github.com/ipfs/kubo/blob/df222053856d3967ff0b4d6bc513bdb66ceedd6f/core/corehttp/gateway_handler_unixfs_file.go
 	// see http ServeContent https://cs.opensource.google/go/go/+/refs/tags/go1.19.2:src/net/http/fs.go;l=221;drc=1f068f0dc7bc997446a7aac44cfc70746ad918e0
 
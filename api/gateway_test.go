@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/application-research/whypfs-core"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	mdutils "github.com/ipfs/boxo/ipld/merkledag/test"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/boxo/ipld/unixfs/hamt"
+	"github.com/ipfs/go-cid"
+	mdagipld "github.com/ipfs/go-ipld-format"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/xerrors"
+)
+
+// testUnixfsTree adds a tree of basic and sharded directories to dserv and returns its root:
+// /docs/a.txt, /docs/shard/file-<n>.txt for n < 300, /readme.txt.
+func testUnixfsTree(t *testing.T, dserv mdagipld.DAGService) (root cid.Cid, files map[string]mdagipld.Node) {
+	t.Helper()
+	ctx := context.Background()
+	files = map[string]mdagipld.Node{}
+	addFile := func(path string, data string) mdagipld.Node {
+		nd := merkledag.NodeWithData(unixfs.FilePBData([]byte(data), uint64(len(data))))
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		files[path] = nd
+		return nd
+	}
+	addDir := func(links map[string]mdagipld.Node) mdagipld.Node {
+		dir := unixfs.EmptyDirNode()
+		for name, nd := range links {
+			if err := dir.AddNodeLink(name, nd); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := dserv.Add(ctx, dir); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	shard, err := hamt.NewShard(dserv, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 300; i++ {
+		name := "file-" + strconv.Itoa(i) + ".txt"
+		if err := shard.Set(ctx, name, addFile("docs/shard/"+name, "sharded "+name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shardNode, err := shard.Node()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dserv.Add(ctx, shardNode); err != nil {
+		t.Fatal(err)
+	}
+
+	docs := addDir(map[string]mdagipld.Node{"a.txt": addFile("docs/a.txt", "a"), "shard": shardNode})
+	return addDir(map[string]mdagipld.Node{"docs": docs, "readme.txt": addFile("readme.txt", "readme")}).Cid(), files
+}
+
+func TestResolveUnixfsPath(t *testing.T) {
+	ctx := context.Background()
+	dserv := mdutils.Mock()
+	root, files := testUnixfsTree(t, dserv)
+
+	for _, path := range []string{"readme.txt", "docs/a.txt", "docs/shard/file-0.txt", "docs/shard/file-299.txt"} {
+		nd, err := resolveUnixfsPath(ctx, dserv, root, strings.Split(path, "/"))
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		if !nd.Cid().Equals(files[path].Cid()) {
+			t.Fatalf("%s resolved to %s, expected %s", path, nd.Cid(), files[path].Cid())
+		}
+	}
+	// empty segments of doubled and trailing slashes are skipped
+	if nd, err := resolveUnixfsPath(ctx, dserv, root, []string{"docs", "", "a.txt", ""}); err != nil || !nd.Cid().Equals(files["docs/a.txt"].Cid()) {
+		t.Fatalf("path with empty segments resolved to %v: %v", nd, err)
+	}
+	if nd, err := resolveUnixfsPath(ctx, dserv, root, nil); err != nil || !nd.Cid().Equals(root) {
+		t.Fatalf("empty path resolved to %v: %v", nd, err)
+	}
+
+	for _, path := range []string{"missing.txt", "docs/missing.txt", "docs/shard/file-300.txt", "readme.txt/a", "docs/a.txt/b/c"} {
+		_, err := resolveUnixfsPath(ctx, dserv, root, strings.Split(path, "/"))
+		if !xerrors.Is(err, os.ErrNotExist) {
+			t.Fatalf("%s resolved with %v, expected it not to exist", path, err)
+		}
+	}
+}
+
+func TestGatewayPathNotFound(t *testing.T) {
+	dserv := mdutils.Mock()
+	root, _ := testUnixfsTree(t, dserv)
+	previous := gatewayHandler.node
+	gatewayHandler.node = &whypfs.Node{DAGService: dserv}
+	t.Cleanup(func() { gatewayHandler.node = previous })
+
+	e := echo.New()
+	e.GET("/gw/*", GatewayResolverCheckHandlerDirectPath)
+	cases := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"docs/shard/file-42.txt", http.StatusOK, "sharded file-42.txt"},
+		{"docs/missing.txt", http.StatusNotFound, ""},
+		{"docs/shard/missing.txt", http.StatusNotFound, ""},
+		{"readme.txt/a", http.StatusNotFound, ""},
+	}
+	for _, tc := range cases {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/gw/"+root.String()+"/"+tc.path, nil))
+		if rec.Code != tc.code {
+			t.Fatalf("%s got %d: %s", tc.path, rec.Code, rec.Body)
+		}
+		if body, _ := io.ReadAll(rec.Body); tc.body != "" && string(body) != tc.body {
+			t.Fatalf("%s served %q", tc.path, body)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/go-cid"
+	mdagipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/xerrors"
+)
+
+// Response formats of the trustless gateway, asked for with the `format` query param or the Accept header.
+const (
+	formatCar = "car"
+	formatRaw = "raw"
+
+	carContentType = "application/vnd.ipld.car"
+	rawContentType = "application/vnd.ipld.raw"
+)
+
+// Scopes of the dag a car response carries, besides the blocks of the path.
+const (
+	dagScopeAll    = "all"    // the whole dag under the end of the path
+	dagScopeEntity = "entity" // the file, or the directory without its entries, at the end of the path
+	dagScopeBlock  = "block"  // only the block at the end of the path
+)
+
+// entityBytes is the byte range of a file a car response carries, from the `entity-bytes` query param. Negative
+// offsets are from the end of the file, a nil to is the end of the file.
+type entityBytes struct {
+	from int64
+	to   *int64
+}
+
+// responseFormat returns the trustless format a gateway request asks for, or "" for deserialized unixfs.
+func responseFormat(c echo.Context) (string, error) {
+	switch format := c.QueryParam("format"); format {
+	case formatCar, formatRaw:
+		return format, nil
+	case "":
+	default:
+		return "", xerrors.Errorf("unsupported format %q, use %s or %s", format, formatCar, formatRaw)
+	}
+
+	for _, accepted := range strings.Split(c.Request().Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case carContentType:
+			if version, ok := params["version"]; ok && version != "1" {
+				return "", xerrors.Errorf("only version 1 cars are supported")
+			}
+			return formatCar, nil
+		case rawContentType:
+			return formatRaw, nil
+		}
+	}
+	return "", nil
+}
+
+// parseEntityBytes parses an `entity-bytes` query param of the form from:to, where to can be `*`.
+func parseEntityBytes(param string) (*entityBytes, error) {
+	if param == "" {
+		return nil, nil
+	}
+	parts := strings.Split(param, ":")
+	if len(parts) != 2 {
+		return nil, xerrors.Errorf("invalid entity-bytes %q, use from:to", param)
+	}
+	from, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid entity-bytes from %q", parts[0])
+	}
+	byteRange := &entityBytes{from: from}
+	if parts[1] != "*" {
+		to, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid entity-bytes to %q", parts[1])
+		}
+		if from >= 0 && to >= 0 && to < from {
+			return nil, xerrors.Errorf("invalid entity-bytes %q, to is before from", param)
+		}
+		byteRange.to = &to
+	}
+	return byteRange, nil
+}
+
+// resolve returns the offsets of the range in a file of size bytes, inclusive. ok is false when the range holds no
+// byte of the file.
+func (b *entityBytes) resolve(size int64) (from int64, to int64, ok bool) {
+	from = b.from
+	if from < 0 {
+		from = size + from
+		if from < 0 {
+			from = 0
+		}
+	}
+	to = size - 1
+	if b.to != nil {
+		to = *b.to
+		if to < 0 {
+			to = size + to
+		}
+		if to > size-1 {
+			to = size - 1
+		}
+	}
+	return from, to, from < size && from <= to
+}
+
+// serveRawBlock serves the block at the end of a gateway path as is.
+func serveRawBlock(c echo.Context, root cid.Cid, segments []string) error {
+	nd, err := resolveUnixfsPath(c.Request().Context(), gatewayHandler.node.DAGService, root, segments)
+	if xerrors.Is(err, os.ErrNotExist) {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, rawContentType)
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"%s.bin\"", nd.Cid()))
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Etag", fmt.Sprintf("\"%s.raw\"", nd.Cid()))
+	setTrustlessHeaders(c)
+	http.ServeContent(c.Response().Writer, c.Request(), "", time.Time{}, bytes.NewReader(nd.RawData()))
+	return nil
+}
+
+// serveCar serves the blocks of a gateway path and the dag-scope of its end as a CARv1 rooted at the cid of the path.
+// The blocks are written depth first in the order a client verifies them, every block once.
+func serveCar(c echo.Context, root cid.Cid, segments []string) error {
+	ctx := c.Request().Context()
+	scope := c.QueryParam("dag-scope")
+	switch scope {
+	case "":
+		scope = dagScopeAll
+	case dagScopeAll, dagScopeEntity, dagScopeBlock:
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": fmt.Sprintf("Invalid dag-scope %q, use %s, %s or %s", scope, dagScopeAll, dagScopeEntity, dagScopeBlock),
+		})
+	}
+	byteRange, err := parseEntityBytes(c.QueryParam("entity-bytes"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"message": err.Error(),
+		})
+	}
+
+	// the path is resolved before anything is written, so a missing segment can still be a 404
+	dserv := gatewayHandler.node.DAGService
+	pathBlocks := &recordingDAGService{DAGService: dserv}
+	nd, err := resolveUnixfsPath(ctx, pathBlocks, root, segments)
+	if xerrors.Is(err, os.ErrNotExist) {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{
+			"message": err.Error(),
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, carContentType+"; version=1; order=dfs; dups=n")
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"%s.car\"", root))
+	header.Set("X-Content-Type-Options", "nosniff")
+	setTrustlessHeaders(c)
+	c.Response().WriteHeader(http.StatusOK)
+
+	w := &carBlockWriter{w: c.Response().Writer, written: cid.NewSet()}
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{root}, Version: 1}, w.w); err != nil {
+		return err
+	}
+	for _, block := range pathBlocks.blocks {
+		if err := w.write(block); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case scope == dagScopeBlock:
+		return nil
+	case scope == dagScopeEntity && byteRange != nil:
+		return writeFileRange(ctx, dserv, w, nd, byteRange)
+	case scope == dagScopeEntity:
+		return writeEntity(ctx, dserv, w, nd)
+	default:
+		return writeDag(ctx, dserv, w, nd)
+	}
+}
+
+// setTrustlessHeaders sets the headers the car and raw responses share. The responses only depend on cids, so they
+// can be cached for good.
+func setTrustlessHeaders(c echo.Context) {
+	header := c.Response().Header()
+	header.Set("X-Ipfs-Path", c.Request().URL.Path)
+	header.Set(echo.HeaderVary, echo.HeaderAccept)
+	header.Set("Cache-Control", "public, max-age=29030400, immutable")
+}
+
+// recordingDAGService keeps the nodes it gets, in order, so the blocks a path was resolved through can be sent along.
+type recordingDAGService struct {
+	mdagipld.DAGService
+	blocks []mdagipld.Node
+}
+
+func (r *recordingDAGService) Get(ctx context.Context, c cid.Cid) (mdagipld.Node, error) {
+	nd, err := r.DAGService.Get(ctx, c)
+	if err == nil {
+		r.blocks = append(r.blocks, nd)
+	}
+	return nd, err
+}
+
+// carBlockWriter writes the blocks of a car, skipping blocks it already wrote.
+type carBlockWriter struct {
+	w       io.Writer
+	written *cid.Set
+}
+
+func (w *carBlockWriter) write(nd mdagipld.Node) error {
+	if !w.written.Visit(nd.Cid()) {
+		return nil
+	}
+	return util.LdWrite(w.w, nd.Cid().Bytes(), nd.RawData())
+}
+
+// writeDag writes the whole dag under nd. nd may already be written as the end of the path, below it a block that was
+// already written had its dag written with it, so a dag shared by several links is only walked once.
+func writeDag(ctx context.Context, dserv mdagipld.DAGService, w *carBlockWriter, nd mdagipld.Node) error {
+	if err := w.write(nd); err != nil {
+		return err
+	}
+	for _, link := range nd.Links() {
+		if w.written.Has(link.Cid) {
+			continue
+		}
+		child, err := dserv.Get(ctx, link.Cid)
+		if err != nil {
+			return err
+		}
+		if err := writeDag(ctx, dserv, w, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEntity writes what it takes to read the unixfs entity nd: every block of a file, the shards of a sharded
+// directory, the node alone for anything else.
+func writeEntity(ctx context.Context, dserv mdagipld.DAGService, w *carBlockWriter, nd mdagipld.Node) error {
+	protoNode, ok := nd.(*merkledag.ProtoNode)
+	if !ok {
+		return w.write(nd)
+	}
+	fsNode, err := unixfs.FSNodeFromBytes(protoNode.Data())
+	if err != nil {
+		return w.write(nd)
+	}
+	switch fsNode.Type() {
+	case unixfs.TFile, unixfs.TRaw:
+		return writeDag(ctx, dserv, w, nd)
+	case unixfs.THAMTShard:
+		return writeShards(ctx, dserv, w, protoNode, fsNode)
+	default:
+		return w.write(nd)
+	}
+}
+
+// writeShards writes the shard nodes of a sharded directory, the links of a shard to its sub-shards are named only
+// with the padded hex index, the links to entries add the name of the entry.
+func writeShards(ctx context.Context, dserv mdagipld.DAGService, w *carBlockWriter, nd *merkledag.ProtoNode, fsNode *unixfs.FSNode) error {
+	if err := w.write(nd); err != nil {
+		return err
+	}
+	padLength := len(fmt.Sprintf("%X", fsNode.Fanout()-1))
+	for _, link := range nd.Links() {
+		if len(link.Name) != padLength {
+			continue
+		}
+		child, err := dserv.Get(ctx, link.Cid)
+		if err != nil {
+			return err
+		}
+		childProto, ok := child.(*merkledag.ProtoNode)
+		if !ok {
+			return xerrors.Errorf("shard %s is not a dag-pb node", link.Cid)
+		}
+		childFs, err := unixfs.FSNodeFromBytes(childProto.Data())
+		if err != nil {
+			return err
+		}
+		if err := writeShards(ctx, dserv, w, childProto, childFs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileRange writes the blocks of the file nd that hold the bytes of the range, entities that are not files are
+// written whole.
+func writeFileRange(ctx context.Context, dserv mdagipld.DAGService, w *carBlockWriter, nd mdagipld.Node, byteRange *entityBytes) error {
+	size := int64(len(nd.RawData()))
+	if protoNode, ok := nd.(*merkledag.ProtoNode); ok {
+		fsNode, err := unixfs.FSNodeFromBytes(protoNode.Data())
+		if err != nil || (fsNode.Type() != unixfs.TFile && fsNode.Type() != unixfs.TRaw) {
+			return writeEntity(ctx, dserv, w, nd)
+		}
+		size = int64(fsNode.FileSize())
+	}
+	from, to, ok := byteRange.resolve(size)
+	if !ok {
+		return w.write(nd)
+	}
+	return writeFileBlocks(ctx, dserv, w, nd, 0, from, to)
+}
+
+// writeFileBlocks writes the node of a file that starts at offset, and the children holding bytes from from to to.
+func writeFileBlocks(ctx context.Context, dserv mdagipld.DAGService, w *carBlockWriter, nd mdagipld.Node, offset int64, from int64, to int64) error {
+	if err := w.write(nd); err != nil {
+		return err
+	}
+	protoNode, ok := nd.(*merkledag.ProtoNode)
+	if !ok {
+		return nil
+	}
+	fsNode, err := unixfs.FSNodeFromBytes(protoNode.Data())
+	if err != nil {
+		return err
+	}
+	// the data of the node itself comes before the data of its children
+	offset += int64(len(fsNode.Data()))
+	for i, link := range nd.Links() {
+		if i >= fsNode.NumChildren() {
+			break
+		}
+		childSize := int64(fsNode.BlockSize(i))
+		if offset <= to && offset+childSize > from {
+			child, err := dserv.Get(ctx, link.Cid)
+			if err != nil {
+				return err
+			}
+			if err := writeFileBlocks(ctx, dserv, w, child, offset, from, to); err != nil {
+				return err
+			}
+		}
+		offset += childSize
+	}
+	return nil
+}
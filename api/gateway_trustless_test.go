@@ -0,0 +1,76 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	mdutils "github.com/ipfs/boxo/ipld/merkledag/test"
+	"github.com/ipfs/go-cid"
+	mdagipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipld/go-car"
+)
+
+// countingDAGService counts the nodes it is asked for.
+type countingDAGService struct {
+	mdagipld.DAGService
+	gets map[cid.Cid]int
+}
+
+func (c *countingDAGService) Get(ctx context.Context, k cid.Cid) (mdagipld.Node, error) {
+	c.gets[k]++
+	return c.DAGService.Get(ctx, k)
+}
+
+func TestWriteDagWalksSharedDagsOnce(t *testing.T) {
+	ctx := context.Background()
+	dserv := &countingDAGService{DAGService: mdutils.Mock(), gets: make(map[cid.Cid]int)}
+
+	leaf := merkledag.NewRawNode([]byte("shared leaf"))
+	shared := merkledag.NodeWithData([]byte("shared"))
+	if err := shared.AddNodeLink("leaf", leaf); err != nil {
+		t.Fatal(err)
+	}
+	root := merkledag.NodeWithData([]byte("root"))
+	for _, name := range []string{"a", "b", "c"} {
+		if err := root.AddNodeLink(name, shared); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dserv.AddMany(ctx, []mdagipld.Node{leaf, shared, root}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w := &carBlockWriter{w: &buf, written: cid.NewSet()}
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{root.Cid()}, Version: 1}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	// the end of the path is written before its dag
+	if err := w.write(root); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeDag(ctx, dserv, w, root); err != nil {
+		t.Fatal(err)
+	}
+
+	if dserv.gets[shared.Cid()] != 1 || dserv.gets[leaf.Cid()] != 1 {
+		t.Fatalf("walked the shared dag %d times and its leaf %d times", dserv.gets[shared.Cid()], dserv.gets[leaf.Cid()])
+	}
+	cr, err := car.NewCarReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks []cid.Cid
+	for {
+		blk, err := cr.Next()
+		if err != nil {
+			break
+		}
+		blocks = append(blocks, blk.Cid())
+	}
+	if len(blocks) != 3 || !blocks[0].Equals(root.Cid()) || !blocks[1].Equals(shared.Cid()) || !blocks[2].Equals(leaf.Cid()) {
+		t.Fatalf("wrote the blocks %v", blocks)
+	}
+}
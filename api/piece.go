@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/application-research/edge-ur/core"
+	"github.com/application-research/edge-ur/jobs"
+	"github.com/labstack/echo/v4"
+)
+
+// ConfigurePieceRouter configures the piece store routes. Storage providers pull the exact car bytes that the piece
+// commitment was computed over, either by piece cid or by bucket uuid.
+func ConfigurePieceRouter(e *echo.Group, node *core.LightNode) {
+	e.GET("/piece/:pieceCid", handleGetPiece(node))
+	e.HEAD("/piece/:pieceCid", handleGetPiece(node))
+	e.GET("/buckets/:uuid/car", handleGetBucketCar(node))
+	e.HEAD("/buckets/:uuid/car", handleGetBucketCar(node))
+}
+
+// The function `handleGetPiece` serves the car file of the bucket with the given piece cid.
+func handleGetPiece(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var bucket core.Bucket
+		node.DB.Model(&core.Bucket{}).Where("piece_cid = ? and status <> ?", c.Param("pieceCid"), "deleted").First(&bucket)
+		if bucket.ID == 0 {
+			return c.JSON(404, map[string]interface{}{
+				"message": "Piece not found. Please check if the piece cid is valid",
+			})
+		}
+		return servePieceCar(c, node, bucket)
+	}
+}
+
+// The function `handleGetBucketCar` serves the car file of the bucket with the given uuid.
+func handleGetBucketCar(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var bucket core.Bucket
+		node.DB.Model(&core.Bucket{}).Where("uuid = ? and status <> ?", c.Param("uuid"), "deleted").First(&bucket)
+		if bucket.ID == 0 || bucket.PieceCid == "" {
+			return c.JSON(404, map[string]interface{}{
+				"message": "Bucket car not found. Please check if the bucket id is valid and the bucket is ready",
+			})
+		}
+		return servePieceCar(c, node, bucket)
+	}
+}
+
+// servePieceCar streams the car file of a bucket. http.ServeContent takes care of HEAD, range requests and
+// If-Range, so interrupted transfers can be resumed.
+func servePieceCar(c echo.Context, node *core.LightNode, bucket core.Bucket) error {
+	carFile, err := os.Open(jobs.BucketCarPath(node, bucket.Uuid))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c.JSON(404, map[string]interface{}{
+				"message": "Car file for the piece is not available on this node",
+			})
+		}
+		return err
+	}
+	defer carFile.Close()
+
+	stat, err := carFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	w := c.Response().Writer
+	w.Header().Set("Content-Type", "application/vnd.ipld.car")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+bucket.PieceCid+".car\"")
+	w.Header().Set("ETag", "\""+bucket.PieceCid+"\"")
+	w.Header().Set("Accept-Ranges", "bytes")
+	http.ServeContent(w, c.Request(), bucket.PieceCid+".car", stat.ModTime(), carFile)
+	return nil
+}
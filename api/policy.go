@@ -0,0 +1,182 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/application-research/edge-ur/core"
+	"github.com/labstack/echo/v4"
+)
+
+type PolicyRequest struct {
+	Name            string `json:"name"`
+	MinBucketSize   int64  `json:"min_bucket_size"`
+	BucketSize      int64  `json:"bucket_size"`
+	MaxBucketAge    int64  `json:"max_bucket_age"` // seconds
+	TargetPieceSize int64  `json:"target_piece_size"`
+	SplitSize       int64  `json:"split_size"`
+
+	DealDurationInDays int64  `json:"deal_duration_in_days"`
+	DealVerifyState    string `json:"deal_verify_state"`
+	RemoveUnsealedCopy *bool  `json:"remove_unsealed_copy"` // left out uses DELTA_REMOVE_UNSEALED_COPY
+	AutoRetry          *bool  `json:"auto_retry"`           // left out uses DELTA_AUTO_RETRY
+	ReplicationFactor  int    `json:"replication_factor"`
+}
+
+// ConfigurePolicyRouter configures the routes to manage the policies of the collections. Anyone with an api key can
+// read the policies, changing them takes the admin api key.
+func ConfigurePolicyRouter(e *echo.Group, node *core.LightNode) {
+	policies := e.Group("/policies", scopeByMethod(core.ScopeRead, core.ScopeAdmin))
+	policies.GET("", handleListPolicies(node))
+	policies.GET("/:id", handleGetPolicy(node))
+	policies.POST("", handleCreatePolicy(node))
+	policies.PUT("/:id", handleUpdatePolicy(node))
+	policies.DELETE("/:id", handleDeletePolicy(node))
+}
+
+// The function `handleListPolicies` returns all the policies.
+func handleListPolicies(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var policies []core.Policy
+		node.DB.Model(&core.Policy{}).Order("id asc").Find(&policies)
+		return c.JSON(200, policies)
+	}
+}
+
+// The function `handleGetPolicy` returns a single policy by id.
+func handleGetPolicy(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		policy, err := findPolicy(node, c.Param("id"))
+		if err != nil {
+			return c.JSON(404, map[string]interface{}{
+				"message": "Policy not found",
+			})
+		}
+		return c.JSON(200, policy)
+	}
+}
+
+// The function `handleCreatePolicy` creates the policy of a collection that does not have one yet.
+func handleCreatePolicy(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var req PolicyRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(400, map[string]interface{}{
+				"message": "Invalid policy request: " + err.Error(),
+			})
+		}
+
+		policy := core.Policy{CreatedAt: time.Now()}
+		applyPolicyRequest(&policy, req)
+		if err := policy.Validate(); err != nil {
+			return c.JSON(400, map[string]interface{}{
+				"message": err.Error(),
+			})
+		}
+
+		var existing int64
+		node.DB.Model(&core.Policy{}).Where("name = ?", policy.Name).Count(&existing)
+		if existing > 0 {
+			return c.JSON(409, map[string]interface{}{
+				"message": "Policy for collection " + policy.Name + " already exist",
+			})
+		}
+
+		if err := node.DB.Create(&policy).Error; err != nil {
+			return err
+		}
+		return c.JSON(200, policy)
+	}
+}
+
+// The function `handleUpdatePolicy` replaces the settings of a policy. Open buckets pick up the new settings the next
+// time the aggregator runs for them.
+func handleUpdatePolicy(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		policy, err := findPolicy(node, c.Param("id"))
+		if err != nil {
+			return c.JSON(404, map[string]interface{}{
+				"message": "Policy not found",
+			})
+		}
+
+		var req PolicyRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(400, map[string]interface{}{
+				"message": "Invalid policy request: " + err.Error(),
+			})
+		}
+		if req.Name == "" {
+			req.Name = policy.Name
+		}
+		if req.Name != policy.Name {
+			return c.JSON(400, map[string]interface{}{
+				"message": "The collection of a policy can not be changed",
+			})
+		}
+
+		applyPolicyRequest(&policy, req)
+		if err := policy.Validate(); err != nil {
+			return c.JSON(400, map[string]interface{}{
+				"message": err.Error(),
+			})
+		}
+		if err := node.DB.Save(&policy).Error; err != nil {
+			return err
+		}
+		return c.JSON(200, policy)
+	}
+}
+
+// The function `handleDeletePolicy` deletes a policy that no open bucket uses anymore.
+func handleDeletePolicy(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		policy, err := findPolicy(node, c.Param("id"))
+		if err != nil {
+			return c.JSON(404, map[string]interface{}{
+				"message": "Policy not found",
+			})
+		}
+
+		var openBuckets int64
+		node.DB.Model(&core.Bucket{}).Where("policy_id = ? and status = ?", policy.ID, "open").Count(&openBuckets)
+		if openBuckets > 0 {
+			return c.JSON(409, map[string]interface{}{
+				"message": "Policy is still used by open buckets",
+			})
+		}
+
+		if err := node.DB.Delete(&policy).Error; err != nil {
+			return err
+		}
+		return c.JSON(200, map[string]interface{}{
+			"message": "Policy deleted",
+			"policy":  policy.ID,
+		})
+	}
+}
+
+func findPolicy(node *core.LightNode, id string) (core.Policy, error) {
+	var policy core.Policy
+	policyId, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return policy, err
+	}
+	err = node.DB.Model(&core.Policy{}).Where("id = ?", policyId).First(&policy).Error
+	return policy, err
+}
+
+func applyPolicyRequest(policy *core.Policy, req PolicyRequest) {
+	policy.Name = req.Name
+	policy.MinBucketSize = req.MinBucketSize
+	policy.BucketSize = req.BucketSize
+	policy.MaxBucketAge = req.MaxBucketAge
+	policy.TargetPieceSize = req.TargetPieceSize
+	policy.SplitSize = req.SplitSize
+	policy.DealDurationInDays = req.DealDurationInDays
+	policy.DealVerifyState = req.DealVerifyState
+	policy.RemoveUnsealedCopy = req.RemoveUnsealedCopy
+	policy.AutoRetry = req.AutoRetry
+	policy.ReplicationFactor = req.ReplicationFactor
+	policy.UpdatedAt = time.Now()
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/application-research/edge-ur/core"
+	"github.com/labstack/echo/v4"
+)
+
+type QuotaResponse struct {
+	Quota  core.KeyQuota    `json:"quota"`
+	Limits core.QuotaLimits `json:"limits"`
+}
+
+// SetQuotaRequest sets the limits of the key given by `api_key` or `key_hash`. Limits left out fall back to the
+// config, 0 means no limit.
+type SetQuotaRequest struct {
+	ApiKey            string `json:"api_key"`
+	KeyHash           string `json:"key_hash"`
+	MaxBytes          *int64 `json:"max_bytes"`
+	MaxObjects        *int64 `json:"max_objects"`
+	UploadsPerMinute  *int64 `json:"uploads_per_minute"`
+	EgressBytesPerDay *int64 `json:"egress_bytes_per_day"`
+}
+
+func ConfigureQuotaRouter(e *echo.Group, node *core.LightNode) {
+	e.GET("/quota", handleGetOwnQuota(node), requireScope(core.ScopeRead))
+
+	quotas := e.Group("/quotas", requireScope(core.ScopeAdmin))
+	quotas.GET("", handleListQuotas(node))
+	quotas.PUT("", handleSetQuota(node))
+}
+
+// uploadLimits answers 429 to the uploads of a key that is over its upload rate or its storage quota. The rate limit
+// headers are set on every upload of a rate limited key.
+func uploadLimits(node *core.LightNode) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			keyHash := requestKeyHash(c)
+			rateLimit, allowed, err := node.Quotas.AllowUpload(keyHash)
+			if err != nil {
+				return err
+			}
+			if rateLimit.Limit > 0 {
+				header := c.Response().Header()
+				header.Set("X-RateLimit-Limit", strconv.FormatInt(rateLimit.Limit, 10))
+				header.Set("X-RateLimit-Remaining", strconv.FormatInt(rateLimit.Remaining, 10))
+				header.Set("X-RateLimit-Reset", strconv.FormatInt(rateLimit.Reset.Unix(), 10))
+			}
+			if !allowed {
+				return tooManyRequests(c, rateLimit.Reset, "Upload rate limit of "+strconv.FormatInt(rateLimit.Limit, 10)+" per minute reached")
+			}
+
+			if err := node.Quotas.CheckStorage(keyHash, uploadSize(c)); err != nil {
+				if err == core.ErrQuotaBytes || err == core.ErrQuotaObjects {
+					return tooManyRequests(c, time.Time{}, err.Error())
+				}
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+// uploadSize returns the size of an upload when the request tells it, the Upload-Length of a resumable upload or the
+// size of the files of a form. It is 0 for the uploads whose size is only known once they are fetched.
+func uploadSize(c echo.Context) int64 {
+	if length := c.Request().Header.Get("Upload-Length"); length != "" {
+		size, _ := strconv.ParseInt(length, 10, 64)
+		return size
+	}
+	if !strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		return 0
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return 0 // the handler answers the broken form
+	}
+	var size int64
+	for _, files := range form.File {
+		for _, file := range files {
+			size += file.Size
+		}
+	}
+	return size
+}
+
+// tooManyRequests answers 429, with a Retry-After when the limit is lifted at reset.
+func tooManyRequests(c echo.Context, reset time.Time, details string) error {
+	if !reset.IsZero() {
+		retryAfter := int64(time.Until(reset).Seconds()) + 1
+		c.Response().Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	}
+	return c.JSON(http.StatusTooManyRequests, HttpErrorResponse{
+		Error: HttpError{
+			Code:    http.StatusTooManyRequests,
+			Reason:  http.StatusText(http.StatusTooManyRequests),
+			Details: details,
+		},
+	})
+}
+
+// The function `handleGetOwnQuota` returns the usage and the limits of the api key of the request.
+func handleGetOwnQuota(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		quota, err := node.Quotas.Quota(requestKeyHash(c))
+		if err != nil {
+			return err
+		}
+		return c.JSON(200, QuotaResponse{Quota: quota, Limits: node.Quotas.Limits(quota)})
+	}
+}
+
+// The function `handleListQuotas` lists the quotas of all the api keys that used the node.
+func handleListQuotas(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var quotas []core.KeyQuota
+		node.DB.Model(&core.KeyQuota{}).Order("id asc").Find(&quotas)
+
+		responses := make([]QuotaResponse, 0, len(quotas))
+		for _, quota := range quotas {
+			responses = append(responses, QuotaResponse{Quota: quota, Limits: node.Quotas.Limits(quota)})
+		}
+		return c.JSON(200, responses)
+	}
+}
+
+// The function `handleSetQuota` sets the limits of an api key.
+func handleSetQuota(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var req SetQuotaRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(400, map[string]interface{}{
+				"message": "Invalid quota request: " + err.Error(),
+			})
+		}
+		keyHash := req.KeyHash
+		if req.ApiKey != "" {
+			keyHash = node.Keys.Hash(req.ApiKey)
+		}
+		if keyHash == "" {
+			return c.JSON(400, map[string]interface{}{
+				"message": "Please provide the api_key or the key_hash of the quota",
+			})
+		}
+		for _, limit := range []*int64{req.MaxBytes, req.MaxObjects, req.UploadsPerMinute, req.EgressBytesPerDay} {
+			if limit != nil && *limit < 0 {
+				return c.JSON(400, map[string]interface{}{
+					"message": "Limits can not be negative, use 0 for no limit",
+				})
+			}
+		}
+
+		quota, err := node.Quotas.SetLimits(keyHash, req.MaxBytes, req.MaxObjects, req.UploadsPerMinute, req.EgressBytesPerDay)
+		if err != nil {
+			return err
+		}
+		return c.JSON(200, QuotaResponse{Quota: quota, Limits: node.Quotas.Limits(quota)})
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/application-research/edge-ur/core"
+	"github.com/labstack/echo/v4"
+)
+
+func newUploadLimitsTest(t *testing.T, cfg config.EdgeConfig) *echo.Echo {
+	t.Helper()
+	node := newTestNode(t, cfg)
+	e := echo.New()
+	e.POST("/upload", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(authResultKey, core.AuthResult{Validated: true, Role: core.RoleUploader, KeyHash: "key"})
+			return next(c)
+		}
+	}, uploadLimits(node))
+	return e
+}
+
+func TestUploadLimitsRateHeaders(t *testing.T) {
+	var cfg config.EdgeConfig
+	cfg.Quota.UploadsPerMinute = 1
+	e := newUploadLimitsTest(t, cfg)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/upload", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Limit") != "1" || rec.Header().Get("X-RateLimit-Remaining") != "0" ||
+		rec.Header().Get("X-RateLimit-Reset") == "" {
+		t.Fatalf("first upload got %d with %v", rec.Code, rec.Header())
+	}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/upload", nil))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("upload past the rate limit got %d with %v", rec.Code, rec.Header())
+	}
+}
+
+func TestUploadLimitsCheckUploadSize(t *testing.T) {
+	var cfg config.EdgeConfig
+	cfg.Common.CapacityLimitPerKeyInBytes = 10
+	e := newUploadLimitsTest(t, cfg)
+
+	for _, tc := range []struct {
+		length string
+		code   int
+	}{{"10", http.StatusOK}, {"11", http.StatusTooManyRequests}} {
+		req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+		req.Header.Set("Upload-Length", tc.length)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tc.code {
+			t.Fatalf("upload of %s bytes got %d", tc.length, rec.Code)
+		}
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("data", "large.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(make([]byte, 11))
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set(echo.HeaderContentType, mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("form upload past the quota got %d", rec.Code)
+	}
+}
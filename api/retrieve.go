@@ -10,7 +10,7 @@ func ConfigureRetrieveRouter(e *echo.Group, node *core.LightNode) {
 
 	e.GET("/retrieve/split", func(c echo.Context) error {
 		return RetrieveSplitHandler(c, node)
-	})
+	}, requireScope(core.ScopeRead))
 }
 
 // RetrieveSplitHandler is the handler for the /retrieve/split endpoint
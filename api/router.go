@@ -1,14 +1,11 @@
 package api
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
-	"time"
 
 	"github.com/application-research/edge-ur/core"
 	logging "github.com/ipfs/go-log/v2"
@@ -39,32 +36,11 @@ type HttpErrorResponse struct {
 	Error HttpError `json:"error"`
 }
 
-type AuthResponse struct {
-	Result struct {
-		Validated bool   `json:"validated"`
-		Details   string `json:"details"`
-	} `json:"result"`
-}
-
+// GetDefaultTagPolicy makes sure the default collection has a policy. An existing policy is kept as is, so changes made
+// through the policy api survive restarts.
 func GetDefaultTagPolicy(ln *core.LightNode) error {
-
-	// remove the current default tag policy
-	if err := ln.DB.Model(&core.Policy{}).Where("name = ?", ln.Config.Node.DefaultCollectionName).Delete(&core.Policy{}).Error; err != nil {
-		return xerrors.Errorf("failed to remove default tag policy: %w", err)
-	}
-
-	// create a new default tag policy
-	newTagPolicy := core.Policy{
-		Name:       ln.Config.Node.DefaultCollectionName,
-		BucketSize: ln.Config.Common.BucketAggregateSize,
-		SplitSize:  ln.Config.Common.SplitSize,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
-	}
-
-	ln.DB.Model(&core.Policy{}).Create(&newTagPolicy)
-
-	return nil
+	_, err := ln.PolicyForCollection(ln.Config.Node.DefaultCollectionName)
+	return err
 }
 
 // RouterConfig configures the API node
@@ -79,6 +55,8 @@ func InitializeEchoRouterConfig(ln *core.LightNode) {
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"*"},
+		// resumable upload clients read these from the responses
+		ExposeHeaders: []string{"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length", "Upload-Metadata", "Upload-Expires"},
 	}))
 	e.Pre(middleware.RemoveTrailingSlash())
 	e.HTTPErrorHandler = ErrorHandler
@@ -90,67 +68,15 @@ func InitializeEchoRouterConfig(ln *core.LightNode) {
 	ConfigureNodeInfoRouter(defaultOpenRoute, ln)
 
 	apiGroup := e.Group("/api/v1")
-	apiGroup.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			authorizationString := c.Request().Header.Get("Authorization")
-			authParts := strings.Split(authorizationString, " ")
-
-			if len(authParts) != 2 {
-				return c.JSON(http.StatusInternalServerError, HttpErrorResponse{
-					Error: HttpError{
-						Code:    http.StatusInternalServerError,
-						Reason:  http.StatusText(http.StatusInternalServerError),
-						Details: "Invalid Authorization Header",
-					},
-				})
-			}
-			response, err := http.Post(
-				ln.Config.ExternalApi.AuthSvcUrl+"/check-api-key",
-				"application/json",
-				strings.NewReader(fmt.Sprintf(`{"token": "%s"}`, authParts[1])),
-			)
-
-			if err != nil {
-				log.Errorf("handler error: %s", err)
-				return c.JSON(http.StatusInternalServerError, HttpErrorResponse{
-					Error: HttpError{
-						Code:    http.StatusInternalServerError,
-						Reason:  http.StatusText(http.StatusInternalServerError),
-						Details: err.Error(),
-					},
-				})
-			}
-
-			authResp, err := GetAuthResponse(response)
-			if err != nil {
-				log.Errorf("handler error: %s", err)
-				return c.JSON(http.StatusInternalServerError, HttpErrorResponse{
-					Error: HttpError{
-						Code:    http.StatusInternalServerError,
-						Reason:  http.StatusText(http.StatusInternalServerError),
-						Details: err.Error(),
-					},
-				})
-			}
-
-			if authResp.Result.Validated == false {
-				return c.JSON(http.StatusUnauthorized, HttpErrorResponse{
-					Error: HttpError{
-						Code:    http.StatusUnauthorized,
-						Reason:  http.StatusText(http.StatusUnauthorized),
-						Details: authResp.Result.Details,
-					},
-				})
-			}
-			if authResp.Result.Validated == true {
-				return next(c)
-			}
-			return next(c)
-		}
-	})
+	apiGroup.Use(authMiddleware(ln))
 	ConfigureRetrieveRouter(apiGroup, ln)
 	ConfigureUploadRouter(apiGroup, ln)
+	ConfigurePolicyRouter(apiGroup, ln)
+	ConfigureBucketClaimsRouter(apiGroup, ln)
+	ConfigureUploadsRouter(apiGroup, ln)
+	ConfigureQuotaRouter(apiGroup, ln)
 	ConfigureBucketsRouter(defaultOpenRoute, ln)
+	ConfigurePieceRouter(defaultOpenRoute, ln)
 	ConfigureCollectionsRouter(defaultOpenRoute, ln)
 	ConfigureStatusCheckRouter(apiGroup, ln)
 	ConfigureStatusOpenCheckRouter(defaultOpenRoute, ln)
@@ -161,27 +87,6 @@ func InitializeEchoRouterConfig(ln *core.LightNode) {
 	e.Logger.Fatal(e.Start(addrPort)) // configuration
 }
 
-func GetAuthResponse(resp *http.Response) (AuthResponse, error) {
-
-	jsonBody := AuthResponse{}
-	err := json.NewDecoder(resp.Body).Decode(&jsonBody)
-	if err != nil {
-
-		log.Error("empty json body")
-		return AuthResponse{
-			Result: struct {
-				Validated bool   `json:"validated"`
-				Details   string `json:"details"`
-			}{
-				Validated: false,
-				Details:   "empty json body",
-			},
-		}, nil
-	}
-
-	return jsonBody, nil
-}
-
 func ErrorHandler(err error, c echo.Context) {
 	var httpRespErr *HttpError
 	if xerrors.As(err, &httpRespErr) {
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"time"
+
+	"github.com/application-research/edge-ur/core"
+	"github.com/labstack/echo/v4"
+)
+
+type SignedUrlRequest struct {
+	Message             string    `json:"message"`
+	CurrentTimestamp    time.Time `json:"current_timestamp"`
+	ExpirationTimestamp time.Time `json:"expiration_timestamp"`
+	EdgeContentId       int64     `json:"edge_content_id"`
+	Signature           string    `json:"signature"`
+}
+
+// The function `handleIssueSignedUrl` issues a signed gateway url for a content of the api key. The url serves the
+// content from `/gw/content/:id` without an api key until its `expiration_timestamp`.
+func handleIssueSignedUrl(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		keyHash := requestKeyHash(c)
+		var req SignedUrlRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(400, map[string]interface{}{
+				"message": "Invalid request body",
+			})
+		}
+
+		var content core.Content
+		node.DB.Model(&core.Content{}).Where("id = ? and api_key_hash = ?", req.EdgeContentId, keyHash).Find(&content)
+		if content.ID == 0 {
+			return c.JSON(404, map[string]interface{}{
+				"message": "Content not found",
+			})
+		}
+		if content.Encrypted {
+			return c.JSON(400, map[string]interface{}{
+				"message": "Encrypted contents can only be read with the api key they were uploaded with",
+			})
+		}
+
+		maxExpiration := time.Now().Add(time.Duration(node.Config.Common.SignedUrlMaxExpiry) * time.Hour)
+		if !req.ExpirationTimestamp.After(time.Now()) || req.ExpirationTimestamp.After(maxExpiration) {
+			return c.JSON(400, map[string]interface{}{
+				"message": "The expiration_timestamp has to be in the future and before " + maxExpiration.Format(time.RFC3339),
+			})
+		}
+
+		meta, err := node.Signer.Issue(node.DB, content, keyHash, req.ExpirationTimestamp, req.Message, node.PublicUrl())
+		if err != nil {
+			return c.JSON(500, map[string]interface{}{
+				"message": "Error issuing the signed url: " + err.Error(),
+			})
+		}
+		return c.JSON(200, meta)
+	}
+}
+
+// The function `handleGetSignedUrls` lists the signed urls issued for the api key, optionally for one `content_id`.
+func handleGetSignedUrls(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		query := node.DB.Model(&core.ContentSignatureMeta{}).Where("api_key_hash = ?", requestKeyHash(c))
+		if contentId := c.QueryParam("content_id"); contentId != "" {
+			query = query.Where("content_id = ?", contentId)
+		}
+		var metas []core.ContentSignatureMeta
+		query.Order("id desc").Find(&metas)
+		return c.JSON(200, metas)
+	}
+}
+
+// The function `handleRevokeSignedUrl` revokes a signed url of the api key before it expires.
+func handleRevokeSignedUrl(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var meta core.ContentSignatureMeta
+		node.DB.Model(&core.ContentSignatureMeta{}).Where("id = ? and api_key_hash = ?", c.Param("id"), requestKeyHash(c)).Find(&meta)
+		if meta.ID == 0 {
+			return c.JSON(404, map[string]interface{}{
+				"message": "Signed url not found",
+			})
+		}
+		if meta.RevokedAt == nil {
+			if err := core.RevokeSignedUrl(node.DB, &meta); err != nil {
+				return err
+			}
+		}
+		return c.JSON(200, meta)
+	}
+}
@@ -34,7 +34,7 @@ func ConfigureStatsRouter(e *echo.Group, node *core.LightNode) {
 			return c.JSON(500, err)
 		}
 
-		//select sum(total_api_keys) from (select count(*) as total_api_keys from contents group by requesting_api_key) as total_api_keys;
+		//select sum(total_api_keys) from (select count(*) as total_api_keys from contents group by api_key_hash) as total_api_keys;
 		err = node.DB.Raw("select * from mv_total_api_keys").Scan(&s.TotalApiKeys).Error
 		if err != nil {
 			return c.JSON(500, err)
@@ -2,9 +2,7 @@ package api
 
 import (
 	"context"
-	"github.com/application-research/edge-ur/jobs"
 	"github.com/ipfs/go-cid"
-	"strings"
 
 	"github.com/application-research/edge-ur/core"
 	"github.com/labstack/echo/v4"
@@ -24,15 +22,12 @@ type StatusCheckResponse struct {
 func ConfigureStatusCheckRouter(e *echo.Group, node *core.LightNode) {
 	e.GET("/status/cid/:cid", func(c echo.Context) error {
 
-		authorizationString := c.Request().Header.Get("Authorization")
-		authParts := strings.Split(authorizationString, " ")
+		keyHash := requestKeyHash(c)
 
 		var contentCids []core.Content
-		node.DB.Raw("select * from contents as c where requesting_api_key = ? and cid = ?", authParts[1], c.Param("cid")).Scan(&contentCids)
+		node.DB.Raw("select * from contents as c where api_key_hash = ? and cid = ?", keyHash, c.Param("cid")).Scan(&contentCids)
 
-		for _, content := range contentCids {
-			content.RequestingApiKey = ""
-		}
+		node.FillReplicaCounts(contentCids)
 
 		if len(contentCids) == 0 {
 			return c.JSON(404, map[string]interface{}{
@@ -43,32 +38,43 @@ func ConfigureStatusCheckRouter(e *echo.Group, node *core.LightNode) {
 		return c.JSON(200, map[string]interface{}{
 			"cids": contentCids,
 		})
-	})
+	}, requireScope(core.ScopeRead))
 	e.GET("/status/content/:contentId", func(c echo.Context) error {
 
-		authorizationString := c.Request().Header.Get("Authorization")
-		authParts := strings.Split(authorizationString, " ")
+		keyHash := requestKeyHash(c)
 
 		var content core.Content
-		node.DB.Raw("select * from contents as c where requesting_api_key = ? and id = ?", authParts[1], c.Param("id")).Scan(&content)
-		content.RequestingApiKey = ""
+		node.DB.Raw("select * from contents as c where api_key_hash = ? and id = ?", keyHash, c.Param("contentId")).Scan(&content)
 
 		if content.ID == 0 {
 			return c.JSON(404, map[string]interface{}{
 				"message": "Content not found. Please check if you have the proper API key or if the content id is valid",
 			})
 		}
+
+		// the deals and replicas of the bucket the content was aggregated in
+		var deals []core.ContentDeal
+		var replication core.ReplicationStatus
+		if content.BucketUuid != "" {
+			node.DB.Model(&core.ContentDeal{}).Where("bucket_uuid = ?", content.BucketUuid).Find(&deals)
+
+			var bucket core.Bucket
+			node.DB.Model(&core.Bucket{}).Where("uuid = ?", content.BucketUuid).Find(&bucket)
+			replication = node.BucketReplication(bucket)
+			content.Replicas = replication.ReplicaCount
+		}
 		return c.JSON(200, map[string]interface{}{
-			"content": content,
+			"content":     content,
+			"deals":       deals,
+			"replication": replication,
 		})
-	})
+	}, requireScope(core.ScopeRead))
 	e.GET("/status/bucket/:bucketUuid", func(c echo.Context) error {
 
-		authorizationString := c.Request().Header.Get("Authorization")
-		authParts := strings.Split(authorizationString, " ")
+		keyHash := requestKeyHash(c)
 
 		var bucket core.Bucket
-		node.DB.Model(&core.Bucket{}).Where("requesting_api_key = ? and uuid = ?", authParts[1], c.Param("uuid")).Scan(&bucket)
+		node.DB.Model(&core.Bucket{}).Where("api_key_hash = ? and uuid = ?", keyHash, c.Param("uuid")).Scan(&bucket)
 
 		// get the cid
 		bucketCid, err := cid.Decode(bucket.Cid)
@@ -85,12 +91,10 @@ func ConfigureStatusCheckRouter(e *echo.Group, node *core.LightNode) {
 		}
 
 		var contents []core.Content
-		node.DB.Model(&core.Content{}).Where("requesting_api_key = ? and bucket_uuid = ?", authParts[1], c.Param("uuid")).Scan(&contents)
+		node.DB.Model(&core.Content{}).Where("api_key_hash = ? and bucket_uuid = ?", keyHash, c.Param("uuid")).Scan(&contents)
 
 		var contentResponse []core.Content
 		for _, content := range contents {
-			content.RequestingApiKey = ""
-
 			for _, link := range dirNdRaw.Links() {
 				cidFromDb, err := cid.Decode(content.Cid)
 				if err != nil {
@@ -98,26 +102,29 @@ func ConfigureStatusCheckRouter(e *echo.Group, node *core.LightNode) {
 				}
 				if link.Cid == cidFromDb {
 					content.Cid = link.Cid.String()
-					content.RequestingApiKey = ""
 					contentResponse = append(contentResponse, content)
 				}
 
 			}
 		}
 
-		bucket.RequestingApiKey = ""
+		replication := node.BucketReplication(bucket)
+		for i := range contentResponse {
+			contentResponse[i].Replicas = replication.ReplicaCount
+		}
+
 		return c.JSON(200, map[string]interface{}{
 			"bucket":        bucket,
 			"content_links": contentResponse,
+			"replication":   replication,
 		})
-	})
+	}, requireScope(core.ScopeRead))
 	e.GET("/status/tag/:tag-name", func(c echo.Context) error {
 
-		authorizationString := c.Request().Header.Get("Authorization")
-		authParts := strings.Split(authorizationString, " ")
+		keyHash := requestKeyHash(c)
 
 		var bucket core.Bucket
-		node.DB.Model(&core.Bucket{}).Where("requesting_api_key = ? and collection_name = ?", authParts[1], c.Param("tag-name")).Scan(&bucket)
+		node.DB.Model(&core.Bucket{}).Where("api_key_hash = ? and collection_name = ?", keyHash, c.Param("tag-name")).Scan(&bucket)
 
 		// get the cid
 		bucketCid, err := cid.Decode(bucket.Cid)
@@ -134,12 +141,10 @@ func ConfigureStatusCheckRouter(e *echo.Group, node *core.LightNode) {
 		}
 
 		var contents []core.Content
-		node.DB.Model(&core.Content{}).Where("requesting_api_key = ? and bucket_uuid = ?", authParts[1], c.Param("uuid")).Scan(&contents)
+		node.DB.Model(&core.Content{}).Where("api_key_hash = ? and bucket_uuid = ?", keyHash, c.Param("uuid")).Scan(&contents)
 
 		var contentResponse []core.Content
 		for _, content := range contents {
-			content.RequestingApiKey = ""
-
 			for _, link := range dirNdRaw.Links() {
 				cidFromDb, err := cid.Decode(content.Cid)
 				if err != nil {
@@ -147,24 +152,21 @@ func ConfigureStatusCheckRouter(e *echo.Group, node *core.LightNode) {
 				}
 				if link.Cid == cidFromDb {
 					content.Cid = link.Cid.String()
-					content.RequestingApiKey = ""
 					contentResponse = append(contentResponse, content)
 				}
 
 			}
-			//job := jobs.CreateNewDispatcher()
-			//job.AddJob(jobs.NewDealItemChecker(node, content))
+		}
 
+		replication := node.BucketReplication(bucket)
+		for i := range contentResponse {
+			contentResponse[i].Replicas = replication.ReplicaCount
 		}
-		// trigger status check
-		job := jobs.CreateNewDispatcher()
-		//job.AddJob(jobs.NewCarDealItemChecker(node, bucket))
-		job.Start(len(contents) + 1)
 
-		bucket.RequestingApiKey = ""
 		return c.JSON(200, map[string]interface{}{
 			"bucket":        bucket,
 			"content_links": contentResponse,
+			"replication":   replication,
 		})
-	})
+	}, requireScope(core.ScopeRead))
 }
@@ -2,8 +2,8 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/application-research/edge-ur/core"
-	"github.com/application-research/edge-ur/jobs"
 	"github.com/ipfs/go-cid"
 	"github.com/labstack/echo/v4"
 )
@@ -27,9 +27,7 @@ func ConfigureStatusOpenCheckRouter(e *echo.Group, node *core.LightNode) {
 		var contentCids []core.Content
 		node.DB.Raw("select * from contents as c where cid = ?", c.Param("cid")).Scan(&contentCids)
 
-		for _, content := range contentCids {
-			content.RequestingApiKey = ""
-		}
+		node.FillReplicaCounts(contentCids)
 
 		if len(contentCids) == 0 {
 			return c.JSON(404, map[string]interface{}{
@@ -45,15 +43,73 @@ func ConfigureStatusOpenCheckRouter(e *echo.Group, node *core.LightNode) {
 
 		var content core.Content
 		node.DB.Raw("select * from contents as c where id = ?", c.Param("contentId")).Scan(&content)
-		content.RequestingApiKey = ""
 
 		if content.ID == 0 {
 			return c.JSON(404, map[string]interface{}{
 				"message": "Content not found. Please check if the content id is valid",
 			})
 		}
+
+		// the deals and replicas of the bucket the content was aggregated in
+		var deals []core.ContentDeal
+		var replication core.ReplicationStatus
+		if content.BucketUuid != "" {
+			node.DB.Model(&core.ContentDeal{}).Where("bucket_uuid = ?", content.BucketUuid).Find(&deals)
+
+			var bucket core.Bucket
+			node.DB.Model(&core.Bucket{}).Where("uuid = ?", content.BucketUuid).Find(&bucket)
+			replication = node.BucketReplication(bucket)
+			content.Replicas = replication.ReplicaCount
+		}
+		return c.JSON(200, map[string]interface{}{
+			"content":     content,
+			"deals":       deals,
+			"replication": replication,
+		})
+	})
+	e.GET("/status/content/:contentId/proof", func(c echo.Context) error {
+
+		var content core.Content
+		node.DB.Model(&core.Content{}).Where("id = ?", c.Param("contentId")).Scan(&content)
+		if content.ID == 0 {
+			return c.JSON(404, map[string]interface{}{
+				"message": "Content not found. Please check if the content id is valid",
+			})
+		}
+
+		var bucket core.Bucket
+		node.DB.Model(&core.Bucket{}).Where("uuid = ?", content.BucketUuid).Scan(&bucket)
+		if content.InclusionProof == "" || bucket.PieceCid == "" {
+			return c.JSON(404, map[string]interface{}{
+				"message": "No inclusion proof for this content yet. Proofs are available once the bucket of the content is aggregated",
+			})
+		}
+
+		var proof core.InclusionProof
+		if err := json.Unmarshal([]byte(content.InclusionProof), &proof); err != nil {
+			return err
+		}
+
+		// check the proof before handing it out, the client should still verify it against the deal.
+		verified := false
+		pieceCid, errPiece := cid.Decode(bucket.PieceCid)
+		subPieceCid, errSubPiece := cid.Decode(content.PieceCid)
+		if errPiece == nil && errSubPiece == nil {
+			subPiece := core.SubPiece{PieceCid: subPieceCid, Size: uint64(content.PieceSize)}
+			verified = proof.Verify(pieceCid, subPiece, uint64(content.PieceOffset)) == nil
+		}
+
 		return c.JSON(200, map[string]interface{}{
-			"content": content,
+			"content_id":       content.ID,
+			"cid":              content.Cid,
+			"bucket_uuid":      bucket.Uuid,
+			"piece_cid":        bucket.PieceCid,
+			"piece_size":       bucket.PieceSize,
+			"sub_piece_cid":    content.PieceCid,
+			"sub_piece_size":   content.PieceSize,
+			"sub_piece_offset": content.PieceOffset,
+			"inclusion_proof":  proof,
+			"verified":         verified,
 		})
 	})
 	e.GET("/status/bucket/:bucketUuid", func(c echo.Context) error {
@@ -80,8 +136,6 @@ func ConfigureStatusOpenCheckRouter(e *echo.Group, node *core.LightNode) {
 
 		var contentResponse []core.Content
 		for _, content := range contents {
-			content.RequestingApiKey = ""
-
 			for _, link := range dirNdRaw.Links() {
 				cidFromDb, err := cid.Decode(content.Cid)
 				if err != nil {
@@ -89,17 +143,21 @@ func ConfigureStatusOpenCheckRouter(e *echo.Group, node *core.LightNode) {
 				}
 				if link.Cid == cidFromDb {
 					content.Cid = link.Cid.String()
-					content.RequestingApiKey = ""
 					contentResponse = append(contentResponse, content)
 				}
 
 			}
 		}
 
-		bucket.RequestingApiKey = ""
+		replication := node.BucketReplication(bucket)
+		for i := range contentResponse {
+			contentResponse[i].Replicas = replication.ReplicaCount
+		}
+
 		return c.JSON(200, map[string]interface{}{
 			"bucket":        bucket,
 			"content_links": contentResponse,
+			"replication":   replication,
 		})
 	})
 	e.GET("/status/tag/:tag-name", func(c echo.Context) error {
@@ -126,8 +184,6 @@ func ConfigureStatusOpenCheckRouter(e *echo.Group, node *core.LightNode) {
 
 		var contentResponse []core.Content
 		for _, content := range contents {
-			content.RequestingApiKey = ""
-
 			for _, link := range dirNdRaw.Links() {
 				cidFromDb, err := cid.Decode(content.Cid)
 				if err != nil {
@@ -135,21 +191,22 @@ func ConfigureStatusOpenCheckRouter(e *echo.Group, node *core.LightNode) {
 				}
 				if link.Cid == cidFromDb {
 					content.Cid = link.Cid.String()
-					content.RequestingApiKey = ""
 					contentResponse = append(contentResponse, content)
 				}
 
 			}
 
 		}
-		// trigger status check
-		job := jobs.CreateNewDispatcher()
-		job.Start(len(contents) + 1)
 
-		bucket.RequestingApiKey = ""
+		replication := node.BucketReplication(bucket)
+		for i := range contentResponse {
+			contentResponse[i].Replicas = replication.ReplicaCount
+		}
+
 		return c.JSON(200, map[string]interface{}{
 			"bucket":        bucket,
 			"content_links": contentResponse,
+			"replication":   replication,
 		})
 	})
 }
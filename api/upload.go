@@ -1,16 +1,10 @@
 package api
 
 import (
-	"bytes"
-	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/application-research/edge-ur/jobs"
 	"github.com/application-research/edge-ur/utils"
-	"github.com/google/uuid"
-	"github.com/ipfs/go-cid"
-	"github.com/ipld/go-car"
 	"github.com/labstack/echo/v4"
 	"io"
 	"strings"
@@ -20,7 +14,8 @@ import (
 )
 
 type CidRequest struct {
-	Cids []string `json:"cids"`
+	Cids           []string `json:"cids"`
+	CollectionName string   `json:"collection_name"`
 }
 
 type DealE2EUploadRequest struct {
@@ -76,21 +71,21 @@ type UploadResponse struct {
 
 // The function `ConfigureUploadRouter` configures the upload routes for a given Echo group and LightNode.
 func ConfigureUploadRouter(e *echo.Group, node *core.LightNode) {
-	content := e.Group("/content")
-	content.POST("/add", handleUploadToCarBucket(node))
-	content.POST("/upload", handleUploadToCarBucket(node))
-	content.POST("/pin", handlePin(node))
-	content.POST("/fetch-cids", handleFetchCids(node))
-	content.POST("/car-cids", handleCidsToCarBucket(node))
-	content.POST("/add-car", handleUploadCarToBucket(node))
-}
-
-type SignedUrlRequest struct {
-	Message             string    `json:"message"`
-	CurrentTimestamp    time.Time `json:"current_timestamp"`
-	ExpirationTimestamp time.Time `json:"expiration_timestamp"`
-	EdgeContentId       int64     `json:"edge_content_id"`
-	Signature           string    `json:"signature"`
+	content := e.Group("/content", scopeByMethod(core.ScopeRead, core.ScopeUpload))
+	content.POST("/add", handleUploadToCarBucket(node), uploadLimits(node))
+	content.POST("/upload", handleUploadToCarBucket(node), uploadLimits(node))
+	content.POST("/pin", handlePin(node), uploadLimits(node))
+	content.POST("/fetch-cids", handleFetchCids(node), uploadLimits(node))
+	content.POST("/car-cids", handleFetchCids(node), uploadLimits(node))
+	content.GET("/fetch/:id", handleGetCidFetch(node))
+	content.GET("/fetches", handleGetCidFetches(node))
+	content.POST("/add-car", handleUploadCarToBucket(node), uploadLimits(node))
+	content.POST("/add-dir", handleUploadDirectory(node), uploadLimits(node))
+	content.POST("/add-archive", handleUploadArchive(node), uploadLimits(node))
+	content.GET("/dir/:uuid", handleGetDirectory(node))
+	content.POST("/signed-url", handleIssueSignedUrl(node))
+	content.GET("/signed-urls", handleGetSignedUrls(node))
+	content.DELETE("/signed-url/:id", handleRevokeSignedUrl(node))
 }
 
 // The function `handlePin` handles the pinning of a file to IPFS and returns a JSON response with the status, CID, content
@@ -123,240 +118,13 @@ func handlePin(node *core.LightNode) func(c echo.Context) error {
 	}
 }
 
-// The function `handleFetchCids` handles a request to fetch and pin multiple CIDs, returning a JSON response with the
-// status and message for each CID.
-func handleFetchCids(node *core.LightNode) func(c echo.Context) error {
-	return func(c echo.Context) error {
-		cidBodyReq := CidRequest{}
-		c.Bind(&cidBodyReq)
-
-		type PinCidsResponse struct {
-			Cid     string `json:"cid"`
-			Status  string `json:"status"`
-			Message string `json:"message"`
-		}
-		var pinCidsResponse []PinCidsResponse
-		for _, cidItem := range cidBodyReq.Cids {
-			cidDc, err := cid.Decode(cidItem)
-			pinCidsResponseItem := PinCidsResponse{
-				Cid: cidItem,
-			}
-			if err != nil {
-				pinCidsResponseItem.Status = "error"
-				pinCidsResponseItem.Message = "Error decoding the CID"
-				pinCidsResponse = append(pinCidsResponse, pinCidsResponseItem)
-				continue
-			}
-
-			_, errGet := node.Node.Get(c.Request().Context(), cidDc)
-			if errGet != nil {
-				pinCidsResponseItem.Status = "error"
-				pinCidsResponseItem.Message = "Error fetching the CID"
-				pinCidsResponse = append(pinCidsResponse, pinCidsResponseItem)
-				continue
-			}
-			pinCidsResponseItem.Status = "success"
-			pinCidsResponseItem.Message = "CID successfully fetched and pinned"
-		}
-		return c.JSON(200, pinCidsResponse)
-	}
-}
-
-// The function `handleCidsToCarBucket` handles the request to upload and pin files to a bucket in IPFS, splitting the
-// files if necessary and applying tag policies.
-func handleCidsToCarBucket(node *core.LightNode) func(c echo.Context) error {
-	return func(c echo.Context) error {
-		authorizationString := c.Request().Header.Get("Authorization")
-		authParts := strings.Split(authorizationString, " ")
-		cidBodyReq := CidRequest{}
-		c.Bind(&cidBodyReq)
-		collectionName := c.FormValue("collection_name")
-
-		// Check capacity if needed
-		if node.Config.Common.CapacityLimitPerKeyInBytes > 0 {
-			if err := validateCapacityLimit(node, authParts[1]); err != nil {
-				return c.JSON(500, UploadResponse{
-					Status:  "error",
-					Message: err.Error(),
-				})
-			}
-		}
-
-		// check if tag exists, if it does, get the ID
-		fmt.Println(collectionName)
-		if collectionName == "" {
-			collectionName = node.Config.Node.DefaultCollectionName
-		}
-
-		// load the policy of the tag
-		var policy core.Policy
-		node.DB.Where("name = ?", collectionName).First(&policy)
-		if policy.ID == 0 {
-			// create new policy
-			newPolicy := core.Policy{
-				Name:       collectionName,
-				BucketSize: node.Config.Common.BucketAggregateSize,
-				SplitSize:  node.Config.Common.SplitSize,
-				CreatedAt:  time.Now(),
-				UpdatedAt:  time.Now(),
-			}
-			node.DB.Create(&newPolicy)
-			policy = newPolicy
-		}
-
-		// check open bucket
-		var contentList []core.Content
-		job := jobs.CreateNewDispatcher()
-		for _, cidItem := range cidBodyReq.Cids {
-			time.Sleep(5 * time.Second)
-			cidDc, err := cid.Decode(cidItem)
-			if err != nil {
-				return c.JSON(500, UploadResponse{
-					Status:  "error",
-					Message: "Error decoding the CID",
-				})
-			}
-
-			nodeToGet, err := node.Node.GetFile(c.Request().Context(), cidDc)
-			if err != nil {
-				return c.JSON(500, UploadResponse{
-					Status:  "error",
-					Message: "Error adding the file to IPFS",
-				})
-			}
-			var nodeW bytes.Buffer
-			_, errS := io.Copy(&nodeW, nodeToGet)
-			if errS != nil {
-				return c.JSON(500, UploadResponse{
-					Status:  "error",
-					Message: "Error getting the file size",
-				})
-			}
-
-			nodeFileSize := nodeW.Len()
-
-			if int64(nodeFileSize) > node.Config.Common.MaxSizeToSplit {
-				newContent := core.Content{
-					Name:             cidDc.String(),
-					Size:             int64(nodeFileSize),
-					Cid:              cidDc.String(),
-					CollectionName:   collectionName,
-					RequestingApiKey: authParts[1],
-					Status:           utils.STATUS_PINNED,
-					MakeDeal:         true,
-					CreatedAt:        time.Now(),
-					UpdatedAt:        time.Now(),
-				}
-
-				node.DB.Create(&newContent)
-
-				// split the file and use the same tag policies
-				job.AddJob(jobs.NewSplitterProcessor(node, newContent, nodeToGet))
-				job.Start(1)
-				if err != nil {
-					return c.JSON(500, UploadResponse{
-						Status:  "error",
-						Message: "Error pinning the file" + err.Error(),
-					})
-				}
-				newContent.RequestingApiKey = ""
-				contentList = append(contentList, newContent)
-			} else {
-				var bucket core.Bucket
-
-				rawQuery := "SELECT * FROM buckets WHERE status = ? and name = ?"
-				node.DB.Raw(rawQuery, "open", collectionName).First(&bucket)
-
-				if bucket.ID == 0 {
-					// create a new bucket
-					bucketUuid, errUuid := uuid.NewUUID()
-					if errUuid != nil {
-						return c.JSON(500, UploadResponse{
-							Status:  "error",
-							Message: "Error creating bucket",
-						})
-					}
-					bucket = core.Bucket{
-						Status:           "open",
-						Name:             collectionName,
-						RequestingApiKey: authParts[1],
-						Uuid:             bucketUuid.String(),
-						PolicyId:         policy.ID,
-						Size:             int64(nodeFileSize),
-						CreatedAt:        time.Now(),
-						UpdatedAt:        time.Now(),
-					}
-					node.DB.Create(&bucket)
-				} else {
-
-					bucket.Size = bucket.Size + int64(nodeFileSize)
-					node.DB.Save(&bucket)
-				}
-				fmt.Println("bucketUuid", bucket.Uuid, "bucket.Size", bucket.Size)
-
-				newContent := core.Content{
-					Name:             cidDc.String(),
-					Size:             int64(nodeFileSize),
-					Cid:              cidDc.String(),
-					RequestingApiKey: authParts[1],
-					Status:           utils.STATUS_PINNED,
-					CollectionName:   collectionName,
-					BucketUuid:       bucket.Uuid,
-					MakeDeal:         true,
-					CreatedAt:        time.Now(),
-					UpdatedAt:        time.Now(),
-				}
-
-				node.DB.Create(&newContent)
-
-				// bucket aggregator
-
-				job.AddJob(jobs.NewBucketAggregator(node, &bucket))
-				job.Start(1)
-				if err != nil {
-					return c.JSON(500, UploadResponse{
-						Status:  "error",
-						Message: "Error pinning the file" + err.Error(),
-					})
-				}
-				newContent.RequestingApiKey = ""
-				contentList = append(contentList, newContent)
-			}
-			//}
-		}
-
-		//job.Start(len(cidBodyReq.Cids))
-
-		return c.JSON(200, struct {
-			Status   string         `json:"status"`
-			Message  string         `json:"message"`
-			Contents []core.Content `json:"contents"`
-		}{
-			Status:   "success",
-			Message:  "File uploaded and pinned successfully. Please take note of the ids.",
-			Contents: contentList,
-		})
-	}
-}
-
 // The function `handleUploadToCarBucket` handles the upload of a file to a bucket in a car storage system, including
 // splitting the file if necessary and updating the bucket's size.
 func handleUploadToCarBucket(node *core.LightNode) func(c echo.Context) error {
 	return func(c echo.Context) error {
-		authorizationString := c.Request().Header.Get("Authorization")
-		authParts := strings.Split(authorizationString, " ")
+		keyHash := requestKeyHash(c)
 		collectionName := c.FormValue("collection_name")
 
-		// Check capacity if needed
-		if node.Config.Common.CapacityLimitPerKeyInBytes > 0 {
-			if err := validateCapacityLimit(node, authParts[1]); err != nil {
-				return c.JSON(500, UploadResponse{
-					Status:  "error",
-					Message: err.Error(),
-				})
-			}
-		}
-
 		// check if tag exists, if it does, get the ID
 		fmt.Println(collectionName)
 		if collectionName == "" {
@@ -364,19 +132,12 @@ func handleUploadToCarBucket(node *core.LightNode) func(c echo.Context) error {
 		}
 
 		// load the policy of the tag
-		var policy core.Policy
-		node.DB.Where("name = ?", collectionName).First(&policy)
-		if policy.ID == 0 {
-			// create new policy
-			newPolicy := core.Policy{
-				Name:       collectionName,
-				BucketSize: node.Config.Common.BucketAggregateSize,
-				SplitSize:  node.Config.Common.SplitSize,
-				CreatedAt:  time.Now(),
-				UpdatedAt:  time.Now(),
-			}
-			node.DB.Create(&newPolicy)
-			policy = newPolicy
+		policy, err := node.PolicyForCollection(collectionName)
+		if err != nil {
+			return c.JSON(500, UploadResponse{
+				Status:  "error",
+				Message: err.Error(),
+			})
 		}
 
 		file, err := c.FormFile("data")
@@ -384,291 +145,62 @@ func handleUploadToCarBucket(node *core.LightNode) func(c echo.Context) error {
 			return err
 		}
 		src, err := file.Open()
-		srcR, err := file.Open()
 		if err != nil {
 			return err
 		}
+		defer src.Close()
 
-		addNode, err := node.Node.AddPinFile(c.Request().Context(), src, nil)
-		if err != nil {
-			return c.JSON(500, UploadResponse{
-				Status:  "error",
-				Message: "Error adding the file to IPFS",
-			})
-		}
-
-		// check open bucket
-		var contentList []core.Content
-
-		if file.Size > node.Config.Common.MaxSizeToSplit {
-			newContent := core.Content{
-				Name:             file.Filename,
-				Size:             file.Size,
-				Cid:              addNode.Cid().String(),
-				CollectionName:   collectionName,
-				RequestingApiKey: authParts[1],
-				Status:           utils.STATUS_PINNED,
-				MakeDeal:         true,
-				CreatedAt:        time.Now(),
-				UpdatedAt:        time.Now(),
-			}
-
-			node.DB.Create(&newContent)
-
-			// split the file and use the same tag policies
-			job := jobs.CreateNewDispatcher()
-			job.AddJob(jobs.NewSplitterProcessor(node, newContent, srcR))
-			job.Start(1)
-
+		// encrypted uploads are stored and dealt as ciphertext, only the uploading api key can read them back
+		encrypt := c.FormValue("encrypt") == "true"
+		var reader io.Reader = src
+		size := file.Size
+		var envelope core.ContentEncryption
+		if encrypt {
+			var dek []byte
+			dek, envelope, err = core.NewContentKey(requestApiKey(c), file.Size)
 			if err != nil {
 				return c.JSON(500, UploadResponse{
 					Status:  "error",
-					Message: "Error pinning the file" + err.Error(),
+					Message: "Error creating the encryption key: " + err.Error(),
 				})
 			}
-			newContent.RequestingApiKey = ""
-			contentList = append(contentList, newContent)
-		} else {
-			var bucket core.Bucket
-
-			rawQuery := "SELECT * FROM buckets WHERE status = ? and name = ?"
-			node.DB.Raw(rawQuery, "open", collectionName).First(&bucket)
-
-			if bucket.ID == 0 {
-				// create a new bucket
-				bucketUuid, errUuid := uuid.NewUUID()
-				if errUuid != nil {
-					return c.JSON(500, UploadResponse{
-						Status:  "error",
-						Message: "Error creating bucket",
-					})
-				}
-				bucket = core.Bucket{
-					Status:           "open",
-					Name:             collectionName,
-					RequestingApiKey: authParts[1],
-					Uuid:             bucketUuid.String(),
-					PolicyId:         policy.ID,
-					Size:             file.Size,
-					CreatedAt:        time.Now(),
-					UpdatedAt:        time.Now(),
-				}
-				node.DB.Create(&bucket)
-			} else {
-
-				bucket.Size = bucket.Size + file.Size
-				node.DB.Save(&bucket)
-			}
-			fmt.Println("bucketUuid", bucket.Uuid, "bucket.Size", bucket.Size)
-
-			newContent := core.Content{
-				Name:             file.Filename,
-				Size:             file.Size,
-				Cid:              addNode.Cid().String(),
-				RequestingApiKey: authParts[1],
-				Status:           utils.STATUS_PINNED,
-				CollectionName:   collectionName,
-				BucketUuid:       bucket.Uuid,
-				MakeDeal:         true,
-				CreatedAt:        time.Now(),
-				UpdatedAt:        time.Now(),
-			}
-
-			node.DB.Create(&newContent)
-
-			// bucket aggregator
-			job := jobs.CreateNewDispatcher()
-			job.AddJob(jobs.NewBucketAggregator(node, &bucket))
-			job.Start(1)
-
-			if err != nil {
-				return c.JSON(500, UploadResponse{
-					Status:  "error",
-					Message: "Error pinning the file" + err.Error(),
-				})
+			if reader, err = utils.NewEncryptingReader(dek, src); err != nil {
+				return err
 			}
-			newContent.RequestingApiKey = ""
-			contentList = append(contentList, newContent)
+			size = utils.EncryptedStreamSize(file.Size)
 		}
-		//}
 
-		return c.JSON(200, struct {
-			Status   string         `json:"status"`
-			Message  string         `json:"message"`
-			Contents []core.Content `json:"contents"`
-		}{
-			Status:   "success",
-			Message:  "File uploaded and pinned successfully. Please take note of the ids.",
-			Contents: contentList,
-		})
-	}
-}
-
-// The function `handleUploadCarToBucket` handles the upload of a car file to a bucket, splitting the file if necessary and
-// pinning it to the IPFS network.
-func handleUploadCarToBucket(node *core.LightNode) func(c echo.Context) error {
-	return func(c echo.Context) error {
-		authorizationString := c.Request().Header.Get("Authorization")
-		authParts := strings.Split(authorizationString, " ")
-		collectionName := c.FormValue("collection_name")
-
-		// Check capacity if needed
-		if node.Config.Common.CapacityLimitPerKeyInBytes > 0 {
-			if err := validateCapacityLimit(node, authParts[1]); err != nil {
-				return c.JSON(500, UploadResponse{
-					Status:  "error",
-					Message: err.Error(),
-				})
-			}
-		}
-
-		// check if tag exists, if it does, get the ID
-		fmt.Println(collectionName)
-		if collectionName == "" {
-			collectionName = node.Config.Node.DefaultCollectionName
-		}
-
-		// load the policy of the tag
-		var policy core.Policy
-		node.DB.Where("name = ?", collectionName).First(&policy)
-
-		if policy.ID == 0 {
-			newPolicy := core.Policy{
-				Name:       collectionName,
-				BucketSize: node.Config.Common.BucketAggregateSize,
-				SplitSize:  node.Config.Common.SplitSize,
-				CreatedAt:  time.Now(),
-				UpdatedAt:  time.Now(),
-			}
-			node.DB.Create(&newPolicy)
-			policy = newPolicy
+		addNode, err := node.Node.AddPinFile(c.Request().Context(), reader, nil)
+		if err != nil {
+			return c.JSON(500, UploadResponse{
+				Status:  "error",
+				Message: "Error adding the file to IPFS",
+			})
 		}
 
-		file, err := c.FormFile("data")
-		if err != nil {
-			return err
+		newContent := core.Content{
+			Name:           file.Filename,
+			Size:           size,
+			Cid:            addNode.Cid().String(),
+			ApiKeyHash:     keyHash,
+			Status:         utils.STATUS_PINNED,
+			CollectionName: collectionName,
+			MakeDeal:       true,
+			Encrypted:      encrypt,
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
 		}
-		src, err := file.Open()
-		srcR, err := file.Open()
-		if err != nil {
-			return err
+		if encrypt {
+			newContent.Envelope = &envelope
 		}
-
-		carHeader, err := car.LoadCar(context.Background(), node.Node.Blockstore, src)
-
+		newContent, err = jobs.AddContentToCollection(node, newContent, policy, "")
 		if err != nil {
 			return c.JSON(500, UploadResponse{
 				Status:  "error",
-				Message: "Error loading car file: " + err.Error(),
+				Message: "Error pinning the file" + err.Error(),
 			})
 		}
-		rootCid := carHeader.Roots[0].String()
-
-		for _, root := range carHeader.Roots {
-			fmt.Println("root.String()", root.String())
-			rootCid = root.String()
-		}
-
-		// check open bucket
-		var contentList []core.Content
-
-		if file.Size > node.Config.Common.MaxSizeToSplit {
-			newContent := core.Content{
-				Name:             file.Filename,
-				Size:             file.Size,
-				Cid:              rootCid,
-				CollectionName:   collectionName,
-				RequestingApiKey: authParts[1],
-				Status:           utils.STATUS_PINNED,
-				MakeDeal:         true,
-				CreatedAt:        time.Now(),
-				UpdatedAt:        time.Now(),
-			}
-
-			node.DB.Create(&newContent)
-
-			// split the file and use the same tag policies
-			job := jobs.CreateNewDispatcher()
-			job.AddJob(jobs.NewSplitterProcessor(node, newContent, srcR))
-			job.Start(1)
-
-			if err != nil {
-				return c.JSON(500, UploadResponse{
-					Status:  "error",
-					Message: "Error pinning the file" + err.Error(),
-				})
-			}
-			newContent.RequestingApiKey = ""
-			contentList = append(contentList, newContent)
-		} else {
-			var bucket core.Bucket
-
-			rawQuery := "SELECT * FROM buckets WHERE status = ? and name = ?"
-			node.DB.Raw(rawQuery, "open", collectionName).First(&bucket)
-
-			if bucket.ID == 0 {
-				// create a new bucket
-				bucketUuid, errUuid := uuid.NewUUID()
-				if errUuid != nil {
-					return c.JSON(500, UploadResponse{
-						Status:  "error",
-						Message: "Error creating bucket",
-					})
-				}
-				bucket = core.Bucket{
-					Status:           "open",
-					Name:             collectionName,
-					RequestingApiKey: authParts[1],
-					//DeltaNodeUrl:     DeltaUploadApi,
-					Uuid:     bucketUuid.String(),
-					PolicyId: policy.ID,
-					Size:     file.Size,
-					//Miner:            miner, // blank
-					//Tag:       tag,
-					CreatedAt: time.Now(),
-					UpdatedAt: time.Now(),
-				}
-				node.DB.Create(&bucket)
-			} else {
-
-				bucket.Size = bucket.Size + file.Size
-				node.DB.Save(&bucket)
-			}
-			fmt.Println("bucketUuid", bucket.Uuid, "bucket.Size", bucket.Size)
-			newContent := core.Content{
-				Name: file.Filename,
-				Size: file.Size,
-				Cid:  rootCid,
-				//DeltaNodeUrl:     DeltaUploadApi,
-				RequestingApiKey: authParts[1],
-				Status:           utils.STATUS_PINNED,
-				//Miner:            miner,
-				//Tag:        tag,
-				CollectionName: collectionName,
-				BucketUuid:     bucket.Uuid,
-				MakeDeal:       true,
-				CreatedAt:      time.Now(),
-				UpdatedAt:      time.Now(),
-			}
-
-			node.DB.Create(&newContent)
-
-			//if makeDeal == "true" {
-			job := jobs.CreateNewDispatcher()
-			job.AddJob(jobs.NewBucketAggregator(node, &bucket))
-			job.Start(1)
-			//}
-
-			if err != nil {
-				return c.JSON(500, UploadResponse{
-					Status:  "error",
-					Message: "Error pinning the file" + err.Error(),
-				})
-			}
-			newContent.RequestingApiKey = ""
-			contentList = append(contentList, newContent)
-		}
-		//}
+		contentList := []core.Content{newContent}
 
 		return c.JSON(200, struct {
 			Status   string         `json:"status"`
@@ -679,26 +211,9 @@ func handleUploadCarToBucket(node *core.LightNode) func(c echo.Context) error {
 			Message:  "File uploaded and pinned successfully. Please take note of the ids.",
 			Contents: contentList,
 		})
-
 	}
 }
 
-// The function `validateCapacityLimit` checks if the total size of contents associated with a given API key exceeds a
-// specified capacity limit.
-func validateCapacityLimit(node *core.LightNode, authKey string) error {
-	var totalSize int64
-	err := node.DB.Raw(`SELECT COALESCE(SUM(size), 0) FROM contents where requesting_api_key = ?`, authKey).Scan(&totalSize).Error
-	if err != nil {
-		return err
-	}
-
-	if totalSize >= node.Config.Common.CapacityLimitPerKeyInBytes {
-		return errors.New(fmt.Sprintf("You have reached your capacity limit of %d bytes", node.Config.Common.CapacityLimitPerKeyInBytes))
-	}
-
-	return nil
-}
-
 type roots struct {
 	Event    string `json:"event"`
 	Payload  int    `json:"payload"`
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"io"
+
+	"github.com/application-research/edge-ur/core"
+	"github.com/application-research/edge-ur/jobs"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/xerrors"
+)
+
+// The function `handleUploadArchive` handles the upload of a tar, tar.gz or zip archive. The archive is unpacked on the
+// node within the archive limits, its files are pinned as a unixfs directory, and each file is added to the collection
+// as its own content. Links and other special entries of the archive are skipped.
+func handleUploadArchive(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		keyHash := requestKeyHash(c)
+		collectionName := c.FormValue("collection_name")
+
+		if collectionName == "" {
+			collectionName = node.Config.Node.DefaultCollectionName
+		}
+		policy, err := node.PolicyForCollection(collectionName)
+		if err != nil {
+			return c.JSON(500, DirectoryUploadResponse{
+				Status:  "error",
+				Message: err.Error(),
+			})
+		}
+
+		fileHeader, err := c.FormFile("data")
+		if err != nil {
+			return c.JSON(400, DirectoryUploadResponse{
+				Status:  "error",
+				Message: "Please provide the archive as the data file",
+			})
+		}
+		src, err := fileHeader.Open()
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		format, err := core.DetectArchiveFormat(src)
+		if err != nil {
+			return c.JSON(400, DirectoryUploadResponse{
+				Status:  "error",
+				Message: err.Error(),
+			})
+		}
+
+		limits := core.ArchiveLimits{
+			MaxEntries: node.Config.Common.ArchiveMaxEntries,
+			MaxSize:    node.Config.Common.ArchiveMaxSize,
+			MaxRatio:   node.Config.Common.ArchiveMaxRatio,
+		}
+		// the archive is checked in a first pass, files added while walking a rejected archive would stay on the node
+		if err := core.CheckArchive(src, fileHeader.Size, format, limits); err != nil {
+			return c.JSON(400, DirectoryUploadResponse{
+				Status:  "error",
+				Message: "Error unpacking the archive: " + err.Error(),
+			})
+		}
+
+		var files []jobs.DirectoryFile
+		err = core.WalkArchive(src, fileHeader.Size, format, limits, func(path string, r io.Reader) error {
+			counter := &countingReader{r: r}
+			addNode, err := node.Node.AddPinFile(c.Request().Context(), counter, nil)
+			if err != nil {
+				return xerrors.Errorf("error adding the file %s to IPFS: %w", path, err)
+			}
+			files = append(files, jobs.DirectoryFile{Path: path, Size: counter.n, Node: addNode})
+			return nil
+		})
+		if err != nil {
+			return c.JSON(500, DirectoryUploadResponse{
+				Status:  "error",
+				Message: "Error adding the archive: " + err.Error(),
+			})
+		}
+		if len(files) == 0 {
+			return c.JSON(400, DirectoryUploadResponse{
+				Status:  "error",
+				Message: "The archive has no files",
+			})
+		}
+
+		upload, err := jobs.AddDirectoryToCollection(node, files, collectionName, keyHash, policy)
+		if err != nil {
+			return c.JSON(500, DirectoryUploadResponse{
+				Status:  "error",
+				Message: "Error adding the directory: " + err.Error(),
+			})
+		}
+		return c.JSON(200, newDirectoryUploadResponse(upload))
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/application-research/edge-ur/core"
+	"github.com/application-research/edge-ur/jobs"
+	"github.com/application-research/edge-ur/utils"
+	"github.com/labstack/echo/v4"
+)
+
+type CarUploadResponse struct {
+	Status   string         `json:"status"`
+	Message  string         `json:"message"`
+	Contents []core.Content `json:"contents,omitempty"`
+	Car      core.CarReport `json:"car"`
+}
+
+// The function `handleUploadCarToBucket` handles the upload of a CARv1 or CARv2 file. Every block is re-hashed and the
+// DAG under every root has to be complete in the car, otherwise the car is rejected with a report of what is wrong.
+// A valid car is loaded into the blockstore and each root is added to the collection as its own content.
+func handleUploadCarToBucket(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		keyHash := requestKeyHash(c)
+		collectionName := c.FormValue("collection_name")
+
+		if collectionName == "" {
+			collectionName = node.Config.Node.DefaultCollectionName
+		}
+
+		// load the policy of the tag
+		policy, err := node.PolicyForCollection(collectionName)
+		if err != nil {
+			return c.JSON(500, UploadResponse{
+				Status:  "error",
+				Message: err.Error(),
+			})
+		}
+
+		file, err := c.FormFile("data")
+		if err != nil {
+			return c.JSON(400, UploadResponse{
+				Status:  "error",
+				Message: "Please provide the car as the data file",
+			})
+		}
+		src, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		report, err := core.ValidateCar(src, file.Size)
+		if err != nil {
+			return c.JSON(500, UploadResponse{
+				Status:  "error",
+				Message: "Error reading the car file: " + err.Error(),
+			})
+		}
+		if !report.Valid() {
+			return c.JSON(400, CarUploadResponse{
+				Status:  "error",
+				Message: "The car file is invalid",
+				Car:     report,
+			})
+		}
+		if err := core.LoadCar(context.Background(), node.Node.Blockstore, src, file.Size); err != nil {
+			return c.JSON(500, UploadResponse{
+				Status:  "error",
+				Message: "Error loading the car file: " + err.Error(),
+			})
+		}
+
+		var contentList []core.Content
+		for _, root := range report.Roots {
+			newContent := core.Content{
+				Name:           file.Filename,
+				Size:           root.Size,
+				Cid:            root.Cid,
+				ApiKeyHash:     keyHash,
+				Status:         utils.STATUS_PINNED,
+				CollectionName: collectionName,
+				MakeDeal:       true,
+				CreatedAt:      time.Now(),
+				UpdatedAt:      time.Now(),
+			}
+			if root.UnixfsFile {
+				newContent.Size = root.FileSize
+				newContent, err = jobs.AddContentToCollection(node, newContent, policy, "")
+			} else {
+				// the splitter only splits files, other dags go into a bucket whole
+				newContent, err = jobs.AddContentToBucket(node, newContent, policy)
+			}
+			if err != nil {
+				return c.JSON(500, UploadResponse{
+					Status:  "error",
+					Message: "Error adding the root " + root.Cid + ": " + err.Error(),
+				})
+			}
+			contentList = append(contentList, newContent)
+		}
+
+		return c.JSON(200, CarUploadResponse{
+			Status:   "success",
+			Message:  "File uploaded and pinned successfully. Please take note of the ids.",
+			Contents: contentList,
+			Car:      report,
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"github.com/application-research/edge-ur/core"
+	"github.com/application-research/edge-ur/jobs"
+	"github.com/labstack/echo/v4"
+)
+
+type DirectoryFileResponse struct {
+	Path      string `json:"path"`
+	ContentId int64  `json:"content_id"`
+	Cid       string `json:"cid"`
+	Size      int64  `json:"size"`
+}
+
+type DirectoryUploadResponse struct {
+	Status         string                  `json:"status"`
+	Message        string                  `json:"message"`
+	CollectionUuid string                  `json:"collection_uuid,omitempty"`
+	RootCid        string                  `json:"root_cid,omitempty"`
+	RootContentId  int64                   `json:"root_content_id,omitempty"`
+	Files          []DirectoryFileResponse `json:"files,omitempty"`
+	Contents       []core.Content          `json:"contents,omitempty"`
+}
+
+// The function `handleUploadDirectory` handles the upload of many files in one request. Each `data` file is placed at
+// the `path` form value of the same position, or at its file name when no paths are given. The files are pinned as a
+// unixfs directory, and each file is added to the collection as its own content.
+func handleUploadDirectory(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		keyHash := requestKeyHash(c)
+		collectionName := c.FormValue("collection_name")
+
+		if collectionName == "" {
+			collectionName = node.Config.Node.DefaultCollectionName
+		}
+		policy, err := node.PolicyForCollection(collectionName)
+		if err != nil {
+			return c.JSON(500, DirectoryUploadResponse{
+				Status:  "error",
+				Message: err.Error(),
+			})
+		}
+
+		form, err := c.MultipartForm()
+		if err != nil {
+			return c.JSON(400, DirectoryUploadResponse{
+				Status:  "error",
+				Message: "Please upload the files as multipart form data",
+			})
+		}
+		fileHeaders := form.File["data"]
+		paths := form.Value["path"]
+		if len(fileHeaders) == 0 {
+			return c.JSON(400, DirectoryUploadResponse{
+				Status:  "error",
+				Message: "Please provide at least one data file",
+			})
+		}
+		if len(paths) != 0 && len(paths) != len(fileHeaders) {
+			return c.JSON(400, DirectoryUploadResponse{
+				Status:  "error",
+				Message: "Please provide one path per data file",
+			})
+		}
+
+		var files []jobs.DirectoryFile
+		for i, fileHeader := range fileHeaders {
+			path := fileHeader.Filename
+			if len(paths) != 0 {
+				path = paths[i]
+			}
+			if path, err = core.CleanRelativePath(path); err != nil {
+				return c.JSON(400, DirectoryUploadResponse{
+					Status:  "error",
+					Message: err.Error(),
+				})
+			}
+
+			src, err := fileHeader.Open()
+			if err != nil {
+				return err
+			}
+			addNode, err := node.Node.AddPinFile(c.Request().Context(), src, nil)
+			src.Close()
+			if err != nil {
+				return c.JSON(500, DirectoryUploadResponse{
+					Status:  "error",
+					Message: "Error adding the file " + path + " to IPFS",
+				})
+			}
+			files = append(files, jobs.DirectoryFile{Path: path, Size: fileHeader.Size, Node: addNode})
+		}
+
+		upload, err := jobs.AddDirectoryToCollection(node, files, collectionName, keyHash, policy)
+		if err != nil {
+			return c.JSON(500, DirectoryUploadResponse{
+				Status:  "error",
+				Message: "Error adding the directory: " + err.Error(),
+			})
+		}
+		return c.JSON(200, newDirectoryUploadResponse(upload))
+	}
+}
+
+// The function `handleGetDirectory` returns a directory upload with the paths and contents of its files.
+func handleGetDirectory(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var upload jobs.DirectoryUpload
+		node.DB.Model(&core.Collection{}).Where("uuid = ? and api_key_hash = ?", c.Param("uuid"), requestKeyHash(c)).Find(&upload.Collection)
+		if upload.Collection.ID == 0 {
+			return c.JSON(404, map[string]interface{}{
+				"message": "Directory not found",
+			})
+		}
+
+		node.DB.Model(&core.Content{}).Where("cid = ? and collection_name = ? and api_key_hash = ?", upload.Collection.Cid, upload.Collection.Name, upload.Collection.ApiKeyHash).
+			Order("id desc").Limit(1).Find(&upload.Root)
+		node.DB.Model(&core.CollectionRef{}).Where("collection = ?", upload.Collection.ID).Order("id asc").Find(&upload.Refs)
+		contents := make(map[int64]core.Content)
+		var contentIds []uint64
+		for _, ref := range upload.Refs {
+			contentIds = append(contentIds, ref.Content)
+		}
+		var found []core.Content
+		if len(contentIds) > 0 {
+			node.DB.Model(&core.Content{}).Where("id in ?", contentIds).Find(&found)
+		}
+		for _, content := range found {
+			contents[content.ID] = content
+		}
+		for _, ref := range upload.Refs {
+			upload.Contents = append(upload.Contents, contents[int64(ref.Content)])
+		}
+
+		response := newDirectoryUploadResponse(upload)
+		response.Message = "Directory found"
+		return c.JSON(200, response)
+	}
+}
+
+func newDirectoryUploadResponse(upload jobs.DirectoryUpload) DirectoryUploadResponse {
+	response := DirectoryUploadResponse{
+		Status:         "success",
+		Message:        "Directory uploaded and pinned successfully. Please take note of the ids.",
+		CollectionUuid: upload.Collection.UUID,
+		RootCid:        upload.Collection.Cid,
+		RootContentId:  upload.Root.ID,
+	}
+	for i, content := range upload.Contents {
+		response.Contents = append(response.Contents, content)
+
+		file := DirectoryFileResponse{ContentId: content.ID, Cid: content.Cid, Size: content.Size}
+		if i < len(upload.Refs) && upload.Refs[i].Path != nil {
+			file.Path = *upload.Refs[i].Path
+		}
+		response.Files = append(response.Files, file)
+	}
+	return response
+}
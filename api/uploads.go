@@ -0,0 +1,366 @@
+package api
+
+import (
+	"encoding/base64"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/application-research/edge-ur/core"
+	"github.com/application-research/edge-ur/jobs"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	tusVersion       = "1.0.0"
+	tusExtensions    = "creation,creation-with-upload,termination,expiration"
+	tusContentType   = "application/offset+octet-stream"
+	uploadsRoutePath = "/api/v1/uploads/"
+)
+
+// uploadLocks serializes the writes to the staged data of an upload, a client retrying a PATCH while the previous one
+// is still running must not interleave its data with it. Uploads share a fixed set of locks.
+var uploadLocks [64]sync.Mutex
+
+// ConfigureUploadsRouter configures the resumable upload routes, an implementation of the tus 1.0 protocol. The data
+// is staged on disk until the upload is complete, the file is then added to its collection in the background.
+func ConfigureUploadsRouter(e *echo.Group, node *core.LightNode) {
+	uploads := e.Group("/uploads", scopeByMethod(core.ScopeRead, core.ScopeUpload))
+	uploads.OPTIONS("", handleUploadOptions(node))
+	uploads.POST("", handleCreateUpload(node), uploadLimits(node))
+	uploads.HEAD("/:uuid", handleUploadOffset(node))
+	uploads.PATCH("/:uuid", handleUploadChunk(node))
+	uploads.DELETE("/:uuid", handleTerminateUpload(node))
+	uploads.GET("/:uuid", handleGetUpload(node))
+}
+
+// The function `handleUploadOptions` tells tus clients which version and extensions of the protocol are supported.
+func handleUploadOptions(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		header := c.Response().Header()
+		header.Set("Tus-Resumable", tusVersion)
+		header.Set("Tus-Version", tusVersion)
+		header.Set("Tus-Extension", tusExtensions)
+		if node.Config.Common.MaxUploadSize > 0 {
+			header.Set("Tus-Max-Size", strconv.FormatInt(node.Config.Common.MaxUploadSize, 10))
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// The function `handleCreateUpload` creates an upload of the length given in `Upload-Length`. The `filename` and
+// `collection_name` keys of `Upload-Metadata` name the file and pick its collection. Data sent along with the
+// request is written right away.
+func handleCreateUpload(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !tusResumable(c) {
+			return c.NoContent(http.StatusPreconditionFailed)
+		}
+
+		length, err := strconv.ParseInt(c.Request().Header.Get("Upload-Length"), 10, 64)
+		if err != nil || length < 0 {
+			return c.JSON(400, map[string]interface{}{
+				"message": "Please provide the length of the upload in Upload-Length",
+			})
+		}
+		if node.Config.Common.MaxUploadSize > 0 && length > node.Config.Common.MaxUploadSize {
+			return c.JSON(413, map[string]interface{}{
+				"message": "Upload is larger than the max upload size of " + strconv.FormatInt(node.Config.Common.MaxUploadSize, 10) + " bytes",
+			})
+		}
+
+		keyHash := requestKeyHash(c)
+
+		metadataHeader := c.Request().Header.Get("Upload-Metadata")
+		metadata, err := parseUploadMetadata(metadataHeader)
+		if err != nil {
+			return c.JSON(400, map[string]interface{}{
+				"message": "Invalid Upload-Metadata: " + err.Error(),
+			})
+		}
+
+		uploadUuid, err := uuid.NewUUID()
+		if err != nil {
+			return err
+		}
+		upload := core.Upload{
+			Uuid:           uploadUuid.String(),
+			Name:           metadata["filename"],
+			CollectionName: metadata["collection_name"],
+			ApiKeyHash:     keyHash,
+			Length:         length,
+			Metadata:       metadataHeader,
+			Status:         core.UploadStatusUploading,
+			ExpiresAt:      uploadExpiry(node),
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
+		}
+		if upload.Name == "" {
+			upload.Name = upload.Uuid
+		}
+		if upload.CollectionName == "" {
+			upload.CollectionName = node.Config.Node.DefaultCollectionName
+		}
+
+		stagedPath := node.UploadPath(upload.Uuid)
+		if err := os.MkdirAll(filepath.Dir(stagedPath), 0755); err != nil {
+			return err
+		}
+		stagedFile, err := os.Create(stagedPath)
+		if err != nil {
+			return err
+		}
+		stagedFile.Close()
+
+		if err := node.DB.Create(&upload).Error; err != nil {
+			os.Remove(stagedPath)
+			return err
+		}
+
+		c.Response().Header().Set("Location", uploadsRoutePath+upload.Uuid)
+		if c.Request().Header.Get("Content-Type") == tusContentType {
+			// creation-with-upload, a failed write is picked up by the client with a HEAD request
+			if err := writeUploadChunk(node, &upload, c.Request().Body); err != nil {
+				log.Warnf("failed to write the data of upload %s: %s", upload.Uuid, err)
+			}
+		} else if upload.Length == 0 {
+			if err := completeUpload(node, &upload); err != nil {
+				return err
+			}
+		}
+
+		setUploadHeaders(c, upload)
+		return c.NoContent(http.StatusCreated)
+	}
+}
+
+// The function `handleUploadOffset` returns how much of an upload was received, so the client knows where to resume.
+func handleUploadOffset(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !tusResumable(c) {
+			return c.NoContent(http.StatusPreconditionFailed)
+		}
+		upload, err := findUpload(c, node)
+		if err != nil {
+			return c.NoContent(404)
+		}
+
+		setUploadHeaders(c, upload)
+		c.Response().Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		if upload.Metadata != "" {
+			c.Response().Header().Set("Upload-Metadata", upload.Metadata)
+		}
+		c.Response().Header().Set("Cache-Control", "no-store")
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+// The function `handleUploadChunk` appends the body of the request to an upload at the offset given in
+// `Upload-Offset`. Once all the data arrived, the file is added to its collection in the background.
+func handleUploadChunk(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !tusResumable(c) {
+			return c.NoContent(http.StatusPreconditionFailed)
+		}
+		if c.Request().Header.Get("Content-Type") != tusContentType {
+			return c.JSON(415, map[string]interface{}{
+				"message": "Content-Type must be " + tusContentType,
+			})
+		}
+		offset, err := strconv.ParseInt(c.Request().Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			return c.JSON(400, map[string]interface{}{
+				"message": "Please provide the offset of the data in Upload-Offset",
+			})
+		}
+
+		unlock := lockUpload(c.Param("uuid"))
+		defer unlock()
+
+		upload, err := findUpload(c, node)
+		if err != nil {
+			return c.NoContent(404)
+		}
+		if upload.Status != core.UploadStatusUploading {
+			return c.JSON(409, map[string]interface{}{
+				"message": "Upload is already complete",
+			})
+		}
+		if offset != upload.Offset {
+			return c.JSON(409, map[string]interface{}{
+				"message": "Upload-Offset does not match the offset of the upload, " + strconv.FormatInt(upload.Offset, 10),
+			})
+		}
+
+		if err := writeUploadChunk(node, &upload, c.Request().Body); err != nil {
+			return err
+		}
+		setUploadHeaders(c, upload)
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// The function `handleTerminateUpload` removes an unfinished upload and its staged data.
+func handleTerminateUpload(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !tusResumable(c) {
+			return c.NoContent(http.StatusPreconditionFailed)
+		}
+
+		unlock := lockUpload(c.Param("uuid"))
+		defer unlock()
+
+		upload, err := findUpload(c, node)
+		if err != nil {
+			return c.NoContent(404)
+		}
+		if upload.Status != core.UploadStatusUploading {
+			return c.JSON(409, map[string]interface{}{
+				"message": "Only unfinished uploads can be terminated",
+			})
+		}
+		if err := node.DB.Delete(&upload).Error; err != nil {
+			return err
+		}
+		os.Remove(node.UploadPath(upload.Uuid))
+
+		c.Response().Header().Set("Tus-Resumable", tusVersion)
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// The function `handleGetUpload` returns the status of an upload, with the id of its content once the upload was
+// added to its collection.
+func handleGetUpload(node *core.LightNode) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		upload, err := findUpload(c, node)
+		if err != nil {
+			return c.JSON(404, map[string]interface{}{
+				"message": "Upload not found",
+			})
+		}
+		return c.JSON(200, upload)
+	}
+}
+
+// writeUploadChunk appends data to the staged file of an upload and records the new offset. What was written before
+// the data stopped is kept, so a dropped connection only loses the data in flight.
+func writeUploadChunk(node *core.LightNode, upload *core.Upload, data io.Reader) error {
+	stagedFile, err := os.OpenFile(node.UploadPath(upload.Uuid), os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer stagedFile.Close()
+
+	// drop whatever an interrupted write left past the recorded offset
+	if err := stagedFile.Truncate(upload.Offset); err != nil {
+		return err
+	}
+	if _, err := stagedFile.Seek(upload.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	written, copyErr := io.Copy(stagedFile, io.LimitReader(data, upload.Length-upload.Offset))
+	if err := stagedFile.Sync(); err != nil {
+		return err
+	}
+
+	upload.Offset += written
+	upload.ExpiresAt = uploadExpiry(node)
+	upload.UpdatedAt = time.Now()
+	if err := node.DB.Model(&core.Upload{}).Where("id = ?", upload.ID).Updates(map[string]interface{}{
+		"offset":     upload.Offset,
+		"expires_at": upload.ExpiresAt,
+		"updated_at": upload.UpdatedAt,
+	}).Error; err != nil {
+		return err
+	}
+	if copyErr != nil {
+		return copyErr
+	}
+
+	if upload.Offset == upload.Length {
+		return completeUpload(node, upload)
+	}
+	return nil
+}
+
+// completeUpload queues the finalizer that adds the received file to its collection.
+func completeUpload(node *core.LightNode, upload *core.Upload) error {
+	upload.Status = core.UploadStatusProcessing
+	if err := node.DB.Model(&core.Upload{}).Where("id = ?", upload.ID).Update("status", upload.Status).Error; err != nil {
+		return err
+	}
+	return jobs.Enqueue(node, jobs.NewUploadFinalizerProcessor(node, *upload))
+}
+
+// findUpload loads the upload of the request, uploads are only visible to the api key that created them.
+func findUpload(c echo.Context, node *core.LightNode) (core.Upload, error) {
+	var upload core.Upload
+	err := node.DB.Model(&core.Upload{}).Where("uuid = ? and api_key_hash = ?", c.Param("uuid"), requestKeyHash(c)).First(&upload).Error
+	return upload, err
+}
+
+// tusResumable tells if the request was made with the supported version of the tus protocol.
+func tusResumable(c echo.Context) bool {
+	c.Response().Header().Set("Tus-Resumable", tusVersion)
+	if c.Request().Header.Get("Tus-Resumable") != tusVersion {
+		c.Response().Header().Set("Tus-Version", tusVersion)
+		return false
+	}
+	return true
+}
+
+func setUploadHeaders(c echo.Context, upload core.Upload) {
+	header := c.Response().Header()
+	header.Set("Tus-Resumable", tusVersion)
+	header.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.Status == core.UploadStatusUploading {
+		header.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+func uploadExpiry(node *core.LightNode) time.Time {
+	expiry := time.Duration(node.Config.Common.UploadExpiry) * time.Hour
+	if expiry <= 0 {
+		expiry = 24 * time.Hour
+	}
+	return time.Now().Add(expiry)
+}
+
+// lockUpload takes the write lock of an upload and returns the function releasing it.
+func lockUpload(uploadUuid string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(uploadUuid))
+	lk := &uploadLocks[h.Sum32()%uint32(len(uploadLocks))]
+	lk.Lock()
+	return lk.Unlock
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header, comma separated keys each followed by a base64 value.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, " ", 2)
+		if len(parts) == 1 {
+			metadata[parts[0]] = ""
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, err
+		}
+		metadata[parts[0]] = string(value)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/application-research/edge-ur/core"
+	"github.com/application-research/edge-ur/jobs"
+	"github.com/labstack/echo/v4"
+)
+
+func newUploadsTest(t *testing.T) (*core.LightNode, *echo.Echo) {
+	t.Helper()
+	node := newTestNode(t, config.EdgeConfig{})
+	e := echo.New()
+	group := e.Group("/api/v1", func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(authResultKey, core.AuthResult{Validated: true, Role: core.RoleUploader, KeyHash: "key"})
+			return next(c)
+		}
+	})
+	ConfigureUploadsRouter(group, node)
+	return node, e
+}
+
+func tusRequest(e *echo.Echo, method string, target string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func createUpload(t *testing.T, e *echo.Echo, length int) string {
+	t.Helper()
+	rec := tusRequest(e, http.MethodPost, "/api/v1/uploads", "", map[string]string{"Upload-Length": strconv.Itoa(length)})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating the upload got %d: %s", rec.Code, rec.Body)
+	}
+	return rec.Header().Get("Location")
+}
+
+func patchUpload(e *echo.Echo, location string, offset int, data string) *httptest.ResponseRecorder {
+	return tusRequest(e, http.MethodPatch, location, data, map[string]string{
+		"Content-Type":  tusContentType,
+		"Upload-Offset": strconv.Itoa(offset),
+	})
+}
+
+func findTestUpload(t *testing.T, node *core.LightNode, location string) core.Upload {
+	t.Helper()
+	var upload core.Upload
+	node.DB.Model(&core.Upload{}).Where("uuid = ?", strings.TrimPrefix(location, uploadsRoutePath)).First(&upload)
+	return upload
+}
+
+func assertFinalizerQueued(t *testing.T, node *core.LightNode, upload core.Upload) {
+	t.Helper()
+	if upload.Status != core.UploadStatusProcessing {
+		t.Fatalf("upload is %s, expected it to be processed", upload.Status)
+	}
+	var queued int64
+	node.DB.Model(&core.Job{}).Where("type = ? and job_key = ?", jobs.JobTypeUploadFinalizer, upload.Uuid).Count(&queued)
+	if queued != 1 {
+		t.Fatalf("queued %d finalizers", queued)
+	}
+}
+
+func TestUploadChunkOffsetMismatch(t *testing.T) {
+	node, e := newUploadsTest(t)
+	location := createUpload(t, e, 10)
+
+	if rec := patchUpload(e, location, 0, "hello"); rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "5" {
+		t.Fatalf("first chunk got %d at offset %s", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+	// a chunk sent again, or sent ahead, does not match the offset of the upload
+	for _, offset := range []int{0, 7} {
+		if rec := patchUpload(e, location, offset, "world"); rec.Code != http.StatusConflict {
+			t.Fatalf("chunk at offset %d got %d", offset, rec.Code)
+		}
+	}
+	if rec := tusRequest(e, http.MethodHead, location, "", nil); rec.Header().Get("Upload-Offset") != "5" || rec.Header().Get("Upload-Length") != "10" {
+		t.Fatalf("upload is at %s of %s", rec.Header().Get("Upload-Offset"), rec.Header().Get("Upload-Length"))
+	}
+
+	if rec := patchUpload(e, location, 5, "world"); rec.Code != http.StatusNoContent {
+		t.Fatalf("last chunk got %d", rec.Code)
+	}
+	upload := findTestUpload(t, node, location)
+	assertFinalizerQueued(t, node, upload)
+	if rec := patchUpload(e, location, 10, "!"); rec.Code != http.StatusConflict {
+		t.Fatalf("chunk past the end of a complete upload got %d", rec.Code)
+	}
+}
+
+func TestUploadResumesAfterTruncation(t *testing.T) {
+	node, e := newUploadsTest(t)
+	location := createUpload(t, e, 10)
+	if rec := patchUpload(e, location, 0, "hello"); rec.Code != http.StatusNoContent {
+		t.Fatalf("first chunk got %d", rec.Code)
+	}
+
+	// a write that was cut off left data past the recorded offset
+	upload := findTestUpload(t, node, location)
+	stagedFile, err := os.OpenFile(node.UploadPath(upload.Uuid), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stagedFile.WriteString("wo")
+	stagedFile.Close()
+
+	if rec := patchUpload(e, location, 5, "world"); rec.Code != http.StatusNoContent {
+		t.Fatalf("resumed chunk got %d", rec.Code)
+	}
+	staged, err := os.ReadFile(node.UploadPath(upload.Uuid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(staged) != "helloworld" {
+		t.Fatalf("staged %q", staged)
+	}
+}
+
+func TestCreationWithUpload(t *testing.T) {
+	node, e := newUploadsTest(t)
+	rec := tusRequest(e, http.MethodPost, "/api/v1/uploads", "hello world", map[string]string{
+		"Upload-Length":   "11",
+		"Upload-Metadata": "filename aGVsbG8udHh0,collection_name ZG9jcw==",
+		"Content-Type":    tusContentType,
+	})
+	if rec.Code != http.StatusCreated || rec.Header().Get("Upload-Offset") != "11" {
+		t.Fatalf("creating the upload got %d at offset %s", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+	upload := findTestUpload(t, node, rec.Header().Get("Location"))
+	if upload.Name != "hello.txt" || upload.CollectionName != "docs" || upload.ApiKeyHash != "key" {
+		t.Fatalf("created the upload %+v", upload)
+	}
+	assertFinalizerQueued(t, node, upload)
+	staged, err := os.ReadFile(node.UploadPath(upload.Uuid))
+	if err != nil || string(staged) != "hello world" {
+		t.Fatalf("staged %q: %v", staged, err)
+	}
+}
+
+func TestTerminateUpload(t *testing.T) {
+	node, e := newUploadsTest(t)
+	location := createUpload(t, e, 10)
+	upload := findTestUpload(t, node, location)
+
+	if rec := tusRequest(e, http.MethodDelete, location, "", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("terminating the upload got %d", rec.Code)
+	}
+	if _, err := os.Stat(node.UploadPath(upload.Uuid)); !os.IsNotExist(err) {
+		t.Fatal("the staged data was kept")
+	}
+	if rec := tusRequest(e, http.MethodHead, location, "", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("terminated upload got %d", rec.Code)
+	}
+
+	// a complete upload is being added to its collection and can't be terminated
+	location = createUpload(t, e, 5)
+	patchUpload(e, location, 0, "hello")
+	if rec := tusRequest(e, http.MethodDelete, location, "", nil); rec.Code != http.StatusConflict {
+		t.Fatalf("terminating a complete upload got %d", rec.Code)
+	}
+}
+
+func TestUploadRequiresTusVersion(t *testing.T) {
+	_, e := newUploadsTest(t)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/uploads", nil)
+	req.Header.Set("Upload-Length", "10")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPreconditionFailed || rec.Header().Get("Tus-Version") != tusVersion {
+		t.Fatalf("request without Tus-Resumable got %d", rec.Code)
+	}
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/application-research/edge-ur/core"
+	"github.com/urfave/cli/v2"
+)
+
+// ApiKeyCmd manages the keys of the local auth backend (AUTH_BACKEND=local). It works on the database of the node, so
+// the daemon doesn't have to run.
+func ApiKeyCmd(cfg *config.EdgeConfig) []*cli.Command {
+	var apiKeyCommands []*cli.Command
+
+	apiKeyCmd := &cli.Command{
+		Name:  "api-key",
+		Usage: "Create, list and revoke the api keys of the local auth backend.",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "create",
+				Usage: "Create an api key, the key is only shown once.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "owner",
+						Usage:    "who the key is for",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "role",
+						Usage: "admin, uploader, read-only or sp",
+						Value: core.RoleUploader,
+					},
+					&cli.StringFlag{
+						Name: "description",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					db, err := core.OpenDatabase(*cfg)
+					if err != nil {
+						return err
+					}
+					keys, err := core.NewApiKeyHasher(*cfg)
+					if err != nil {
+						return err
+					}
+					apiKey, key, err := core.CreateApiKey(db, keys, c.String("owner"), c.String("role"), c.String("description"))
+					if err != nil {
+						return err
+					}
+					fmt.Println("id:     ", key.ID)
+					fmt.Println("owner:  ", key.Owner)
+					fmt.Println("role:   ", key.Role)
+					fmt.Println("api key:", apiKey)
+					return nil
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "List the api keys.",
+				Action: func(c *cli.Context) error {
+					db, err := core.OpenDatabase(*cfg)
+					if err != nil {
+						return err
+					}
+					var keys []core.ApiKey
+					db.Model(&core.ApiKey{}).Order("id asc").Find(&keys)
+
+					w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+					fmt.Fprintln(w, "ID\tPREFIX\tOWNER\tROLE\tDESCRIPTION\tCREATED\tLAST USED\tREVOKED")
+					for _, key := range keys {
+						fmt.Fprintf(w, "%d\t%s...\t%s\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Prefix, key.Owner, key.Role, key.Description,
+							key.CreatedAt.Format(time.RFC3339), formatOptionalTime(key.LastUsedAt), formatOptionalTime(key.RevokedAt))
+					}
+					return w.Flush()
+				},
+			},
+			{
+				Name:      "revoke",
+				Usage:     "Revoke an api key, the contents uploaded with it are kept.",
+				ArgsUsage: "<id>",
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return cli.ShowSubcommandHelp(c)
+					}
+					db, err := core.OpenDatabase(*cfg)
+					if err != nil {
+						return err
+					}
+					key, err := core.RevokeApiKey(db, c.Args().First())
+					if err != nil {
+						return err
+					}
+					fmt.Println("revoked api key", key.ID, "of", key.Owner)
+					return nil
+				},
+			},
+		},
+	}
+
+	apiKeyCommands = append(apiKeyCommands, apiKeyCmd)
+
+	return apiKeyCommands
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -28,19 +28,24 @@ func DaemonCmd(cfg *config.EdgeConfig) []*cli.Command {
 			&cli.StringFlag{
 				Name: "port",
 			},
+			&cli.BoolFlag{
+				Name:  "offline",
+				Usage: "run without public networking (no public ip lookup, no bootstrapping)",
+			},
 		},
 
 		Action: func(c *cli.Context) error {
+			if c.Bool("offline") {
+				cfg.Network.Offline = true
+			}
 
 			fmt.Println("OS:", runtime.GOOS)
 			fmt.Println("Architecture:", runtime.GOARCH)
 			fmt.Println("Hostname:", core.GetHostname())
 
-			ip, err := core.GetPublicIP()
-			if err != nil {
-				fmt.Println("Error getting public IP:", err)
+			if cfg.Network.Offline {
+				fmt.Println("Offline mode: public networking is disabled")
 			}
-			fmt.Println("Public IP:", ip)
 			fmt.Println(utils.Blue + "Starting Edge daemon..." + utils.Reset)
 
 			repo := c.String("repo")
@@ -62,10 +67,30 @@ func DaemonCmd(cfg *config.EdgeConfig) []*cli.Command {
 				return err
 			}
 			fmt.Println(utils.Blue + "Setting up the Edge node... Done" + utils.Reset)
+			if !cfg.Network.Offline {
+				fmt.Println("Public IP:", ln.PublicIp)
+			}
+
+			// make sure the blocks the database points to survived the restart
+			if err := core.VerifyLocalBlocks(context.Background(), ln); err != nil {
+				fmt.Println(utils.Red+"Error verifying local blocks:", err.Error()+utils.Reset)
+			}
 
 			core.ScanHostComputeResources(ln, cfg.Node.Repo)
 			//	launch the jobs
+			jobs.NewJobQueue(ln).Start(context.Background())
+			jobs.NewBucketSealer(ln).Start(context.Background())
+			jobs.NewBucketClaimReaper(ln).Start(context.Background())
+			jobs.NewUploadReaper(ln).Start(context.Background())
 			go rerunBucketCarGen(ln)
+			if cfg.Delta.Enabled {
+				go submitReadyBuckets(ln)
+				tracker, err := jobs.NewDealTracker(ln)
+				if err != nil {
+					return err
+				}
+				tracker.Start(context.Background())
+			}
 
 			// launch the API node
 			fmt.Printf(`
@@ -97,14 +122,24 @@ func DaemonCmd(cfg *config.EdgeConfig) []*cli.Command {
 
 func rerunBucketCarGen(ln *core.LightNode) {
 
-	// query db for all "processing" jobs and retry
+	// query db for all "processing" jobs and retry. buckets that still have a job in the queue are not queued twice.
 	var buckets []core.Bucket
 	ln.DB.Model(&core.Bucket{}).Where("status = ?", "processing").Find(&buckets)
-	dispatcher := jobs.CreateNewDispatcher()
-	var numJobs int
 	for _, bucket := range buckets {
-		numJobs += 1
-		dispatcher.AddJob(jobs.NewBucketCarGenerator(ln, bucket))
+		if err := jobs.Enqueue(ln, jobs.NewBucketCarGenerator(ln, bucket)); err != nil {
+			fmt.Println("Error queueing car generation for bucket", bucket.Uuid, err)
+		}
+	}
+}
+
+func submitReadyBuckets(ln *core.LightNode) {
+
+	// ready buckets without a deal were made before deal-making was turned on, or the node went down in between.
+	var buckets []core.Bucket
+	ln.DB.Model(&core.Bucket{}).Where("status = ? and uuid not in (?)", "ready", ln.DB.Model(&core.ContentDeal{}).Where("bucket_uuid is not null").Select("bucket_uuid")).Find(&buckets)
+	for _, bucket := range buckets {
+		if err := jobs.Enqueue(ln, jobs.NewDealMakerProcessor(ln, bucket)); err != nil {
+			fmt.Println("Error queueing deal for bucket", bucket.Uuid, err)
+		}
 	}
-	dispatcher.Start(numJobs)
 }
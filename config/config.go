@@ -15,16 +15,22 @@ var (
 
 type EdgeConfig struct {
 	Node struct {
-		Name                  string `env:"NODE_NAME" envDefault:"edge-urid"`
-		Description           string `env:"NODE_DESCRIPTION"`
-		Type                  string `env:"NODE_TYPE"`
-		DbDsn                 string `env:"DB_DSN" envDefault:"edge-urid.db"`
-		Repo                  string `env:"REPO" envDefault:"./whypfs"`
-		DsRepo                string `env:"DS_REPO" envDefault:"./whypfs-ds"`
-		GwHost                string `env:"HOST" envDefault:"localhost"`
-		Port                  int    `env:"PORT" envDefault:"1414"`
-		AdminApiKey           string `env:"ADMIN_API_KEY" envDefault:"admin"`
-		DefaultCollectionName string `env:"DEFAULT_COLLECTION_NAME" envDefault:"default"`
+		Name                  string   `env:"NODE_NAME" envDefault:"edge-urid"`
+		Description           string   `env:"NODE_DESCRIPTION"`
+		Type                  string   `env:"NODE_TYPE"`
+		DbDsn                 string   `env:"DB_DSN" envDefault:"edge-urid.db"`
+		Repo                  string   `env:"REPO" envDefault:"./whypfs"`
+		DsRepo                string   `env:"DS_REPO" envDefault:"./whypfs-ds"`
+		DsType                string   `env:"DS_TYPE" envDefault:"leveldb"`
+		StagingDir            string   `env:"STAGING_DIR" envDefault:"./whypfs-staging"`
+		GwHost                string   `env:"HOST" envDefault:"localhost"`
+		Port                  int      `env:"PORT" envDefault:"1414"`
+		AdminApiKey           string   `env:"ADMIN_API_KEY"`
+		AdminApiKeys          []string `env:"ADMIN_API_KEYS" envSeparator:","` // more admin api keys, next to ADMIN_API_KEY
+		ApiKeySaltFile        string   `env:"API_KEY_SALT_FILE"`               // secret salt of the stored api key hashes, created in the repo when not set
+		PublicUrl             string   `env:"PUBLIC_URL"`                      // base url storage providers pull pieces from, defaults to the public ip and port
+		DefaultCollectionName string   `env:"DEFAULT_COLLECTION_NAME" envDefault:"default"`
+		UrlSigningKey         string   `env:"URL_SIGNING_KEY"` // pem file of the key signed gateway urls are signed with, created in the repo when not set
 	}
 
 	Common struct {
@@ -32,6 +38,59 @@ type EdgeConfig struct {
 		MaxSizeToSplit             int64 `env:"MAX_SIZE_TO_SPLIT" envDefault:"32000000000"`
 		SplitSize                  int64 `env:"SPLIT_SIZE" envDefault:"5048576000"`
 		CapacityLimitPerKeyInBytes int64 `env:"CAPACITY_LIMIT_PER_KEY_IN_BYTES" envDefault:"0"`
+		DataSegmentAggregation     bool  `env:"DATA_SEGMENT_AGGREGATION" envDefault:"false"`   // build bucket pieces as FRC-0058 aggregates with inclusion proofs
+		ReplicationFactor          int   `env:"REPLICATION_FACTOR" envDefault:"1"`             // storage providers a ready bucket is handed to
+		BucketClaimLease           int   `env:"BUCKET_CLAIM_LEASE_SECONDS" envDefault:"86400"` // how long a storage provider holds a claimed bucket before confirming it
+		MaxUploadSize              int64 `env:"MAX_UPLOAD_SIZE" envDefault:"0"`                // largest resumable upload in bytes, 0 means no limit
+		UploadExpiry               int   `env:"UPLOAD_EXPIRY_HOURS" envDefault:"24"`           // unfinished resumable uploads are removed after this long without data
+		ArchiveMaxEntries          int   `env:"ARCHIVE_MAX_ENTRIES" envDefault:"100000"`       // files and directories of an unpacked archive
+		ArchiveMaxSize             int64 `env:"ARCHIVE_MAX_SIZE" envDefault:"32000000000"`     // total unpacked bytes of an archive
+		ArchiveMaxRatio            int64 `env:"ARCHIVE_MAX_RATIO" envDefault:"100"`            // unpacked bytes per archive byte, 0 disables the check
+		FetchTimeout               int   `env:"FETCH_TIMEOUT_MINUTES" envDefault:"60"`         // how long fetching the dag of a cid may take
+		FetchBlockTimeout          int   `env:"FETCH_BLOCK_TIMEOUT_SECONDS" envDefault:"120"`  // how long to wait for a single block of a fetched dag
+		SignedUrlMaxExpiry         int   `env:"SIGNED_URL_MAX_EXPIRY_HOURS" envDefault:"168"`  // longest lifetime of a signed gateway url
+	}
+
+	Quota struct {
+		MaxObjects        int64 `env:"QUOTA_MAX_OBJECTS_PER_KEY" envDefault:"0"`     // contents per api key, 0 means no limit
+		UploadsPerMinute  int64 `env:"RATE_LIMIT_UPLOADS_PER_MINUTE" envDefault:"0"` // upload requests per api key, 0 means no limit
+		EgressBytesPerDay int64 `env:"QUOTA_EGRESS_BYTES_PER_DAY" envDefault:"0"`    // gateway bytes per api key, 0 means no limit
+	}
+
+	Jobs struct {
+		Workers         int    `env:"JOB_WORKERS" envDefault:"4"`
+		MaxAttempts     int    `env:"JOB_MAX_ATTEMPTS" envDefault:"5"`
+		BackoffSeconds  int    `env:"JOB_BACKOFF_SECONDS" envDefault:"30"`
+		TypeConcurrency string `env:"JOB_TYPE_CONCURRENCY" envDefault:"bucket-car-generator:1,splitter:1,cid-fetcher:2"` // type:limit,...
+		SealInterval    int    `env:"BUCKET_SEAL_INTERVAL_SECONDS" envDefault:"60"`                                      // how often open buckets are checked against the max bucket age
+	}
+
+	Network struct {
+		Offline        bool     `env:"OFFLINE" envDefault:"false"` // no public ip lookup, no bootstrapping, no content announcements
+		PublicIp       string   `env:"PUBLIC_IP"`                  // skips the ifconfig.me lookup when set
+		ListenAddrs    []string `env:"LISTEN_ADDRS" envSeparator:"," envDefault:"/ip4/0.0.0.0/tcp/6745,/ip4/0.0.0.0/udp/6746/quic"`
+		AnnounceAddrs  []string `env:"ANNOUNCE_ADDRS" envSeparator:"," envDefault:"/ip4/0.0.0.0/tcp/6745"`
+		BootstrapPeers []string `env:"BOOTSTRAP_PEERS" envSeparator:","` // defaults to the estuary peers
+	}
+
+	Delta struct {
+		Enabled            bool   `env:"DELTA_DEALS_ENABLED" envDefault:"false"` // submit ready buckets to delta as import deals
+		ApiUrl             string `env:"DELTA_API" envDefault:"https://node.delta.store"`
+		ApiKey             string `env:"DELTA_API_KEY"`
+		DealDurationInDays int64  `env:"DELTA_DEAL_DURATION_IN_DAYS" envDefault:"540"`
+		StartEpochInDays   int64  `env:"DELTA_START_EPOCH_IN_DAYS" envDefault:"3"`
+		DealVerifyState    string `env:"DELTA_DEAL_VERIFY_STATE" envDefault:"verified"` // verified or unverified
+		RemoveUnsealedCopy bool   `env:"DELTA_REMOVE_UNSEALED_COPY" envDefault:"false"`
+		AutoRetry          bool   `env:"DELTA_AUTO_RETRY" envDefault:"true"`
+		StatusBackend      string `env:"DEAL_STATUS_BACKEND" envDefault:"delta"` // delta or mock
+		StatusPollInterval int    `env:"DEAL_STATUS_POLL_INTERVAL_SECONDS" envDefault:"300"`
+	}
+
+	Auth struct {
+		Backend      string `env:"AUTH_BACKEND" envDefault:"external"` // external checks keys with AUTH_SVC_API, local with the api_keys table
+		Timeout      int    `env:"AUTH_TIMEOUT_SECONDS" envDefault:"10"`
+		CacheSeconds int    `env:"AUTH_CACHE_SECONDS" envDefault:"60"`      // how long answers of the auth service are reused
+		DefaultRole  string `env:"AUTH_DEFAULT_ROLE" envDefault:"uploader"` // role of the keys the auth service validates
 	}
 
 	ExternalApi struct {
@@ -52,6 +111,27 @@ func InitConfig() EdgeConfig {
 	return cfg
 }
 
+// BootstrapPeers returns the peers the node bootstraps with. Offline nodes don't bootstrap at all, and when no
+// peers are configured the estuary peers are used.
+func BootstrapPeers(cfg EdgeConfig) ([]peer.AddrInfo, error) {
+	if cfg.Network.Offline {
+		return nil, nil
+	}
+	if len(cfg.Network.BootstrapPeers) == 0 {
+		return BootstrapEstuaryPeers(), nil
+	}
+
+	var addrs []multiaddr.Multiaddr
+	for _, s := range cfg.Network.BootstrapPeers {
+		ma, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, ma)
+	}
+	return peer.AddrInfosFromP2pAddrs(addrs...)
+}
+
 // BootstrapEstuaryPeers Creating a list of multiaddresses that are used to bootstrap the network.
 func BootstrapEstuaryPeers() []peer.AddrInfo {
 
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+
+	"github.com/application-research/edge-ur/config"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+// ApiKeyHasher hashes api keys with the secret salt of the node. The records of a key are kept under its hash, so the
+// database alone doesn't tell the keys nor lets them be checked.
+type ApiKeyHasher struct {
+	salt []byte
+}
+
+// NewApiKeyHasher loads the salt of the node, a new salt is created in the repo when there is none yet. Changing the
+// salt detaches all the records from their keys.
+func NewApiKeyHasher(cfg config.EdgeConfig) (*ApiKeyHasher, error) {
+	saltPath := cfg.Node.ApiKeySaltFile
+	if saltPath == "" {
+		saltPath = filepath.Join(cfg.Node.Repo, "api-key-salt")
+	}
+
+	salt, err := os.ReadFile(saltPath)
+	if os.IsNotExist(err) {
+		salt, err = createApiKeySalt(saltPath)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load the api key salt %s: %w", saltPath, err)
+	}
+	if len(salt) == 0 {
+		return nil, xerrors.Errorf("the api key salt %s is empty", saltPath)
+	}
+	return &ApiKeyHasher{salt: salt}, nil
+}
+
+func createApiKeySalt(saltPath string) ([]byte, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	salt = []byte(hex.EncodeToString(salt))
+	if err := os.MkdirAll(filepath.Dir(saltPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(saltPath, salt, 0600); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// Hash returns the HMAC-SHA256 of the api key under the salt, hex encoded.
+func (h *ApiKeyHasher) Hash(apiKey string) string {
+	mac := hmac.New(sha256.New, h.salt)
+	mac.Write([]byte(apiKey))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// MigrateApiKeyHashes moves the tables of older nodes, which kept the api key itself with every record, to the hash
+// of the key. The old column is dropped once its rows are moved.
+func MigrateApiKeyHashes(db *gorm.DB, hasher *ApiKeyHasher) error {
+	for _, model := range []interface{}{&Content{}, &Bucket{}, &BucketClaim{}, &Upload{}, &CidFetch{}, &ContentSignatureMeta{}, &Collection{}} {
+		if !db.Migrator().HasColumn(model, "requesting_api_key") {
+			continue
+		}
+
+		var apiKeys []string
+		if err := db.Model(model).Distinct().Where("requesting_api_key <> ''").Pluck("requesting_api_key", &apiKeys).Error; err != nil {
+			return err
+		}
+		for _, apiKey := range apiKeys {
+			err := db.Model(model).Where("requesting_api_key = ?", apiKey).UpdateColumns(map[string]interface{}{
+				"api_key_hash":       hasher.Hash(apiKey),
+				"requesting_api_key": "",
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		if err := db.Migrator().DropColumn(model, "requesting_api_key"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
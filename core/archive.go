@@ -0,0 +1,192 @@
+package core
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	ArchiveFormatTar   = "tar"
+	ArchiveFormatTarGz = "tar.gz"
+	ArchiveFormatZip   = "zip"
+)
+
+var (
+	ErrArchiveFormat   = xerrors.New("the file is not a tar, tar.gz or zip archive")
+	ErrArchiveTooLarge = xerrors.New("the archive unpacks to more than the max archive size")
+	ErrArchiveEntries  = xerrors.New("the archive has more entries than allowed")
+	ErrArchiveRatio    = xerrors.New("the archive is compressed more than allowed")
+)
+
+// ArchiveLimits guard the node against archives that unpack to much more than they weigh. The limits apply to the
+// bytes actually unpacked, the sizes claimed by the archive are not trusted.
+type ArchiveLimits struct {
+	MaxEntries int   // files and directories
+	MaxSize    int64 // total unpacked bytes
+	MaxRatio   int64 // unpacked bytes per archive byte, 0 disables the check
+}
+
+// ArchiveFileFunc is called for every regular file of an archive with its path and content.
+type ArchiveFileFunc func(path string, r io.Reader) error
+
+// DetectArchiveFormat tells the format of an archive from its first bytes.
+func DetectArchiveFormat(r io.ReaderAt) (string, error) {
+	header := make([]byte, 512)
+	n, err := r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return ArchiveFormatZip, nil
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return ArchiveFormatTarGz, nil
+	case len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar")):
+		return ArchiveFormatTar, nil
+	default:
+		return "", ErrArchiveFormat
+	}
+}
+
+// WalkArchive calls fn for every regular file of the archive, in the order of the archive. Directories are implied by
+// the paths of the files; links, devices and other special entries are skipped. Paths leaving the archive are
+// rejected.
+func WalkArchive(r io.ReaderAt, size int64, format string, limits ArchiveLimits, fn ArchiveFileFunc) error {
+	budget := &archiveBudget{limits: limits, remaining: limits.MaxSize}
+	if limits.MaxRatio > 0 && (budget.remaining <= 0 || size*limits.MaxRatio < budget.remaining) {
+		budget.remaining = size * limits.MaxRatio
+		budget.ratioBound = true
+	}
+
+	switch format {
+	case ArchiveFormatZip:
+		return walkZip(r, size, budget, fn)
+	case ArchiveFormatTar:
+		return walkTar(io.NewSectionReader(r, 0, size), budget, fn)
+	case ArchiveFormatTarGz:
+		gz, err := gzip.NewReader(bufio.NewReader(io.NewSectionReader(r, 0, size)))
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return walkTar(gz, budget, fn)
+	default:
+		return ErrArchiveFormat
+	}
+}
+
+// CheckArchive unpacks an archive without keeping anything, so an archive that breaks a limit or has a path leaving
+// the archive is rejected before any of its files is added to the node.
+func CheckArchive(r io.ReaderAt, size int64, format string, limits ArchiveLimits) error {
+	return WalkArchive(r, size, format, limits, func(path string, r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	})
+}
+
+func walkTar(r io.Reader, budget *archiveBudget, fn ArchiveFileFunc) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := budget.entry(); err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		path, err := CleanRelativePath(header.Name)
+		if err != nil {
+			return err
+		}
+		if err := fn(path, budget.reader(tr)); err != nil {
+			return err
+		}
+	}
+}
+
+func walkZip(r io.ReaderAt, size int64, budget *archiveBudget, fn ArchiveFileFunc) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, file := range zr.File {
+		if err := budget.entry(); err != nil {
+			return err
+		}
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		if budget.limits.MaxRatio > 0 && file.CompressedSize64 > 0 && file.UncompressedSize64/file.CompressedSize64 > uint64(budget.limits.MaxRatio) {
+			return ErrArchiveRatio
+		}
+		path, err := CleanRelativePath(file.Name)
+		if err != nil {
+			return err
+		}
+
+		fr, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(path, budget.reader(fr))
+		fr.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveBudget counts the entries and the unpacked bytes of an archive against its limits.
+type archiveBudget struct {
+	limits     ArchiveLimits
+	entries    int
+	remaining  int64
+	ratioBound bool // the remaining bytes are bound by the ratio rather than the max size
+}
+
+func (b *archiveBudget) entry() error {
+	b.entries++
+	if b.limits.MaxEntries > 0 && b.entries > b.limits.MaxEntries {
+		return ErrArchiveEntries
+	}
+	return nil
+}
+
+func (b *archiveBudget) reader(r io.Reader) io.Reader {
+	if b.limits.MaxSize <= 0 && !b.ratioBound {
+		return r // no limits
+	}
+	return &budgetReader{r: r, budget: b}
+}
+
+// budgetReader fails the read that goes past the remaining bytes of the archive.
+type budgetReader struct {
+	r      io.Reader
+	budget *archiveBudget
+}
+
+func (br *budgetReader) Read(p []byte) (int, error) {
+	n, err := br.r.Read(p)
+	br.budget.remaining -= int64(n)
+	if br.budget.remaining < 0 {
+		if br.budget.ratioBound {
+			return n, ErrArchiveRatio
+		}
+		return n, ErrArchiveTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+)
+
+type tarEntry struct {
+	name string
+	size int
+}
+
+func buildTar(t *testing.T, entries ...tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: e.name, Mode: 0644, Size: int64(e.size), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(make([]byte, e.size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCheckArchive(t *testing.T) {
+	limits := ArchiveLimits{MaxEntries: 2, MaxSize: 1000}
+	cases := []struct {
+		name    string
+		entries []tarEntry
+		ok      bool
+	}{
+		{"within the limits", []tarEntry{{"a.txt", 100}, {"dir/b.txt", 100}}, true},
+		{"too many entries", []tarEntry{{"a.txt", 1}, {"b.txt", 1}, {"c.txt", 1}}, false},
+		{"too large", []tarEntry{{"a.txt", 600}, {"b.txt", 600}}, false},
+		{"path leaving the archive", []tarEntry{{"a.txt", 1}, {"../b.txt", 1}}, false},
+	}
+	for _, tc := range cases {
+		archive := buildTar(t, tc.entries...)
+		format, err := DetectArchiveFormat(bytes.NewReader(archive))
+		if err != nil || format != ArchiveFormatTar {
+			t.Fatalf("%s: detected %q: %v", tc.name, format, err)
+		}
+		err = CheckArchive(bytes.NewReader(archive), int64(len(archive)), format, limits)
+		if tc.ok && err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if !tc.ok && err == nil {
+			t.Fatalf("%s: the archive was accepted", tc.name)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+const (
+	AuthBackendExternal = "external"
+	AuthBackendLocal    = "local"
+
+	apiKeyPrefix = "EDGE"
+)
+
+// Roles of api keys, a role grants a set of scopes.
+const (
+	RoleAdmin    = "admin"
+	RoleUploader = "uploader"
+	RoleReadOnly = "read-only"
+	RoleSP       = "sp"
+)
+
+// Scopes the routes require.
+const (
+	ScopeRead   = "read"   // status, retrievals and listings of the own contents
+	ScopeUpload = "upload" // adding contents, uploads, fetches and signed urls
+	ScopeClaim  = "claim"  // claiming buckets as a storage provider
+	ScopeAdmin  = "admin"  // policies, buckets and collections of the node
+)
+
+var roleScopes = map[string][]string{
+	RoleAdmin:    {ScopeRead, ScopeUpload, ScopeClaim, ScopeAdmin},
+	RoleUploader: {ScopeRead, ScopeUpload},
+	RoleReadOnly: {ScopeRead},
+	RoleSP:       {ScopeRead, ScopeClaim},
+}
+
+// ValidRole tells if role is one of the roles api keys can have.
+func ValidRole(role string) bool {
+	_, ok := roleScopes[role]
+	return ok
+}
+
+// AuthResult is the outcome of checking an api key. Owner is only known to the local backend.
+type AuthResult struct {
+	Validated bool   `json:"validated"`
+	Details   string `json:"details"`
+	Owner     string `json:"owner,omitempty"`
+	Role      string `json:"role,omitempty"`
+	KeyHash   string `json:"-"` // what the records of the key are kept under
+}
+
+// HasScope tells if the role of the key grants scope.
+func (r AuthResult) HasScope(scope string) bool {
+	if !r.Validated {
+		return false
+	}
+	for _, granted := range roleScopes[r.Role] {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator checks the api keys of the requests. An error means the key could not be checked, an unknown or
+// revoked key is a result that is not validated.
+type Authenticator interface {
+	Authenticate(ctx context.Context, apiKey string) (AuthResult, error)
+}
+
+// NewAuthenticator returns the authenticator of the configured auth backend. The admin api keys of the config are
+// accepted whatever the backend says.
+func NewAuthenticator(cfg config.EdgeConfig, db *gorm.DB, hasher *ApiKeyHasher) (Authenticator, error) {
+	if !ValidRole(cfg.Auth.DefaultRole) {
+		return nil, xerrors.Errorf("unknown default role %q", cfg.Auth.DefaultRole)
+	}
+
+	var backend Authenticator
+	switch cfg.Auth.Backend {
+	case AuthBackendExternal, "":
+		backend = NewExternalAuthenticator(cfg.ExternalApi.AuthSvcUrl, time.Duration(cfg.Auth.Timeout)*time.Second, time.Duration(cfg.Auth.CacheSeconds)*time.Second)
+	case AuthBackendLocal:
+		backend = NewLocalAuthenticator(db, hasher)
+	default:
+		return nil, xerrors.Errorf("unknown auth backend %q, use %s or %s", cfg.Auth.Backend, AuthBackendExternal, AuthBackendLocal)
+	}
+
+	adminKeys := make(map[string]bool)
+	for _, adminKey := range append([]string{cfg.Node.AdminApiKey}, cfg.Node.AdminApiKeys...) {
+		// a key that is easy to guess would hand every scope of the node to anyone
+		if adminKey = strings.TrimSpace(adminKey); adminKey == "admin" {
+			return nil, xerrors.Errorf("the admin api key %q is not allowed, set ADMIN_API_KEY to a secret", adminKey)
+		}
+		if adminKey != "" {
+			adminKeys[hasher.Hash(adminKey)] = true
+		}
+	}
+	return &roleAuthenticator{backend: backend, hasher: hasher, adminKeys: adminKeys, defaultRole: cfg.Auth.DefaultRole}, nil
+}
+
+// roleAuthenticator gives the admin role to the admin api keys, and the default role to the keys of backends that
+// don't know about roles. It also hashes the validated keys, so the key itself doesn't go past the auth layer.
+type roleAuthenticator struct {
+	backend     Authenticator
+	hasher      *ApiKeyHasher
+	adminKeys   map[string]bool // hashes of the admin api keys
+	defaultRole string
+}
+
+func (a *roleAuthenticator) Authenticate(ctx context.Context, apiKey string) (AuthResult, error) {
+	keyHash := a.hasher.Hash(apiKey)
+	if a.adminKeys[keyHash] {
+		return AuthResult{Validated: true, Owner: RoleAdmin, Role: RoleAdmin, KeyHash: keyHash}, nil
+	}
+	result, err := a.backend.Authenticate(ctx, apiKey)
+	if err != nil {
+		return result, err
+	}
+	if result.Validated {
+		if result.Role == "" {
+			result.Role = a.defaultRole
+		}
+		result.KeyHash = keyHash
+	}
+	return result, nil
+}
+
+// ExternalAuthenticator checks api keys with the check-api-key endpoint of an auth service. The answers are cached
+// for a while so every request doesn't make a round trip, failed checks are not cached.
+type ExternalAuthenticator struct {
+	url     string
+	client  *http.Client
+	results *cache.Cache
+}
+
+func NewExternalAuthenticator(authSvcUrl string, timeout time.Duration, cacheFor time.Duration) *ExternalAuthenticator {
+	return &ExternalAuthenticator{
+		url:     authSvcUrl + "/check-api-key",
+		client:  &http.Client{Timeout: timeout},
+		results: cache.New(cacheFor, 2*cacheFor),
+	}
+}
+
+func (a *ExternalAuthenticator) Authenticate(ctx context.Context, apiKey string) (AuthResult, error) {
+	sum := sha256.Sum256([]byte(apiKey))
+	cacheKey := hex.EncodeToString(sum[:])
+	if cached, ok := a.results.Get(cacheKey); ok {
+		return cached.(AuthResult), nil
+	}
+
+	body, err := json.Marshal(map[string]string{"token": apiKey})
+	if err != nil {
+		return AuthResult{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return AuthResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return AuthResult{}, xerrors.Errorf("auth service unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return AuthResult{}, xerrors.Errorf("auth service answered %s", resp.Status)
+	}
+
+	var authResp struct {
+		Result AuthResult `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
+		authResp.Result = AuthResult{Validated: false, Details: "empty json body"}
+	}
+	a.results.SetDefault(cacheKey, authResp.Result)
+	return authResp.Result, nil
+}
+
+// LocalAuthenticator checks api keys against the api_keys table, for nodes that don't use an auth service.
+type LocalAuthenticator struct {
+	db     *gorm.DB
+	hasher *ApiKeyHasher
+}
+
+func NewLocalAuthenticator(db *gorm.DB, hasher *ApiKeyHasher) *LocalAuthenticator {
+	return &LocalAuthenticator{db: db, hasher: hasher}
+}
+
+func (a *LocalAuthenticator) Authenticate(ctx context.Context, apiKey string) (AuthResult, error) {
+	keyHash := a.hasher.Hash(apiKey)
+	var key ApiKey
+	err := a.db.WithContext(ctx).Model(&ApiKey{}).Where("key_hash = ?", keyHash).Find(&key).Error
+	if err != nil {
+		return AuthResult{}, err
+	}
+	if key.ID == 0 {
+		// keys created before the hashes were salted are kept under a plain sha256, which can't be turned into the
+		// salted hash without the key. They are moved the first time they are used.
+		legacy := sha256.Sum256([]byte(apiKey))
+		err := a.db.WithContext(ctx).Model(&ApiKey{}).Where("key_hash = ?", hex.EncodeToString(legacy[:])).Update("key_hash", keyHash).Error
+		if err != nil {
+			return AuthResult{}, err
+		}
+		if err := a.db.WithContext(ctx).Model(&ApiKey{}).Where("key_hash = ?", keyHash).Find(&key).Error; err != nil {
+			return AuthResult{}, err
+		}
+	}
+	if key.ID == 0 {
+		return AuthResult{Validated: false, Details: "unknown api key"}, nil
+	}
+	if key.RevokedAt != nil {
+		return AuthResult{Validated: false, Details: "revoked api key"}, nil
+	}
+
+	// last use is kept to the minute, so busy keys don't write on every request
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > time.Minute {
+		a.db.Model(&ApiKey{}).Where("id = ?", key.ID).Update("last_used_at", now)
+	}
+	return AuthResult{Validated: true, Owner: key.Owner, Role: key.Role}, nil
+}
+
+// CreateApiKey records a new key of the local auth backend. The key is returned only here, the table keeps its hash.
+func CreateApiKey(db *gorm.DB, hasher *ApiKeyHasher, owner string, role string, description string) (string, ApiKey, error) {
+	if !ValidRole(role) {
+		return "", ApiKey{}, xerrors.Errorf("unknown role %q, use %s, %s, %s or %s", role, RoleAdmin, RoleUploader, RoleReadOnly, RoleSP)
+	}
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", ApiKey{}, err
+	}
+	apiKey := apiKeyPrefix + hex.EncodeToString(secret)
+	key := ApiKey{
+		KeyHash:     hasher.Hash(apiKey),
+		Prefix:      apiKey[:len(apiKeyPrefix)+6],
+		Owner:       owner,
+		Role:        role,
+		Description: description,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := db.Create(&key).Error; err != nil {
+		return "", ApiKey{}, err
+	}
+	return apiKey, key, nil
+}
+
+// RevokeApiKey disables a key of the local auth backend, the contents uploaded with it are kept.
+func RevokeApiKey(db *gorm.DB, id string) (ApiKey, error) {
+	var key ApiKey
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return key, xerrors.Errorf("invalid api key id %q", id)
+	}
+	db.Model(&ApiKey{}).Where("id = ?", id).Find(&key)
+	if key.ID == 0 {
+		return key, xerrors.Errorf("api key %s not found", id)
+	}
+	if key.RevokedAt != nil {
+		return key, nil
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+	err := db.Model(&ApiKey{}).Where("id = ?", key.ID).Updates(map[string]interface{}{
+		"revoked_at": now,
+		"updated_at": now,
+	}).Error
+	return key, err
+}
@@ -0,0 +1,72 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/application-research/edge-ur/config"
+)
+
+func newTestHasher(t *testing.T) *ApiKeyHasher {
+	t.Helper()
+	var cfg config.EdgeConfig
+	cfg.Node.ApiKeySaltFile = filepath.Join(t.TempDir(), "api-key-salt")
+	hasher, err := NewApiKeyHasher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hasher
+}
+
+func TestLocalAuthenticator(t *testing.T) {
+	db := newTestDB(t)
+	hasher := newTestHasher(t)
+	auth := NewLocalAuthenticator(db, hasher)
+	ctx := context.Background()
+
+	apiKey, key, err := CreateApiKey(db, hasher, "alice", RoleUploader, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := auth.Authenticate(ctx, apiKey); err != nil || !res.Validated || res.Owner != "alice" || res.Role != RoleUploader {
+		t.Fatalf("the key was not validated: %+v %v", res, err)
+	}
+	if res, _ := auth.Authenticate(ctx, apiKey+"x"); res.Validated {
+		t.Fatal("an unknown key was validated")
+	}
+
+	if _, err := RevokeApiKey(db, strconv.FormatInt(key.ID, 10)); err != nil {
+		t.Fatalf("failed to revoke the key: %v", err)
+	}
+	if res, _ := auth.Authenticate(ctx, apiKey); res.Validated {
+		t.Fatal("a revoked key was validated")
+	}
+}
+
+func TestLocalAuthenticatorMovesLegacyKeys(t *testing.T) {
+	db := newTestDB(t)
+	hasher := newTestHasher(t)
+	auth := NewLocalAuthenticator(db, hasher)
+
+	// a key stored by a node that kept a plain sha256 of the keys
+	apiKey := apiKeyPrefix + "legacy"
+	legacy := sha256.Sum256([]byte(apiKey))
+	db.Create(&ApiKey{KeyHash: hex.EncodeToString(legacy[:]), Owner: "bob", Role: RoleReadOnly})
+
+	res, err := auth.Authenticate(context.Background(), apiKey)
+	if err != nil || !res.Validated || res.Owner != "bob" {
+		t.Fatalf("the legacy key was not validated: %+v %v", res, err)
+	}
+	var key ApiKey
+	db.Model(&ApiKey{}).Where("owner = ?", "bob").First(&key)
+	if key.KeyHash != hasher.Hash(apiKey) {
+		t.Fatal("the legacy key was not moved to the salted hash")
+	}
+	if res, _ := auth.Authenticate(context.Background(), apiKey); !res.Validated {
+		t.Fatal("the moved key was not validated")
+	}
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+// BucketAllocator assigns uploaded contents to the open bucket of their collection. Allocations are serialized per
+// node, and on postgres also across nodes sharing the database, so concurrent uploads never create duplicate open
+// buckets or lose size updates.
+type BucketAllocator struct {
+	db *gorm.DB
+	lk sync.Mutex
+}
+
+func NewBucketAllocator(db *gorm.DB) *BucketAllocator {
+	return &BucketAllocator{db: db}
+}
+
+// Allocate reserves the size of the content in an open bucket of its collection and creates the content in the same
+// transaction. A bucket only takes a content that fits in the bucket size of the policy, a new bucket is opened
+// otherwise.
+func (a *BucketAllocator) Allocate(policy Policy, content *Content) (Bucket, error) {
+	return a.allocate(policy, content.CollectionName, content.ApiKeyHash, content.Size, func(tx *gorm.DB, bucket Bucket) error {
+		content.BucketUuid = bucket.Uuid
+		return CreateContent(tx, content)
+	})
+}
+
+// AllocateAll reserves the size of the contents in one open bucket of their collection, so they end up in the same
+// deals, and creates them in the same transaction. fn records what goes along with the contents in that transaction,
+// nothing is recorded when it fails. The contents are of one collection and api key.
+func (a *BucketAllocator) AllocateAll(policy Policy, contents []*Content, fn func(tx *gorm.DB) error) (Bucket, error) {
+	if len(contents) == 0 {
+		return Bucket{}, xerrors.Errorf("no contents to allocate")
+	}
+	var size int64
+	for _, content := range contents {
+		size += content.Size
+	}
+	return a.allocate(policy, contents[0].CollectionName, contents[0].ApiKeyHash, size, func(tx *gorm.DB, bucket Bucket) error {
+		for _, content := range contents {
+			content.BucketUuid = bucket.Uuid
+			if err := CreateContent(tx, content); err != nil {
+				return err
+			}
+		}
+		return fn(tx)
+	})
+}
+
+// allocate reserves size bytes in an open bucket of the collection and calls create with the bucket in the same
+// transaction.
+func (a *BucketAllocator) allocate(policy Policy, collectionName string, keyHash string, size int64, create func(tx *gorm.DB, bucket Bucket) error) (Bucket, error) {
+	a.lk.Lock() // sqlite has no row locking, and only takes one writer anyway.
+	defer a.lk.Unlock()
+
+	var bucket Bucket
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == "postgres" {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "buckets:"+collectionName).Error; err != nil {
+				return err
+			}
+		}
+
+		// the aggregator seals buckets without the allocation locks, a bucket that got sealed since it was selected
+		// is passed over for the next one
+		var sealed []int64
+		for {
+			bucket = Bucket{}
+			query := tx.Model(&Bucket{}).Where("status = ? and name = ?", "open", collectionName)
+			if policy.BucketSize > 0 {
+				query = query.Where("size + ? <= ?", size, policy.BucketSize)
+			}
+			if len(sealed) > 0 {
+				query = query.Where("id not in ?", sealed)
+			}
+			if err := query.Order("id asc").Limit(1).Find(&bucket).Error; err != nil {
+				return err
+			}
+			if bucket.ID == 0 {
+				break
+			}
+
+			result := tx.Model(&Bucket{}).Where("id = ? and status = ?", bucket.ID, "open").Updates(map[string]interface{}{
+				"size":       gorm.Expr("size + ?", size),
+				"updated_at": time.Now(),
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 1 {
+				bucket.Size += size
+				break
+			}
+			sealed = append(sealed, bucket.ID)
+		}
+
+		if bucket.ID == 0 {
+			// create a new bucket
+			bucketUuid, err := uuid.NewUUID()
+			if err != nil {
+				return err
+			}
+			bucket = Bucket{
+				Status:     "open",
+				Name:       collectionName,
+				ApiKeyHash: keyHash,
+				Uuid:       bucketUuid.String(),
+				PolicyId:   policy.ID,
+				Size:       size,
+				CreatedAt:  time.Now(),
+				UpdatedAt:  time.Now(),
+			}
+			if err := tx.Create(&bucket).Error; err != nil {
+				return err
+			}
+		}
+
+		return create(tx, bucket)
+	})
+	return bucket, err
+}
+
+// CreateContent records a content and, for an encrypted content, its key envelope. Run it in a transaction, an
+// encrypted content must not be recorded without the key to read it.
+func CreateContent(tx *gorm.DB, content *Content) error {
+	if err := tx.Create(content).Error; err != nil {
+		return err
+	}
+	if content.Envelope == nil {
+		return nil
+	}
+	content.Envelope.ContentID = content.ID
+	return tx.Create(content.Envelope).Error
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"testing"
+)
+
+func allocate(t *testing.T, a *BucketAllocator, policy Policy, name string, size int64) (Bucket, Content) {
+	t.Helper()
+	content := Content{Name: name, Size: size, CollectionName: "default"}
+	bucket, err := a.Allocate(policy, &content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bucket, content
+}
+
+func TestAllocateReservesBucketSize(t *testing.T) {
+	db := newTestDB(t)
+	a := NewBucketAllocator(db)
+	policy := Policy{ID: 1, BucketSize: 100}
+
+	first, _ := allocate(t, a, policy, "a", 60)
+	second, _ := allocate(t, a, policy, "b", 30)
+	if second.ID != first.ID || second.Size != 90 {
+		t.Fatalf("a content that fits went to bucket %d of size %d", second.ID, second.Size)
+	}
+
+	// 90 + 20 is over the bucket size, even though the bucket is under it
+	third, _ := allocate(t, a, policy, "c", 20)
+	if third.ID == first.ID || third.Size != 20 {
+		t.Fatalf("a content that doesn't fit went to bucket %d of size %d", third.ID, third.Size)
+	}
+
+	// a content that fits the first bucket still goes there
+	fourth, _ := allocate(t, a, policy, "d", 10)
+	if fourth.ID != first.ID || fourth.Size != 100 {
+		t.Fatalf("a content that fits exactly went to bucket %d of size %d", fourth.ID, fourth.Size)
+	}
+}
+
+func TestAllocateSkipsSealedBuckets(t *testing.T) {
+	db := newTestDB(t)
+	a := NewBucketAllocator(db)
+	policy := Policy{ID: 1, BucketSize: 100}
+
+	first, _ := allocate(t, a, policy, "a", 10)
+	db.Model(&Bucket{}).Where("id = ?", first.ID).Update("status", "processing")
+
+	second, content := allocate(t, a, policy, "b", 10)
+	if second.ID == first.ID {
+		t.Fatal("a content went to a sealed bucket")
+	}
+	var sealed Bucket
+	db.Model(&Bucket{}).Where("id = ?", first.ID).Find(&sealed)
+	if sealed.Size != 10 {
+		t.Fatalf("the size of the sealed bucket changed to %d", sealed.Size)
+	}
+	if content.BucketUuid != second.Uuid {
+		t.Fatalf("the content was recorded in bucket %s instead of %s", content.BucketUuid, second.Uuid)
+	}
+}
+
+func TestAllocateRecordsEnvelope(t *testing.T) {
+	db := newTestDB(t)
+	a := NewBucketAllocator(db)
+
+	content := Content{Name: "secret", Size: 10, CollectionName: "default", Encrypted: true, Envelope: &ContentEncryption{Algorithm: "test"}}
+	if _, err := a.Allocate(Policy{ID: 1}, &content); err != nil {
+		t.Fatal(err)
+	}
+	var envelope ContentEncryption
+	db.Model(&ContentEncryption{}).Where("content_id = ?", content.ID).Find(&envelope)
+	if envelope.ID == 0 || envelope.Algorithm != "test" {
+		t.Fatal("the envelope was not recorded with the content")
+	}
+}
@@ -0,0 +1,224 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/application-research/edge-ur/config"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+const (
+	ClaimStatusLeased    = "leased"
+	ClaimStatusConfirmed = "confirmed"
+	ClaimStatusReleased  = "released"
+	ClaimStatusExpired   = "expired"
+)
+
+var (
+	ErrNoClaimableBucket  = xerrors.New("no ready bucket can be claimed")
+	ErrBucketNotReady     = xerrors.New("bucket is not ready to be claimed")
+	ErrBucketFullyClaimed = xerrors.New("bucket already has as many replicas as its policy asks for")
+	ErrAlreadyClaimed     = xerrors.New("bucket is already claimed by this storage provider")
+	ErrLeaseExpired       = xerrors.New("claim is not leased anymore")
+)
+
+// activeClaim matches the claims that are leased or confirmed.
+const activeClaim = "(bucket_claims.status = 'confirmed' or (bucket_claims.status = 'leased' and bucket_claims.lease_expires_at > ?))"
+
+// leasedClaim matches the claims that reserve a replica slot of their bucket until they are confirmed. Confirmed
+// claims are counted through their replica.
+const leasedClaim = "bucket_claims.status = 'leased' and bucket_claims.lease_expires_at > ?"
+
+// ClaimableBuckets returns a query for the buckets with a piece that still have a free replica slot, a slot is taken
+// by a leased claim, a claimed replica or a deal. When a miner is given, the buckets the miner already holds are left
+// out.
+func ClaimableBuckets(db *gorm.DB, cfg config.EdgeConfig, miner string, now time.Time) *gorm.DB {
+	query := db.Model(&Bucket{}).
+		Joins("left join policies on policies.id = buckets.policy_id").
+		Where("buckets.status in ? and buckets.piece_cid <> ''", OfferedBucketStatuses).
+		Where(liveReplicaCount("buckets.uuid", "coalesce(policies.auto_retry, ?)")+" < coalesce(nullif(policies.replication_factor, 0), 1)",
+			liveReplicaArgs(now, cfg.Delta.AutoRetry)...)
+	if miner != "" {
+		query = query.
+			Where("not exists (select 1 from bucket_claims where bucket_claims.bucket_uuid = buckets.uuid and bucket_claims.miner = ? and "+leasedClaim+")", miner, now).
+			Where("not exists (select 1 from content_replications where content_replications.bucket_uuid = buckets.uuid and content_replications.miner = ? and "+heldReplica+")", miner)
+	}
+	return query
+}
+
+// BucketClaimer hands ready buckets out to storage providers. Claims are serialized per node, and on postgres also
+// across nodes sharing the database, so a bucket is never claimed by more storage providers than its policy allows.
+type BucketClaimer struct {
+	db  *gorm.DB
+	cfg config.EdgeConfig
+	lk  sync.Mutex
+}
+
+func NewBucketClaimer(db *gorm.DB, cfg config.EdgeConfig) *BucketClaimer {
+	return &BucketClaimer{db: db, cfg: cfg}
+}
+
+// Claim leases a bucket to a miner for the given duration and records the miner on the bucket. Without a bucket uuid
+// the oldest claimable bucket is leased.
+func (b *BucketClaimer) Claim(miner string, bucketUuid string, keyHash string, lease time.Duration) (BucketClaim, Bucket, error) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	var claim BucketClaim
+	var bucket Bucket
+	err := b.db.Transaction(func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == "postgres" {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "bucket-claims").Error; err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		query := ClaimableBuckets(tx, b.cfg, miner, now).Select("buckets.*")
+		if bucketUuid != "" {
+			query = query.Where("buckets.uuid = ?", bucketUuid)
+		}
+		if err := query.Order("buckets.id asc").Limit(1).Find(&bucket).Error; err != nil {
+			return err
+		}
+		if bucket.ID == 0 {
+			if bucketUuid == "" {
+				return ErrNoClaimableBucket
+			}
+			return claimRejection(tx, miner, bucketUuid, now)
+		}
+
+		claim = BucketClaim{
+			BucketUuid:     bucket.Uuid,
+			Miner:          miner,
+			ApiKeyHash:     keyHash,
+			Status:         ClaimStatusLeased,
+			LeaseExpiresAt: now.Add(lease),
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+		if err := tx.Create(&claim).Error; err != nil {
+			return err
+		}
+		bucket.Miner = miner
+		return tx.Model(&Bucket{}).Where("id = ?", bucket.ID).Update("miner", miner).Error
+	})
+	return claim, bucket, err
+}
+
+// claimRejection tells why a given bucket could not be claimed.
+func claimRejection(tx *gorm.DB, miner string, bucketUuid string, now time.Time) error {
+	var offered int64
+	tx.Model(&Bucket{}).Where("uuid = ? and status in ? and piece_cid <> ''", bucketUuid, OfferedBucketStatuses).Count(&offered)
+	if offered == 0 {
+		return ErrBucketNotReady
+	}
+	var leased, held int64
+	tx.Model(&BucketClaim{}).Where("bucket_uuid = ? and miner = ? and "+leasedClaim, bucketUuid, miner, now).Count(&leased)
+	tx.Model(&ContentReplication{}).Where("bucket_uuid = ? and miner = ? and "+heldReplica, bucketUuid, miner).Count(&held)
+	if leased+held > 0 {
+		return ErrAlreadyClaimed
+	}
+	return ErrBucketFullyClaimed
+}
+
+// Confirm turns the lease of a claim into a permanent claim and records the storage provider as a replica of the
+// bucket. A lease that ran out can not be confirmed, the bucket has to be claimed again.
+func (b *BucketClaimer) Confirm(claim *BucketClaim) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	now := time.Now()
+	return b.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&BucketClaim{}).
+			Where("id = ? and status = ? and lease_expires_at > ?", claim.ID, ClaimStatusLeased, now).
+			Updates(map[string]interface{}{
+				"status":     ClaimStatusConfirmed,
+				"updated_at": now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrLeaseExpired
+		}
+		claim.Status = ClaimStatusConfirmed
+		claim.UpdatedAt = now
+
+		var bucket Bucket
+		tx.Model(&Bucket{}).Where("uuid = ?", claim.BucketUuid).Find(&bucket)
+		return RecordReplica(tx, ContentReplication{
+			BucketUuid:    claim.BucketUuid,
+			PieceCid:      bucket.PieceCid,
+			Miner:         claim.Miner,
+			Status:        ReplicaStatusClaimed,
+			BucketClaimID: claim.ID,
+		})
+	})
+}
+
+// Release gives the replica slot of a leased claim back, so another storage provider can claim the bucket.
+func (b *BucketClaimer) Release(claim *BucketClaim) error {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	now := time.Now()
+	return b.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&BucketClaim{}).
+			Where("id = ? and status = ?", claim.ID, ClaimStatusLeased).
+			Updates(map[string]interface{}{
+				"status":     ClaimStatusReleased,
+				"updated_at": now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrLeaseExpired
+		}
+		claim.Status = ClaimStatusReleased
+		claim.UpdatedAt = now
+		return updateBucketMiner(tx, claim.BucketUuid, now)
+	})
+}
+
+// ExpireLeases reclaims the leases that ran out and returns how many were expired.
+func (b *BucketClaimer) ExpireLeases(now time.Time) (int64, error) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	var claims []BucketClaim
+	if err := b.db.Model(&BucketClaim{}).Where("status = ? and lease_expires_at <= ?", ClaimStatusLeased, now).Find(&claims).Error; err != nil {
+		return 0, err
+	}
+
+	var expired int64
+	for _, claim := range claims {
+		err := b.db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&BucketClaim{}).Where("id = ? and status = ?", claim.ID, ClaimStatusLeased).Updates(map[string]interface{}{
+				"status":     ClaimStatusExpired,
+				"updated_at": now,
+			})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			expired++
+			return updateBucketMiner(tx, claim.BucketUuid, now)
+		})
+		if err != nil {
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+// updateBucketMiner points the miner of a bucket to the latest storage provider still holding it.
+func updateBucketMiner(tx *gorm.DB, bucketUuid string, now time.Time) error {
+	var latest BucketClaim
+	if err := tx.Model(&BucketClaim{}).Where("bucket_uuid = ? and "+activeClaim, bucketUuid, now).Order("id desc").Limit(1).Find(&latest).Error; err != nil {
+		return err
+	}
+	return tx.Model(&Bucket{}).Where("uuid = ?", bucketUuid).Update("miner", latest.Miner).Error
+}
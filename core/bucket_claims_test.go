@@ -0,0 +1,128 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/application-research/edge-ur/config"
+	"gorm.io/gorm"
+)
+
+func newClaimTest(t *testing.T, cfg config.EdgeConfig, replicas int) (*gorm.DB, *BucketClaimer, Bucket) {
+	t.Helper()
+	db := newTestDB(t)
+	policy := Policy{Name: "default", BucketSize: 1 << 20, ReplicationFactor: replicas}
+	db.Create(&policy)
+	bucket := Bucket{Uuid: "bucket-uuid", Name: "default", Status: "ready", PolicyId: policy.ID, PieceCid: "baga-piece"}
+	db.Create(&bucket)
+	return db, NewBucketClaimer(db, cfg), bucket
+}
+
+func bucketMiner(t *testing.T, db *gorm.DB, bucketUuid string) string {
+	t.Helper()
+	var bucket Bucket
+	db.Model(&Bucket{}).Where("uuid = ?", bucketUuid).First(&bucket)
+	return bucket.Miner
+}
+
+func TestBucketClaimLifecycle(t *testing.T) {
+	db, claimer, bucket := newClaimTest(t, config.EdgeConfig{}, 2)
+
+	first, claimed, err := claimer.Claim("f01000", "", "key", time.Hour)
+	if err != nil || claimed.Uuid != bucket.Uuid || first.Status != ClaimStatusLeased {
+		t.Fatalf("claimed %s with a %s claim: %v", claimed.Uuid, first.Status, err)
+	}
+	if _, _, err := claimer.Claim("f01000", bucket.Uuid, "key", time.Hour); err != ErrAlreadyClaimed {
+		t.Fatalf("claiming twice got %v", err)
+	}
+	second, _, err := claimer.Claim("f02000", bucket.Uuid, "key", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := claimer.Claim("f03000", bucket.Uuid, "key", time.Hour); err != ErrBucketFullyClaimed {
+		t.Fatalf("claiming past the replication factor got %v", err)
+	}
+	if _, _, err := claimer.Claim("f03000", "", "key", time.Hour); err != ErrNoClaimableBucket {
+		t.Fatalf("claiming any bucket got %v", err)
+	}
+	if miner := bucketMiner(t, db, bucket.Uuid); miner != "f02000" {
+		t.Fatalf("bucket miner is %s, expected the latest claim", miner)
+	}
+
+	// a released lease frees its slot
+	if err := claimer.Release(&second); err != nil || second.Status != ClaimStatusReleased {
+		t.Fatalf("released the claim to %s: %v", second.Status, err)
+	}
+	if miner := bucketMiner(t, db, bucket.Uuid); miner != "f01000" {
+		t.Fatalf("bucket miner is %s after the release", miner)
+	}
+	if err := claimer.Release(&second); err != ErrLeaseExpired {
+		t.Fatalf("releasing twice got %v", err)
+	}
+	third, _, err := claimer.Claim("f03000", bucket.Uuid, "key", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a confirmed claim holds its slot through its replica
+	if err := claimer.Confirm(&first); err != nil || first.Status != ClaimStatusConfirmed {
+		t.Fatalf("confirmed the claim to %s: %v", first.Status, err)
+	}
+	var replica ContentReplication
+	db.Model(&ContentReplication{}).Where("bucket_claim_id = ?", first.ID).First(&replica)
+	if replica.Miner != "f01000" || replica.Status != ReplicaStatusClaimed || replica.PieceCid != "baga-piece" {
+		t.Fatalf("recorded the replica %+v", replica)
+	}
+	if replicas := LiveReplicas(db, bucket.Uuid, false, time.Now()); replicas != 2 {
+		t.Fatalf("bucket has %d live replicas, expected the confirmed claim and the lease", replicas)
+	}
+
+	// leases that ran out are expired and can't be confirmed anymore
+	expired, err := claimer.ExpireLeases(time.Now().Add(2 * time.Hour))
+	if err != nil || expired != 1 {
+		t.Fatalf("expired %d leases: %v", expired, err)
+	}
+	if err := claimer.Confirm(&third); err != ErrLeaseExpired {
+		t.Fatalf("confirming an expired lease got %v", err)
+	}
+	var status string
+	db.Model(&BucketClaim{}).Where("id = ?", third.ID).Pluck("status", &status)
+	if status != ClaimStatusExpired {
+		t.Fatalf("lease is %s, expected it to expire", status)
+	}
+	if miner := bucketMiner(t, db, bucket.Uuid); miner != "f01000" {
+		t.Fatalf("bucket miner is %s after the lease expired", miner)
+	}
+	if _, _, err := claimer.Claim("f03000", bucket.Uuid, "key", time.Hour); err != nil {
+		t.Fatalf("the slot of the expired lease was not freed: %v", err)
+	}
+}
+
+func TestBucketClaimRejectsBucketWithoutPiece(t *testing.T) {
+	db, claimer, bucket := newClaimTest(t, config.EdgeConfig{}, 1)
+	db.Model(&Bucket{}).Where("id = ?", bucket.ID).Update("piece_cid", "")
+	if _, _, err := claimer.Claim("f01000", bucket.Uuid, "key", time.Hour); err != ErrBucketNotReady {
+		t.Fatalf("claiming a bucket without a piece got %v", err)
+	}
+}
+
+func TestBucketClaimCountsDeals(t *testing.T) {
+	var cfg config.EdgeConfig
+	cfg.Delta.AutoRetry = true
+	db, claimer, bucket := newClaimTest(t, cfg, 2)
+	db.Create(&ContentDeal{ContentId: 1, BucketUuid: bucket.Uuid, Miner: "f01000"})
+	db.Create(&ContentDeal{ContentId: 2, BucketUuid: bucket.Uuid, Failed: true, FailedAt: time.Now()})
+
+	// delta still retries the failed deal, the bucket has no slot left
+	if _, _, err := claimer.Claim("f02000", bucket.Uuid, "key", time.Hour); err != ErrBucketFullyClaimed {
+		t.Fatalf("claiming a bucket with as many deals as replicas got %v", err)
+	}
+
+	db.Model(&ContentDeal{}).Where("content_id = ?", 2).Update("failed_at", time.Now().Add(-2*FailedDealGrace))
+	if _, _, err := claimer.Claim("f02000", bucket.Uuid, "key", time.Hour); err != nil {
+		t.Fatalf("the slot of a deal delta gave up on was not freed: %v", err)
+	}
+	if replicas := LiveReplicas(db, bucket.Uuid, true, time.Now()); replicas != 2 {
+		t.Fatalf("bucket has %d live replicas, expected the deal and the lease", replicas)
+	}
+}
@@ -0,0 +1,284 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+	_ "github.com/ipld/go-codec-dagpb" // registers the codecs the dags are walked with
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/dagjson"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/traversal"
+	"github.com/multiformats/go-multihash"
+	"golang.org/x/xerrors"
+)
+
+// The maxReportedCids constant caps the cids listed in each part of a car report.
+const maxReportedCids = 20
+
+// The carV2Pragma is the fixed start of a CARv2 file, read by a CARv1 reader as a header with version 2.
+var carV2Pragma = []byte{0x0a, 0xa1, 0x67, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x02}
+
+// The carLoadBatch constant is how many blocks of a car are written to the blockstore at once.
+const carLoadBatch = 1024
+
+// CarRootReport tells whether the dag under a root of a car is complete.
+type CarRootReport struct {
+	Cid           string   `json:"cid"`
+	Blocks        int64    `json:"blocks"`
+	Size          int64    `json:"size"` // bytes of the blocks of the dag
+	UnixfsFile    bool     `json:"unixfs_file"`
+	FileSize      int64    `json:"file_size,omitempty"` // size of the file when the root is a unixfs file
+	Complete      bool     `json:"complete"`
+	MissingBlocks []string `json:"missing_blocks,omitempty"` // the first missing blocks
+}
+
+// CarReport is the outcome of validating a car. A car is valid when every block matches its cid and the dag under
+// every root is complete.
+type CarReport struct {
+	Version       int             `json:"version"`
+	Blocks        int64           `json:"blocks"`
+	Roots         []CarRootReport `json:"roots"`
+	InvalidBlocks []string        `json:"invalid_blocks,omitempty"` // the first blocks whose data does not match their cid
+	Errors        []string        `json:"errors,omitempty"`
+}
+
+func (r CarReport) Valid() bool {
+	if len(r.Errors) > 0 || len(r.InvalidBlocks) > 0 || len(r.Roots) == 0 {
+		return false
+	}
+	for _, root := range r.Roots {
+		if !root.Complete {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *CarReport) addError(format string, args ...interface{}) {
+	if len(r.Errors) < maxReportedCids {
+		r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+	}
+}
+
+// carBlock is what validation keeps of a block to walk the dags of the roots.
+type carBlock struct {
+	size     int64
+	links    []cid.Cid
+	file     bool // the block is the root of a unixfs file, of fileSize bytes
+	fileSize int64
+}
+
+// ValidateCar reads a CARv1 or CARv2 file, re-hashes every block and walks the dag under each root. The problems
+// found are listed in the report, the error is only set when the file can not be read.
+func ValidateCar(r io.ReaderAt, size int64) (CarReport, error) {
+	var report CarReport
+	payload, version, err := openCarPayload(r, size)
+	report.Version = version
+	if err != nil {
+		report.addError("%s", err)
+		return report, nil
+	}
+
+	br := bufio.NewReader(payload)
+	header, err := car.ReadHeader(br)
+	if err != nil {
+		report.addError("the car header can not be read: %s", err)
+		return report, nil
+	}
+	if header.Version != 1 {
+		report.addError("unsupported car payload version %d", header.Version)
+		return report, nil
+	}
+	if len(header.Roots) == 0 {
+		report.addError("the car has no roots")
+	}
+
+	dag := map[cid.Cid]carBlock{}
+	for {
+		c, data, err := util.ReadNode(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			report.addError("block %d can not be read: %s", report.Blocks+1, err)
+			break
+		}
+		report.Blocks++
+
+		hashed, err := c.Prefix().Sum(data)
+		if err != nil || !hashed.Equals(c) {
+			if len(report.InvalidBlocks) < maxReportedCids {
+				report.InvalidBlocks = append(report.InvalidBlocks, c.String())
+			}
+			continue
+		}
+		links, err := blockLinks(c, data)
+		if err != nil {
+			report.addError("block %s can not be decoded: %s", c, err)
+		}
+		block := carBlock{size: int64(len(data)), links: links}
+		block.file, block.fileSize = unixfsFileSize(c, data)
+		dag[c] = block
+	}
+
+	for _, root := range header.Roots {
+		report.Roots = append(report.Roots, walkCarRoot(dag, root))
+	}
+	return report, nil
+}
+
+// LoadCar writes the blocks of a car to the blockstore. The car is expected to be validated first.
+func LoadCar(ctx context.Context, bs blockstore.Blockstore, r io.ReaderAt, size int64) error {
+	payload, _, err := openCarPayload(r, size)
+	if err != nil {
+		return err
+	}
+	cr, err := car.NewCarReader(payload)
+	if err != nil {
+		return err
+	}
+
+	batch := make([]blocks.Block, 0, carLoadBatch)
+	for {
+		blk, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		batch = append(batch, blk)
+		if len(batch) == carLoadBatch {
+			if err := bs.PutMany(ctx, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		return bs.PutMany(ctx, batch)
+	}
+	return nil
+}
+
+// openCarPayload returns the CARv1 data of a car file along with the version of the file. The data of a CARv2 file is
+// the CARv1 payload its header points at.
+func openCarPayload(r io.ReaderAt, size int64) (io.Reader, int, error) {
+	pragma := make([]byte, len(carV2Pragma))
+	if _, err := r.ReadAt(pragma, 0); err != nil {
+		return nil, 0, xerrors.Errorf("the file is too short to be a car: %w", err)
+	}
+	if !bytes.Equal(pragma, carV2Pragma) {
+		return io.NewSectionReader(r, 0, size), 1, nil
+	}
+
+	// characteristics (16 bytes), data offset, data size and index offset
+	v2Header := make([]byte, 40)
+	if _, err := r.ReadAt(v2Header, int64(len(carV2Pragma))); err != nil {
+		return nil, 2, xerrors.Errorf("the CARv2 header can not be read: %w", err)
+	}
+	dataOffset := binary.LittleEndian.Uint64(v2Header[16:24])
+	dataSize := binary.LittleEndian.Uint64(v2Header[24:32])
+	if dataOffset < uint64(len(carV2Pragma)+len(v2Header)) || dataOffset > uint64(size) || dataSize > uint64(size)-dataOffset {
+		return nil, 2, xerrors.Errorf("the CARv2 payload at %d with %d bytes is outside of the file", dataOffset, dataSize)
+	}
+	return io.NewSectionReader(r, int64(dataOffset), int64(dataSize)), 2, nil
+}
+
+// blockLinks decodes a block with the codec of its cid and returns the cids it links to.
+func blockLinks(c cid.Cid, data []byte) ([]cid.Cid, error) {
+	if c.Prefix().Codec == cid.Raw {
+		return nil, nil
+	}
+	decoder, err := multicodec.LookupDecoder(c.Prefix().Codec)
+	if err != nil {
+		return nil, err
+	}
+	nb := basicnode.Prototype.Any.NewBuilder()
+	if err := decoder(nb, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	links, err := traversal.SelectLinks(nb.Build())
+	if err != nil {
+		return nil, err
+	}
+	var cids []cid.Cid
+	for _, link := range links {
+		if cl, ok := link.(cidlink.Link); ok {
+			cids = append(cids, cl.Cid)
+		}
+	}
+	return cids, nil
+}
+
+// unixfsFileSize tells if a block is the root of a unixfs file or a raw block, and the size of the file.
+func unixfsFileSize(c cid.Cid, data []byte) (bool, int64) {
+	var nd ipld.Node
+	switch c.Prefix().Codec {
+	case cid.Raw:
+		return true, int64(len(data))
+	case cid.DagProtobuf:
+		pn, err := merkledag.DecodeProtobuf(data)
+		if err != nil {
+			return false, 0
+		}
+		nd = pn
+	default:
+		return false, 0
+	}
+	if !IsUnixfsFile(nd) {
+		return false, 0
+	}
+	size, err := UnixfsSize(nd)
+	if err != nil {
+		return false, 0
+	}
+	return true, size
+}
+
+// walkCarRoot walks the dag under a root through the blocks of the car. Blocks with an identity hash carry their data
+// in the cid and don't need to be in the car.
+func walkCarRoot(dag map[cid.Cid]carBlock, root cid.Cid) CarRootReport {
+	report := CarRootReport{Cid: root.String(), Complete: true}
+	if block, ok := dag[root]; ok {
+		report.UnixfsFile, report.FileSize = block.file, block.fileSize
+	}
+	visited := cid.NewSet()
+	stack := []cid.Cid{root}
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !visited.Visit(c) {
+			continue
+		}
+		block, ok := dag[c]
+		if !ok {
+			if c.Prefix().MhType == multihash.IDENTITY {
+				continue
+			}
+			report.Complete = false
+			if len(report.MissingBlocks) < maxReportedCids {
+				report.MissingBlocks = append(report.MissingBlocks, c.String())
+			}
+			continue
+		}
+		report.Blocks++
+		report.Size += block.size
+		stack = append(stack, block.links...)
+	}
+	return report
+}
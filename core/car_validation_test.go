@@ -0,0 +1,198 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipld/go-car"
+	"github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multihash"
+)
+
+type carEntry struct {
+	cid  cid.Cid
+	data []byte
+}
+
+func buildCar(t *testing.T, roots []cid.Cid, entries ...carEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := car.WriteHeader(&car.CarHeader{Roots: roots, Version: 1}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := util.LdWrite(&buf, e.cid.Bytes(), e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// buildCarV2 wraps a CARv1 payload in a CARv2 file without an index, the payload is placed at dataOffset and said to
+// have dataSize bytes.
+func buildCarV2(payload []byte, dataOffset uint64, dataSize uint64) []byte {
+	header := make([]byte, 40)
+	binary.LittleEndian.PutUint64(header[16:24], dataOffset)
+	binary.LittleEndian.PutUint64(header[24:32], dataSize)
+	v2 := append(append([]byte{}, carV2Pragma...), header...)
+	return append(v2, payload...)
+}
+
+func entryOf(nd ipld.Node) carEntry {
+	return carEntry{nd.Cid(), nd.RawData()}
+}
+
+// unixfsTestFile returns the root of a unixfs file of two raw leaves and its leaves.
+func unixfsTestFile(t *testing.T) (*merkledag.ProtoNode, []*merkledag.RawNode) {
+	t.Helper()
+	leaves := []*merkledag.RawNode{merkledag.NewRawNode([]byte("hello ")), merkledag.NewRawNode([]byte("world"))}
+	fsNode := unixfs.NewFSNode(unixfs.TFile)
+	root := new(merkledag.ProtoNode)
+	for _, leaf := range leaves {
+		fsNode.AddBlockSize(uint64(len(leaf.RawData())))
+		if err := root.AddNodeLink("", leaf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := fsNode.GetBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root.SetData(data)
+	return root, leaves
+}
+
+func TestValidateCar(t *testing.T) {
+	file, leaves := unixfsTestFile(t)
+	fileBytes := int64(len(file.RawData()) + len(leaves[0].RawData()) + len(leaves[1].RawData()))
+	validFile := buildCar(t, []cid.Cid{file.Cid()}, entryOf(file), entryOf(leaves[0]), entryOf(leaves[1]))
+
+	tampered := append([]byte{}, leaves[1].RawData()...)
+	tampered[0] = 'W'
+
+	identity, err := cid.V1Builder{Codec: cid.Raw, MhType: multihash.IDENTITY}.Sum([]byte("inline"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := unixfs.EmptyDirNode()
+	if err := dir.AddRawLink("inline", &ipld.Link{Cid: identity}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dir.AddNodeLink("file", file); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		car     []byte
+		check   func(t *testing.T, report CarReport)
+		valid   bool
+		version int
+	}{
+		{
+			name: "unixfs file", car: validFile, valid: true, version: 1,
+			check: func(t *testing.T, report CarReport) {
+				root := report.Roots[0]
+				if report.Blocks != 3 || root.Blocks != 3 || root.Size != fileBytes {
+					t.Fatalf("read %d blocks, the root has %d blocks of %d bytes", report.Blocks, root.Blocks, root.Size)
+				}
+				if !root.UnixfsFile || root.FileSize != 11 {
+					t.Fatalf("root is a file %t of %d bytes", root.UnixfsFile, root.FileSize)
+				}
+			},
+		},
+		{
+			name: "CARv2", car: buildCarV2(validFile, 51, uint64(len(validFile))), valid: true, version: 2,
+			check: func(t *testing.T, report CarReport) {
+				if report.Blocks != 3 {
+					t.Fatalf("read %d blocks of the payload", report.Blocks)
+				}
+			},
+		},
+		{
+			name: "CARv2 payload past the end", car: buildCarV2(validFile, 51, uint64(len(validFile))+1), version: 2,
+			check: func(t *testing.T, report CarReport) {
+				if len(report.Errors) != 1 || report.Blocks != 0 {
+					t.Fatalf("reported %v after %d blocks", report.Errors, report.Blocks)
+				}
+			},
+		},
+		{
+			name: "CARv2 payload in the header", car: buildCarV2(validFile, 20, uint64(len(validFile))), version: 2,
+			check: func(t *testing.T, report CarReport) {
+				if len(report.Errors) != 1 {
+					t.Fatalf("reported %v", report.Errors)
+				}
+			},
+		},
+		{
+			name:    "block not matching its cid",
+			car:     buildCar(t, []cid.Cid{file.Cid()}, entryOf(file), entryOf(leaves[0]), carEntry{leaves[1].Cid(), tampered}),
+			version: 1,
+			check: func(t *testing.T, report CarReport) {
+				if len(report.InvalidBlocks) != 1 || report.InvalidBlocks[0] != leaves[1].Cid().String() {
+					t.Fatalf("invalid blocks are %v", report.InvalidBlocks)
+				}
+				if report.Roots[0].Complete {
+					t.Fatal("a dag with an invalid block is complete")
+				}
+			},
+		},
+		{
+			name:    "missing block",
+			car:     buildCar(t, []cid.Cid{file.Cid()}, entryOf(file), entryOf(leaves[0])),
+			version: 1,
+			check: func(t *testing.T, report CarReport) {
+				root := report.Roots[0]
+				if root.Complete || len(root.MissingBlocks) != 1 || root.MissingBlocks[0] != leaves[1].Cid().String() {
+					t.Fatalf("root is complete %t, missing %v", root.Complete, root.MissingBlocks)
+				}
+			},
+		},
+		{
+			name:    "identity hash link and two roots",
+			car:     buildCar(t, []cid.Cid{dir.Cid(), leaves[0].Cid()}, entryOf(dir), entryOf(file), entryOf(leaves[0]), entryOf(leaves[1])),
+			valid:   true,
+			version: 1,
+			check: func(t *testing.T, report CarReport) {
+				if len(report.Roots) != 2 {
+					t.Fatalf("reported %d roots", len(report.Roots))
+				}
+				dirRoot, leafRoot := report.Roots[0], report.Roots[1]
+				if dirRoot.UnixfsFile || dirRoot.Blocks != 4 || dirRoot.Size != fileBytes+int64(len(dir.RawData())) {
+					t.Fatalf("directory root is a file %t with %d blocks of %d bytes", dirRoot.UnixfsFile, dirRoot.Blocks, dirRoot.Size)
+				}
+				if !leafRoot.UnixfsFile || leafRoot.FileSize != 6 || leafRoot.Blocks != 1 {
+					t.Fatalf("raw root is a file %t of %d bytes with %d blocks", leafRoot.UnixfsFile, leafRoot.FileSize, leafRoot.Blocks)
+				}
+			},
+		},
+		{
+			name:    "no roots",
+			car:     buildCar(t, nil, entryOf(leaves[0])),
+			version: 1,
+			check: func(t *testing.T, report CarReport) {
+				if len(report.Errors) != 1 || len(report.Roots) != 0 {
+					t.Fatalf("reported %v with %d roots", report.Errors, len(report.Roots))
+				}
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			report, err := ValidateCar(bytes.NewReader(tc.car), int64(len(tc.car)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Valid() != tc.valid || report.Version != tc.version {
+				t.Fatalf("car of version %d is valid %t: %+v", report.Version, report.Valid(), report)
+			}
+			tc.check(t, report)
+		})
+	}
+}
@@ -43,7 +43,7 @@ package core
 //
 //func (c CollectionService) GetCollection(coluuid string, requestingApiKey string) (Collection, error) {
 //	var collection Collection
-//	err := c.node.DB.Model(Collection{}).Where("uuid = ? and requesting_api_key = ?", coluuid, requestingApiKey).First(&collection).Error
+//	err := c.node.DB.Model(Collection{}).Where("uuid = ? and api_key_hash = ?", coluuid, requestingApiKey).First(&collection).Error
 //	if err != nil {
 //		return Collection{}, errors.New("collection not found")
 //	}
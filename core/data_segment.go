@@ -0,0 +1,313 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+	"sort"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	"github.com/filecoin-project/lotus/storage/sealer/fr32"
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+)
+
+// Data segment aggregation as described in FRC-0058. Sub-pieces are laid out in the aggregate piece aligned to their
+// own size, and a data segment index at the end of the piece lists the commitment, offset and size of every one of
+// them. An inclusion proof shows that a sub-piece is part of the aggregate and that it is listed in the index.
+
+const (
+	// DataSegmentEntrySize is the size of an index entry in the padded space, two nodes of the piece tree.
+	DataSegmentEntrySize = 64
+
+	nodeSize     = 32
+	checksumSize = 16
+)
+
+// PieceNode is a node of the piece merkle tree.
+type PieceNode [nodeSize]byte
+
+// SubPiece is the commitment and padded size of a piece that goes into an aggregate.
+type SubPiece struct {
+	PieceCid cid.Cid
+	Size     uint64
+}
+
+// ProofData is a merkle path from a node up to the root of the aggregate, the path is ordered from the bottom up.
+type ProofData struct {
+	Index uint64   `json:"index"` // index of the node on its level of the tree
+	Path  [][]byte `json:"path"`
+}
+
+// InclusionProof proves that a sub-piece is part of an aggregate piece and that it is listed in its data segment index.
+type InclusionProof struct {
+	ProofSubtree ProofData `json:"proof_subtree"`
+	ProofIndex   ProofData `json:"proof_index"`
+}
+
+// DataSegmentAggregate is the layout and merkle tree of an aggregate piece.
+type DataSegmentAggregate struct {
+	DealSize uint64   // padded size of the aggregate piece
+	Offsets  []uint64 // padded offset of every sub-piece, in the order they were given
+	Pieces   []SubPiece
+
+	entries []PieceNode // two nodes for every index entry
+	levels  []map[uint64]PieceNode
+}
+
+// NewDataSegmentAggregate lays out the sub-pieces in the smallest aggregate piece they fit in, but no smaller than
+// minDealSize. Larger sub-pieces are placed first so that aligning them wastes as little space as possible.
+func NewDataSegmentAggregate(pieces []SubPiece, minDealSize uint64) (*DataSegmentAggregate, error) {
+	if len(pieces) == 0 {
+		return nil, xerrors.New("no sub-pieces to aggregate")
+	}
+	for _, p := range pieces {
+		if p.Size < 128 || bits.OnesCount64(p.Size) != 1 {
+			return nil, xerrors.Errorf("sub-piece %s has an invalid padded size %d", p.PieceCid, p.Size)
+		}
+	}
+
+	order := make([]int, len(pieces))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return pieces[order[a]].Size > pieces[order[b]].Size
+	})
+
+	offsets := make([]uint64, len(pieces))
+	var end uint64
+	for _, i := range order {
+		size := pieces[i].Size
+		offsets[i] = (end + size - 1) / size * size
+		end = offsets[i] + size
+	}
+
+	dealSize := nextPowerOfTwo(minDealSize)
+	if dealSize < 128 {
+		dealSize = 128
+	}
+	for !fitsInDeal(dealSize, end, uint64(len(pieces))) {
+		dealSize <<= 1
+	}
+
+	agg := &DataSegmentAggregate{
+		DealSize: dealSize,
+		Offsets:  offsets,
+		Pieces:   pieces,
+		entries:  make([]PieceNode, 2*MaxIndexEntriesInDeal(dealSize)),
+	}
+	if err := agg.buildTree(); err != nil {
+		return nil, err
+	}
+	return agg, nil
+}
+
+// MaxIndexEntriesInDeal returns the number of entries the data segment index of a piece of the given size has room for.
+func MaxIndexEntriesInDeal(dealSize uint64) uint64 {
+	res := uint64(1) << log2Ceil(dealSize/2048/DataSegmentEntrySize)
+	if res < 4 {
+		return 4
+	}
+	return res
+}
+
+// DataSegmentIndexStartOffset returns the padded offset at which the data segment index starts.
+func DataSegmentIndexStartOffset(dealSize uint64) uint64 {
+	return dealSize - MaxIndexEntriesInDeal(dealSize)*DataSegmentEntrySize
+}
+
+func fitsInDeal(dealSize, end, entries uint64) bool {
+	indexSize := MaxIndexEntriesInDeal(dealSize) * DataSegmentEntrySize
+	return indexSize < dealSize && end <= dealSize-indexSize && entries <= MaxIndexEntriesInDeal(dealSize)
+}
+
+// PieceCid returns the commitment of the aggregate piece.
+func (a *DataSegmentAggregate) PieceCid() (cid.Cid, error) {
+	root := a.levels[len(a.levels)-1][0]
+	return commcid.DataCommitmentV1ToCID(root[:])
+}
+
+// IndexStartOffset returns the padded offset of the data segment index in the aggregate piece.
+func (a *DataSegmentAggregate) IndexStartOffset() uint64 {
+	return DataSegmentIndexStartOffset(a.DealSize)
+}
+
+// IndexData returns the data segment index the way it is written to the aggregate, in the unpadded space.
+func (a *DataSegmentAggregate) IndexData() []byte {
+	padded := make([]byte, len(a.entries)*nodeSize)
+	for i, n := range a.entries {
+		copy(padded[i*nodeSize:], n[:])
+	}
+	unpadded := make([]byte, len(padded)/128*127)
+	fr32.Unpad(padded, unpadded)
+	return unpadded
+}
+
+// InclusionProof returns the proof for the i-th sub-piece given to NewDataSegmentAggregate.
+func (a *DataSegmentAggregate) InclusionProof(i int) InclusionProof {
+	size := a.Pieces[i].Size
+	level := log2Ceil(size / nodeSize)
+	entryNode := (a.IndexStartOffset() + uint64(i)*DataSegmentEntrySize) / DataSegmentEntrySize
+	return InclusionProof{
+		ProofSubtree: a.proof(level, a.Offsets[i]/size),
+		ProofIndex:   a.proof(1, entryNode),
+	}
+}
+
+// Verify checks the proof of a sub-piece placed at the given padded offset against the aggregate piece.
+func (p InclusionProof) Verify(aggregate cid.Cid, subPiece SubPiece, offset uint64) error {
+	rootBytes, err := commcid.CIDToDataCommitmentV1(aggregate)
+	if err != nil {
+		return err
+	}
+	var root PieceNode
+	copy(root[:], rootBytes)
+
+	commP, err := subPieceNode(subPiece)
+	if err != nil {
+		return err
+	}
+	if offset%subPiece.Size != 0 || p.ProofSubtree.Index != offset/subPiece.Size {
+		return xerrors.Errorf("sub-piece offset %d does not match the proof", offset)
+	}
+	if computeRoot(commP, p.ProofSubtree) != root {
+		return xerrors.New("sub-piece is not part of the aggregate piece")
+	}
+
+	entry := segmentDescNodes(commP, offset, subPiece.Size)
+	if computeRoot(hashNodes(entry[0], entry[1]), p.ProofIndex) != root {
+		return xerrors.New("sub-piece is not listed in the data segment index")
+	}
+	return nil
+}
+
+// buildTree fills in the index entries and computes the sparse merkle tree of the aggregate. Only the nodes above the
+// sub-pieces and the index entries are kept, all other nodes are zero commitments.
+func (a *DataSegmentAggregate) buildTree() error {
+	height := log2Ceil(a.DealSize / nodeSize)
+	a.levels = make([]map[uint64]PieceNode, height+1)
+	for i := range a.levels {
+		a.levels[i] = make(map[uint64]PieceNode)
+	}
+
+	indexLeaf := a.IndexStartOffset() / nodeSize
+	for i, p := range a.Pieces {
+		commP, err := subPieceNode(p)
+		if err != nil {
+			return err
+		}
+		a.levels[log2Ceil(p.Size/nodeSize)][a.Offsets[i]/p.Size] = commP
+
+		entry := segmentDescNodes(commP, a.Offsets[i], p.Size)
+		a.entries[2*i], a.entries[2*i+1] = entry[0], entry[1]
+	}
+	for i := 0; i < 2*len(a.Pieces); i++ {
+		a.levels[0][indexLeaf+uint64(i)] = a.entries[i]
+	}
+
+	for level := 0; level < height; level++ {
+		for index := range a.levels[level] {
+			parent := index / 2
+			if _, done := a.levels[level+1][parent]; done {
+				continue
+			}
+			a.levels[level+1][parent] = hashNodes(a.node(level, parent*2), a.node(level, parent*2+1))
+		}
+	}
+	return nil
+}
+
+func (a *DataSegmentAggregate) node(level int, index uint64) PieceNode {
+	if n, ok := a.levels[level][index]; ok {
+		return n
+	}
+	return zeroCommitment(level)
+}
+
+func (a *DataSegmentAggregate) proof(level int, index uint64) ProofData {
+	pd := ProofData{Index: index}
+	for l := level; l < len(a.levels)-1; l++ {
+		sibling := a.node(l, index^1)
+		pd.Path = append(pd.Path, append([]byte(nil), sibling[:]...))
+		index /= 2
+	}
+	return pd
+}
+
+func computeRoot(n PieceNode, pd ProofData) PieceNode {
+	index := pd.Index
+	for _, p := range pd.Path {
+		var sibling PieceNode
+		copy(sibling[:], p)
+		if index%2 == 0 {
+			n = hashNodes(n, sibling)
+		} else {
+			n = hashNodes(sibling, n)
+		}
+		index /= 2
+	}
+	return n
+}
+
+// segmentDescNodes serializes an index entry: the commitment, the little endian offset and size and a checksum over
+// the three, truncated so the entry stays a valid pair of fr32 nodes.
+func segmentDescNodes(commP PieceNode, offset, size uint64) [2]PieceNode {
+	var buf [DataSegmentEntrySize]byte
+	copy(buf[:nodeSize], commP[:])
+	binary.LittleEndian.PutUint64(buf[nodeSize:], offset)
+	binary.LittleEndian.PutUint64(buf[nodeSize+8:], size)
+	sum := sha256.Sum256(buf[:])
+	copy(buf[DataSegmentEntrySize-checksumSize:], sum[:checksumSize])
+	buf[DataSegmentEntrySize-1] &= 0x3f
+
+	var nodes [2]PieceNode
+	copy(nodes[0][:], buf[:nodeSize])
+	copy(nodes[1][:], buf[nodeSize:])
+	return nodes
+}
+
+func subPieceNode(p SubPiece) (PieceNode, error) {
+	var n PieceNode
+	commP, err := commcid.CIDToDataCommitmentV1(p.PieceCid)
+	if err != nil {
+		return n, xerrors.Errorf("invalid sub-piece cid %s: %w", p.PieceCid, err)
+	}
+	copy(n[:], commP)
+	return n, nil
+}
+
+// hashNodes is the sha256 based hash of the piece tree, truncated to 254 bits.
+func hashNodes(left, right PieceNode) PieceNode {
+	h := sha256.New()
+	h.Write(left[:])
+	h.Write(right[:])
+	var out PieceNode
+	copy(out[:], h.Sum(nil))
+	out[nodeSize-1] &= 0x3f
+	return out
+}
+
+var zeroCommitments = func() []PieceNode {
+	zc := make([]PieceNode, 64)
+	for i := 1; i < len(zc); i++ {
+		zc[i] = hashNodes(zc[i-1], zc[i-1])
+	}
+	return zc
+}()
+
+// zeroCommitment returns the root of a tree of the given height with only zeros in it.
+func zeroCommitment(level int) PieceNode {
+	return zeroCommitments[level]
+}
+
+func log2Ceil(x uint64) int {
+	if x <= 1 {
+		return 0
+	}
+	return bits.Len64(x - 1)
+}
+
+func nextPowerOfTwo(x uint64) uint64 {
+	return uint64(1) << log2Ceil(x)
+}
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	"github.com/ipfs/go-cid"
+)
+
+// The aggregate tree is checked against go-fil-commp-hashhash, which computes the commitment of the bytes of a piece
+// on its own.
+
+func commPOf(t *testing.T, data []byte) (cid.Cid, uint64) {
+	t.Helper()
+	cp := new(commp.Calc)
+	if _, err := cp.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	digest, size, err := cp.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := commcid.DataCommitmentV1ToCID(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, size
+}
+
+func unpadded(padded uint64) uint64 {
+	return padded / 128 * 127
+}
+
+func TestZeroCommitments(t *testing.T) {
+	for padded := uint64(128); padded <= 1<<16; padded <<= 1 {
+		want, _ := commPOf(t, make([]byte, unpadded(padded)))
+		zc := zeroCommitment(log2Ceil(padded / nodeSize))
+		got, err := commcid.DataCommitmentV1ToCID(zc[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equals(want) {
+			t.Fatalf("zero commitment of %d bytes is %s, expected %s", padded, got, want)
+		}
+	}
+}
+
+func TestSegmentDescNodes(t *testing.T) {
+	var commP PieceNode
+	for i := range commP {
+		commP[i] = byte(i)
+	}
+	commP[nodeSize-1] &= 0x3f
+	nodes := segmentDescNodes(commP, 0x0102030405060708, 1<<20)
+
+	// FRC-0058 entry: the commitment, the offset and the size as little endian uint64s, and the first 16 bytes of the
+	// sha256 of the entry with a zero checksum, with the two top bits of the last byte cleared
+	want := make([]byte, DataSegmentEntrySize)
+	copy(want, commP[:])
+	copy(want[32:], []byte{0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01})
+	copy(want[40:], []byte{0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00})
+	sum := sha256.Sum256(want)
+	copy(want[48:], sum[:16])
+	want[63] &= 0x3f
+
+	got := append(nodes[0][:], nodes[1][:]...)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("entry is %x, expected %x", got, want)
+	}
+	if binary.LittleEndian.Uint64(got[40:]) != 1<<20 {
+		t.Fatal("size is not little endian")
+	}
+}
+
+func TestDataSegmentIndexLayout(t *testing.T) {
+	cases := []struct {
+		dealSize, entries uint64
+	}{
+		{128 << 10, 4}, // the index never has room for less than 4 entries
+		{1 << 20, 8},
+		{32 << 30, 262144},
+	}
+	for _, tc := range cases {
+		if got := MaxIndexEntriesInDeal(tc.dealSize); got != tc.entries {
+			t.Fatalf("deal of %d bytes has room for %d entries, expected %d", tc.dealSize, got, tc.entries)
+		}
+		if got := DataSegmentIndexStartOffset(tc.dealSize); got != tc.dealSize-tc.entries*DataSegmentEntrySize {
+			t.Fatalf("index of a deal of %d bytes starts at %d", tc.dealSize, got)
+		}
+	}
+}
+
+func TestDataSegmentAggregate(t *testing.T) {
+	// sub-pieces of different sizes, given smallest first so the layout has to reorder them
+	var pieces []SubPiece
+	var datas [][]byte
+	for _, padded := range []uint64{512, 4096, 1024, 2048} {
+		data := make([]byte, unpadded(padded))
+		if _, err := rand.Read(data); err != nil {
+			t.Fatal(err)
+		}
+		pieceCid, size := commPOf(t, data)
+		if size != padded {
+			t.Fatalf("sub-piece of %d bytes padded to %d", padded, size)
+		}
+		pieces = append(pieces, SubPiece{PieceCid: pieceCid, Size: padded})
+		datas = append(datas, data)
+	}
+
+	agg, err := NewDataSegmentAggregate(pieces, 16<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agg.DealSize != 16<<10 {
+		t.Fatalf("aggregate of %d bytes, expected the min deal size", agg.DealSize)
+	}
+	for i, p := range pieces {
+		if agg.Offsets[i]%p.Size != 0 {
+			t.Fatalf("sub-piece %d at %d is not aligned to its size %d", i, agg.Offsets[i], p.Size)
+		}
+	}
+
+	// the bytes of the aggregate: every sub-piece at its offset, zeros in between and the index at the end
+	piece := make([]byte, unpadded(agg.DealSize))
+	for i, data := range datas {
+		copy(piece[unpadded(agg.Offsets[i]):], data)
+	}
+	copy(piece[unpadded(agg.IndexStartOffset()):], agg.IndexData())
+
+	want, size := commPOf(t, piece)
+	got, err := agg.PieceCid()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != agg.DealSize || !got.Equals(want) {
+		t.Fatalf("aggregate piece is %s, the commitment of its bytes is %s", got, want)
+	}
+
+	for i, p := range pieces {
+		proof := agg.InclusionProof(i)
+		if err := proof.Verify(got, p, agg.Offsets[i]); err != nil {
+			t.Fatalf("proof of sub-piece %d: %s", i, err)
+		}
+		if err := proof.Verify(got, p, agg.Offsets[i]+p.Size); err == nil {
+			t.Fatalf("proof of sub-piece %d verified at the wrong offset", i)
+		}
+		if err := proof.Verify(got, pieces[(i+1)%len(pieces)], agg.Offsets[i]); err == nil {
+			t.Fatalf("proof of sub-piece %d verified for another sub-piece", i)
+		}
+	}
+}
+
+func TestDataSegmentAggregateGrows(t *testing.T) {
+	data := make([]byte, unpadded(8192))
+	pieceCid, _ := commPOf(t, data)
+	pieces := []SubPiece{{PieceCid: pieceCid, Size: 8192}, {PieceCid: pieceCid, Size: 8192}}
+
+	// two sub-pieces of 8 KiB and the index don't fit in 16 KiB
+	agg, err := NewDataSegmentAggregate(pieces, 16<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agg.DealSize != 32<<10 {
+		t.Fatalf("aggregate of %d bytes, expected 32 KiB", agg.DealSize)
+	}
+
+	if _, err := NewDataSegmentAggregate([]SubPiece{{PieceCid: pieceCid, Size: 1000}}, 0); err == nil {
+		t.Fatal("accepted a sub-piece whose size is not a power of two")
+	}
+}
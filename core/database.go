@@ -36,7 +36,13 @@ func OpenDatabase(cfg config.EdgeConfig) (*gorm.DB, error) {
 	sqldb.SetConnMaxLifetime(time.Hour)
 
 	// generate new models.
+	linkSplits := !DB.Migrator().HasColumn(&Content{}, "parent_content_id")
 	ConfigureModels(DB) // create models.
+	if linkSplits {
+		if err := MigrateSplitParents(DB); err != nil {
+			return nil, err
+		}
+	}
 
 	if err != nil {
 		return nil, err
@@ -45,7 +51,7 @@ func OpenDatabase(cfg config.EdgeConfig) (*gorm.DB, error) {
 }
 
 func ConfigureModels(db *gorm.DB) {
-	db.AutoMigrate(&Content{}, &ContentDeal{}, &LogEvent{}, &Bucket{}, &Policy{}, &ContentSignatureMeta{})
+	db.AutoMigrate(&Content{}, &ContentDeal{}, &LogEvent{}, &Bucket{}, &Policy{}, &ContentSignatureMeta{}, &Job{}, &BucketClaim{}, &ContentReplication{}, &Upload{}, &Collection{}, &CollectionRef{}, &CidFetch{}, &ContentEncryption{}, &ApiKey{}, &KeyQuota{})
 }
 
 type LogEvent struct {
@@ -62,61 +68,192 @@ type LogEvent struct {
 }
 
 type Policy struct {
-	ID         int64     `gorm:"primaryKey"`
-	Name       string    `json:"name"`
-	BucketSize int64     `json:"bucket_size"`
-	SplitSize  int64     `json:"split_size"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID              int64  `gorm:"primaryKey" json:"id"`
+	Name            string `gorm:"index" json:"name"`
+	MinBucketSize   int64  `json:"min_bucket_size"` // aged buckets are only sealed once they hold this much
+	BucketSize      int64  `json:"bucket_size"`     // max bucket size, buckets are sealed once they reach it
+	MaxBucketAge    int64  `json:"max_bucket_age"`  // seconds, 0 keeps buckets open until they are full
+	TargetPieceSize int64  `json:"target_piece_size"`
+	SplitSize       int64  `json:"split_size"`
+
+	// deal parameters used when the buckets are submitted to delta
+	DealDurationInDays int64  `json:"deal_duration_in_days"` // 0 uses the node default
+	DealVerifyState    string `json:"deal_verify_state"`     // verified or unverified, empty uses the node default
+	RemoveUnsealedCopy *bool  `json:"remove_unsealed_copy"`  // nil uses the node default
+	AutoRetry          *bool  `json:"auto_retry"`            // nil uses the node default
+	ReplicationFactor  int    `json:"replication_factor"`    // number of storage providers a bucket is handed to, 0 means 1
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 type Bucket struct {
-	ID               int64     `gorm:"primaryKey"`
-	Uuid             string    `gorm:"index" json:"uuid"`
-	Name             string    `json:"name"`
-	Size             int64     `json:"size"`
-	RequestingApiKey string    `json:"requesting_api_key,omitempty"`
-	Miner            string    `json:"miner"`
-	PieceCid         string    `json:"piece_cid"`
-	PieceSize        int64     `json:"piece_size"`
-	DirCid           string    `json:"dir_cid"`
-	Cid              string    `json:"cid"`
-	Status           string    `json:"status"`
-	PolicyId         int64     `json:"policy_id"`
-	LastMessage      string    `json:"last_message"`
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
+	ID          int64     `gorm:"primaryKey"`
+	Uuid        string    `gorm:"index" json:"uuid"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	ApiKeyHash  string    `gorm:"index" json:"-"`
+	Miner       string    `json:"miner"`
+	PieceCid    string    `gorm:"index" json:"piece_cid"`
+	PieceSize   int64     `json:"piece_size"`
+	DirCid      string    `json:"dir_cid"`
+	Cid         string    `json:"cid"`
+	Status      string    `json:"status"`
+	PolicyId    int64     `json:"policy_id"`
+	LastMessage string    `json:"last_message"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// BucketClaim is the lease a storage provider takes on a ready bucket. The lease holds a replica slot of the bucket
+// until the storage provider confirms or releases it, or until the lease expires.
+type BucketClaim struct {
+	ID             int64     `gorm:"primaryKey" json:"id"`
+	BucketUuid     string    `gorm:"index" json:"bucket_uuid"`
+	Miner          string    `gorm:"index" json:"miner"`
+	ApiKeyHash     string    `gorm:"index" json:"-"`
+	Status         string    `gorm:"index" json:"status"` // leased, confirmed, released, expired
+	LeaseExpiresAt time.Time `json:"lease_expires_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Upload is a resumable upload. The data is staged on disk until all of it arrived, the file is then added to its
+// collection like any other upload.
+type Upload struct {
+	ID             int64     `gorm:"primaryKey" json:"-"`
+	Uuid           string    `gorm:"uniqueIndex" json:"uuid"`
+	Name           string    `json:"name"`
+	CollectionName string    `json:"collection_name"`
+	ApiKeyHash     string    `gorm:"index" json:"-"`
+	Length         int64     `json:"length"`
+	Offset         int64     `json:"offset"`
+	Metadata       string    `json:"metadata,omitempty"`  // Upload-Metadata header of the upload
+	Status         string    `gorm:"index" json:"status"` // uploading, processing, completed, failed
+	ContentID      int64     `json:"content_id,omitempty"`
+	LastMessage    string    `json:"last_message,omitempty"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// CidFetch is a cid requested with fetch-cids. The cid fetcher pulls its whole dag from the network and adds it to
+// its collection as a content.
+type CidFetch struct {
+	ID             int64     `gorm:"primaryKey" json:"id"`
+	Cid            string    `gorm:"index" json:"cid"`
+	CollectionName string    `json:"collection_name"`
+	ApiKeyHash     string    `gorm:"index" json:"-"`
+	Status         string    `gorm:"index" json:"status"` // queued, fetching, completed, failed
+	BlocksFetched  int64     `json:"blocks_fetched"`
+	BytesFetched   int64     `json:"bytes_fetched"`
+	Size           int64     `json:"size,omitempty"` // unixfs size of the dag, known once it is fetched
+	ContentID      int64     `json:"content_id,omitempty"`
+	LastMessage    string    `json:"last_message,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Job is a unit of background work persisted so it survives restarts. The payload is the json encoded input of the
+// processor registered for the job type.
+type Job struct {
+	ID          int64     `gorm:"primaryKey"`
+	Type        string    `gorm:"index" json:"type"`
+	JobKey      string    `gorm:"index" json:"job_key"` // jobs with the same type and key are only queued once
+	Payload     string    `json:"payload"`
+	Status      string    `gorm:"index" json:"status"` // queued, running, done, failed
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"max_attempts"`
+	RunAt       time.Time `gorm:"index" json:"run_at"` // not picked up before this time, used for the retry backoff
+	LastError   string    `json:"last_error"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
 }
 
+// ContentSignatureMeta is a signed gateway url issued for a content. The url serves the content without an api key
+// until it expires or is revoked.
 type ContentSignatureMeta struct {
-	ID                  int64     `gorm:"primaryKey"`
-	ContentId           int64     `json:"content_id"`
-	Signature           string    `json:"signature"`
-	CurrentTimestamp    time.Time `json:"current_timestamp"`
-	ExpirationTimestamp time.Time `json:"expiration_timestamp"`
-	SignedUrl           string    `json:"signed_url"`
-	Message             string    `json:"message"`
-	CreatedAt           time.Time `json:"created_at"`
-	UpdatedAt           time.Time `json:"updated_at"`
+	ID                  int64      `gorm:"primaryKey" json:"id"`
+	ContentId           int64      `gorm:"index" json:"content_id"`
+	ApiKeyHash          string     `gorm:"index" json:"-"`
+	Signature           string     `json:"signature"`
+	CurrentTimestamp    time.Time  `json:"current_timestamp"`
+	ExpirationTimestamp time.Time  `json:"expiration_timestamp"`
+	SignedUrl           string     `json:"signed_url"`
+	Message             string     `json:"message"`
+	RevokedAt           *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+// ApiKey is a key of the local auth backend. Only the hash of the key is stored, the key is shown once when it is
+// created.
+type ApiKey struct {
+	ID          int64      `gorm:"primaryKey" json:"id"`
+	KeyHash     string     `gorm:"uniqueIndex" json:"-"`
+	Prefix      string     `json:"prefix"` // first characters of the key, to tell keys apart
+	Owner       string     `gorm:"index" json:"owner"`
+	Role        string     `gorm:"default:uploader" json:"role"`
+	Description string     `json:"description"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// KeyQuota holds the limits of an api key and its usage. Limits that are not set fall back to the config.
+type KeyQuota struct {
+	ID                int64     `gorm:"primaryKey" json:"id"`
+	ApiKeyHash        string    `gorm:"uniqueIndex" json:"key_hash"`
+	MaxBytes          *int64    `json:"max_bytes"`
+	MaxObjects        *int64    `json:"max_objects"`
+	UploadsPerMinute  *int64    `json:"uploads_per_minute"`
+	EgressBytesPerDay *int64    `json:"egress_bytes_per_day"`
+	UsedBytes         int64     `json:"used_bytes"`
+	UsedObjects       int64     `json:"used_objects"`
+	EgressBytes       int64     `json:"egress_bytes"` // served by the gateway since the egress window start
+	EgressWindowStart time.Time `json:"egress_window_start"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
 }
 
 // main content record
 type Content struct {
-	ID               int64     `gorm:"primaryKey"`
-	Name             string    `json:"name"`
-	Size             int64     `json:"size"`
-	Cid              string    `json:"cid"`
-	RequestingApiKey string    `json:"requesting_api_key,omitempty"`
-	BucketUuid       string    `json:"bucket_uuid"`
-	Status           string    `json:"status"`
-	PieceCid         string    `json:"piece_cid"`
-	PieceSize        int64     `json:"piece_size"`
-	LastMessage      string    `json:"last_message"`
-	Miner            string    `json:"miner"`
-	MakeDeal         bool      `json:"make_deal"`
-	CollectionName   string    `json:"collection_name"`
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
+	ID              int64  `gorm:"primaryKey"`
+	Name            string `json:"name"`
+	Size            int64  `json:"size"`
+	Cid             string `json:"cid"`
+	ApiKeyHash      string `gorm:"index" json:"-"`
+	BucketUuid      string `json:"bucket_uuid"`
+	Status          string `json:"status"`
+	PieceCid        string `json:"piece_cid"` // sub-piece of the content when the bucket is a data segment aggregate
+	PieceSize       int64  `json:"piece_size"`
+	PieceOffset     int64  `json:"piece_offset"` // padded offset of the sub-piece in the bucket piece
+	InclusionProof  string `json:"inclusion_proof"`
+	LastMessage     string `json:"last_message"`
+	Miner           string `json:"miner"`
+	MakeDeal        bool   `json:"make_deal"`
+	CollectionName  string `json:"collection_name"`
+	ParentContentID int64  `gorm:"index" json:"parent_content_id,omitempty"` // the content a split was cut from
+	Encrypted       bool   `json:"encrypted"`                                // the stored data is encrypted, see ContentEncryption
+	Replicas        int64  `gorm:"-" json:"replicas"`                        // storage providers holding the piece of the bucket
+
+	Envelope  *ContentEncryption `gorm:"-" json:"-"` // key envelope of a new encrypted content, created along with it
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+// ContentEncryption is the key envelope of an encrypted content. The data key of the content is wrapped with a key
+// derived from the api key the content was uploaded with, so the node can only decrypt it for that api key.
+type ContentEncryption struct {
+	ID         int64     `gorm:"primaryKey" json:"-"`
+	ContentID  int64     `gorm:"uniqueIndex" json:"content_id"`
+	Algorithm  string    `json:"algorithm"`
+	KeySalt    string    `json:"-"` // base64 salt of the key derivation
+	WrappedKey string    `json:"-"` // base64 wrapped data key
+	PlainSize  int64     `json:"plain_size"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
 }
 
 type ContentTag struct {
@@ -127,17 +264,19 @@ type ContentTag struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// Collection is a directory uploaded to a collection, its files are linked to it with a CollectionRef.
 type Collection struct {
-	ID               int64     `gorm:"primaryKey"`
-	UUID             string    `gorm:"index" json:"uuid"`
-	Name             string    `json:"name"`
-	Description      string    `json:"description"`
-	RequestingApiKey string    `json:"requesting_api_key"`
-	Cid              string    `json:"cid"`
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
+	ID          int64     `gorm:"primaryKey"`
+	UUID        string    `gorm:"index" json:"uuid"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	ApiKeyHash  string    `gorm:"index" json:"-"`
+	Cid         string    `json:"cid"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
 }
 
+// CollectionRef places a content at a path of a directory upload.
 type CollectionRef struct {
 	ID         uint      `gorm:"primaryKey"`
 	Collection int64     `gorm:"index:,option:CONCURRENTLY;not null"`
@@ -147,8 +286,16 @@ type CollectionRef struct {
 	UpdatedAt  time.Time `json:"updated_at"`
 }
 
+// ContentReplication is a storage provider holding the piece of a bucket, either because it confirmed a claim on the
+// bucket or because delta made a deal with it.
 type ContentReplication struct {
-	ID                    int64     `gorm:"primaryKey"`
+	ID                    int64     `gorm:"primaryKey" json:"id"`
+	BucketUuid            string    `gorm:"index" json:"bucket_uuid"`
+	PieceCid              string    `json:"piece_cid"`
+	Miner                 string    `gorm:"index" json:"miner"`
+	Status                string    `json:"status"` // claimed, or the state of the deal
+	BucketClaimID         int64     `json:"bucket_claim_id,omitempty"`
+	ContentDealID         int64     `gorm:"index" json:"content_deal_id,omitempty"`
 	PrimaryContentID      int64     `json:"primary_content_id"`
 	PrimaryDeltaContentID int64     `json:"primary_delta_content_id"`
 	ReplicaContentID      int64     `json:"replica_content_id"`
@@ -168,9 +315,11 @@ type ContentDeal struct {
 	UpdatedAt           time.Time `json:"UpdatedAt"`
 	DeletedAt           time.Time `json:"DeletedAt"`
 	ContentId           int64     `json:"delta_content_id"`
+	BucketUuid          string    `gorm:"index" json:"bucket_uuid"`
 	UserID              int       `json:"user_id"`
 	PropCid             string    `json:"propCid"`
 	DealUUID            string    `json:"dealUuid"`
+	DealID              int64     `json:"deal_id"` // on-chain deal id, set once the deal is published
 	Miner               string    `json:"miner"`
 	Status              string    `json:"status"`
 	Failed              bool      `json:"failed"`
@@ -184,6 +333,7 @@ type ContentDeal struct {
 	SealedAt            time.Time `json:"sealedAt"`
 	DealProtocolVersion string    `json:"deal_protocol_version"`
 	MinerVersion        string    `json:"miner_version"`
+	LastMessage         string    `json:"last_message"`
 }
 
 type DbAddrInfo struct {
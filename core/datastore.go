@@ -0,0 +1,93 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/application-research/edge-ur/utils"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dsync "github.com/ipfs/go-datastore/sync"
+	levelds "github.com/ipfs/go-ds-leveldb"
+	lerrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"golang.org/x/xerrors"
+)
+
+const (
+	DatastoreTypeLevelDB = "leveldb"
+	DatastoreTypeMemory  = "memory"
+)
+
+// OpenDatastore opens the datastore backing the whypfs node. The backend is selected with `DS_TYPE` and lives
+// under `DS_REPO`, so the node keeps its state across restarts. The in-memory backend is only meant for throwaway nodes.
+// The blocks are not kept here but in the flatfs blockstore whypfs opens under `REPO`, flatfs can't back this datastore
+// as it only takes block keys.
+func OpenDatastore(cfg config.EdgeConfig) (datastore.Batching, error) {
+	switch cfg.Node.DsType {
+	case DatastoreTypeLevelDB, "":
+		if err := os.MkdirAll(cfg.Node.DsRepo, 0755); err != nil {
+			return nil, xerrors.Errorf("failed to create datastore repo %s: %w", cfg.Node.DsRepo, err)
+		}
+
+		// levelds tries to recover a corrupted store on its own, anything that still comes back corrupted
+		// needs an operator to look at it.
+		ds, err := levelds.NewDatastore(cfg.Node.DsRepo, nil)
+		if lerrors.IsCorrupted(err) {
+			return nil, xerrors.Errorf("datastore at %s is corrupted and could not be recovered: %w", cfg.Node.DsRepo, err)
+		}
+		if err != nil {
+			return nil, xerrors.Errorf("failed to open leveldb datastore at %s: %w", cfg.Node.DsRepo, err)
+		}
+		return ds, nil
+	case DatastoreTypeMemory:
+		return dsync.MutexWrap(datastore.NewMapDatastore()), nil
+	default:
+		return nil, xerrors.Errorf("unsupported datastore type: %s, use %s or %s", cfg.Node.DsType, DatastoreTypeLevelDB, DatastoreTypeMemory)
+	}
+}
+
+// VerifyLocalBlocks checks that the root block of every pinned content and every bucket that was already
+// aggregated is still in the local blockstore. Missing blocks are reported and returned as an error so the
+// operator knows the repo and the database went out of sync. Only the roots are checked, walking every dag would
+// hold up the start of large nodes.
+func VerifyLocalBlocks(ctx context.Context, ln *LightNode) error {
+	var missing []string
+
+	var contents []Content
+	ln.DB.Model(&Content{}).Where("status <> ?", utils.STATUS_UNPINNED).Find(&contents)
+	for _, content := range contents {
+		if !ln.hasLocalBlock(ctx, content.Cid) {
+			missing = append(missing, fmt.Sprintf("content %d (%s)", content.ID, content.Cid))
+		}
+	}
+
+	var buckets []Bucket
+	ln.DB.Model(&Bucket{}).Where("status not in ?", []string{"open", "processing", "deleted"}).Find(&buckets)
+	for _, bucket := range buckets {
+		if !ln.hasLocalBlock(ctx, bucket.DirCid) {
+			missing = append(missing, fmt.Sprintf("bucket %s (%s)", bucket.Uuid, bucket.DirCid))
+		}
+	}
+
+	if len(missing) > 0 {
+		for _, m := range missing {
+			log.Errorf("block not found in local blockstore: %s", m)
+		}
+		return xerrors.Errorf("%d pinned objects are missing from the blockstore at %s", len(missing), ln.Node.StorageDir)
+	}
+	return nil
+}
+
+func (ln *LightNode) hasLocalBlock(ctx context.Context, c string) bool {
+	if c == "" {
+		return true
+	}
+	decoded, err := cid.Decode(c)
+	if err != nil {
+		return false
+	}
+	has, err := ln.Node.Blockstore.Has(ctx, decoded)
+	return err == nil && has
+}
@@ -0,0 +1,200 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/application-research/edge-ur/utils"
+	"golang.org/x/xerrors"
+)
+
+const (
+	DealStatusBackendDelta = "delta"
+	DealStatusBackendMock  = "mock"
+)
+
+// DealStatus is what the deal-making backend knows about a deal.
+type DealStatus struct {
+	PropCid          string
+	DealUUID         string
+	DealID           int64
+	Miner            string
+	Message          string
+	Failed           bool
+	Slashed          bool
+	FailedAt         time.Time
+	TransferStarted  time.Time
+	TransferFinished time.Time
+	OnChainAt        time.Time
+	SealedAt         time.Time
+}
+
+// DealStatusProvider looks up the status of a deal submitted to a deal-making backend.
+type DealStatusProvider interface {
+	DealStatus(ctx context.Context, deal ContentDeal) (DealStatus, error)
+}
+
+// NewDealStatusProvider returns the provider selected with `DEAL_STATUS_BACKEND`.
+func NewDealStatusProvider(cfg config.EdgeConfig) (DealStatusProvider, error) {
+	switch cfg.Delta.StatusBackend {
+	case DealStatusBackendDelta, "":
+		return NewDeltaClient(cfg), nil
+	case DealStatusBackendMock:
+		return &MockDealStatusProvider{}, nil
+	default:
+		return nil, xerrors.Errorf("unsupported deal status backend: %s", cfg.Delta.StatusBackend)
+	}
+}
+
+// DealStatus returns the status of the most recent deal delta made for the content of the deal. With auto retry
+// delta makes a new deal when one fails, so older deals are not looked at.
+func (c *DeltaClient) DealStatus(ctx context.Context, deal ContentDeal) (DealStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/v1/stats/content/%d", c.ApiUrl, deal.ContentId), nil)
+	if err != nil {
+		return DealStatus{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.ApiKey)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return DealStatus{}, xerrors.Errorf("failed to reach delta at %s: %w", c.ApiUrl, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return DealStatus{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return DealStatus{}, xerrors.Errorf("delta returned %d for content %d: %s", resp.StatusCode, deal.ContentId, string(body))
+	}
+
+	var stats struct {
+		Content struct {
+			Status      string `json:"status"`
+			LastMessage string `json:"last_message"`
+		} `json:"content"`
+		Deals []struct {
+			PropCid          string    `json:"propCid"`
+			DealUUID         string    `json:"dealUuid"`
+			DealID           int64     `json:"dealId"`
+			Miner            string    `json:"miner"`
+			Failed           bool      `json:"failed"`
+			Slashed          bool      `json:"slashed"`
+			FailedAt         time.Time `json:"failedAt"`
+			TransferStarted  time.Time `json:"transferStarted"`
+			TransferFinished time.Time `json:"transferFinished"`
+			OnChainAt        time.Time `json:"onChainAt"`
+			SealedAt         time.Time `json:"sealedAt"`
+			LastMessage      string    `json:"lastMessage"`
+		} `json:"deals"`
+	}
+	if err := json.Unmarshal(body, &stats); err != nil {
+		return DealStatus{}, xerrors.Errorf("unexpected delta response: %w", err)
+	}
+
+	status := DealStatus{Message: stats.Content.LastMessage}
+	if status.Message == "" {
+		status.Message = stats.Content.Status
+	}
+	if len(stats.Deals) == 0 {
+		return status, nil
+	}
+	d := stats.Deals[len(stats.Deals)-1]
+	status.PropCid = d.PropCid
+	status.DealUUID = d.DealUUID
+	status.DealID = d.DealID
+	status.Miner = d.Miner
+	status.Failed = d.Failed
+	status.Slashed = d.Slashed
+	status.FailedAt = d.FailedAt
+	status.TransferStarted = d.TransferStarted
+	status.TransferFinished = d.TransferFinished
+	status.OnChainAt = d.OnChainAt
+	status.SealedAt = d.SealedAt
+	if d.LastMessage != "" {
+		status.Message = d.LastMessage
+	}
+	return status, nil
+}
+
+// MockDealStatusProvider moves every deal one step further through its lifecycle each time it is asked, from
+// proposed to on-chain to sealed. It stands in for a deal-making backend on test and development nodes.
+type MockDealStatusProvider struct{}
+
+func (m *MockDealStatusProvider) DealStatus(ctx context.Context, deal ContentDeal) (DealStatus, error) {
+	status := DealStatus{
+		PropCid:          deal.PropCid,
+		DealUUID:         deal.DealUUID,
+		DealID:           deal.DealID,
+		Miner:            deal.Miner,
+		TransferStarted:  deal.TransferStarted,
+		TransferFinished: deal.TransferFinished,
+		OnChainAt:        deal.OnChainAt,
+		SealedAt:         deal.SealedAt,
+	}
+	now := time.Now()
+	switch {
+	case deal.DealUUID == "":
+		status.DealUUID = fmt.Sprintf("mock-%d", deal.ID)
+		status.TransferStarted = now
+		status.Message = "deal proposed"
+	case deal.OnChainAt.IsZero():
+		status.DealID = deal.ID
+		status.TransferFinished = now
+		status.OnChainAt = now
+		status.Message = "deal published"
+	case deal.SealedAt.IsZero():
+		status.SealedAt = now
+		status.Message = "sector sealed"
+	default:
+		status.Message = deal.LastMessage
+	}
+	return status, nil
+}
+
+// DealState returns the lifecycle state of a single deal.
+func DealState(deal ContentDeal) string {
+	switch {
+	case deal.Slashed:
+		return utils.STATUS_DEAL_SLASHED
+	case deal.Failed:
+		return utils.STATUS_DEAL_FAILED
+	case !deal.SealedAt.IsZero():
+		return utils.STATUS_DEAL_SEALED
+	case !deal.OnChainAt.IsZero():
+		return utils.STATUS_DEAL_ON_CHAIN
+	case deal.DealUUID != "" || deal.PropCid != "":
+		return utils.STATUS_DEAL_PROPOSED
+	default:
+		return utils.STATUS_UPLOADED_TO_DELTA
+	}
+}
+
+// dealStateRank orders the states from the least to the most progressed, a bucket is as far as its best deal.
+var dealStateRank = map[string]int{
+	utils.STATUS_DEAL_SLASHED:      0,
+	utils.STATUS_DEAL_FAILED:       1,
+	utils.STATUS_UPLOADED_TO_DELTA: 2,
+	utils.STATUS_DEAL_PROPOSED:     3,
+	utils.STATUS_DEAL_ON_CHAIN:     4,
+	utils.STATUS_DEAL_SEALED:       5,
+}
+
+// BucketDealState returns the lifecycle state of a bucket from the states of its deals. The bucket only fails when
+// all of its deals failed.
+func BucketDealState(deals []ContentDeal) string {
+	state := ""
+	for _, deal := range deals {
+		s := DealState(deal)
+		if state == "" || dealStateRank[s] > dealStateRank[state] {
+			state = s
+		}
+	}
+	return state
+}
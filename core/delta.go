@@ -0,0 +1,110 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/application-research/edge-ur/config"
+	"golang.org/x/xerrors"
+)
+
+// DeltaDealRequest is an import deal request for the delta /deal/imports endpoint. The storage provider pulls the
+// piece from the transfer url.
+type DeltaDealRequest struct {
+	Cid                string `json:"cid,omitempty"` // payload root of the piece, left out for data segment aggregates
+	Miner              string `json:"miner,omitempty"`
+	Size               int64  `json:"size,omitempty"`
+	DurationInDays     int64  `json:"duration_in_days,omitempty"`
+	StartEpochInDays   int64  `json:"start_epoch_in_days,omitempty"`
+	ConnectionMode     string `json:"connection_mode"`
+	RemoveUnsealedCopy bool   `json:"remove_unsealed_copy"`
+	SkipIPNIAnnounce   bool   `json:"skip_ipni_announce"`
+	AutoRetry          bool   `json:"auto_retry"`
+	Label              string `json:"label,omitempty"`
+	DealVerifyState    string `json:"deal_verify_state,omitempty"`
+	PieceCommitment    struct {
+		PieceCid        string `json:"piece_cid"`
+		PaddedPieceSize int64  `json:"padded_piece_size"`
+	} `json:"piece_commitment"`
+	TransferParameters struct {
+		URL string `json:"url"`
+	} `json:"transfer_parameters"`
+}
+
+// DeltaDealResponse is the answer of delta for a single deal request.
+type DeltaDealResponse struct {
+	Status                       string      `json:"status"`
+	Message                      string      `json:"message"`
+	ContentId                    int64       `json:"content_id,omitempty"`
+	DealRequest                  interface{} `json:"deal_request_meta,omitempty"`
+	DealProposalParameterRequest interface{} `json:"deal_proposal_parameter_request_meta,omitempty"`
+}
+
+// DeltaClient talks to the deal-making api of a delta node.
+type DeltaClient struct {
+	ApiUrl string
+	ApiKey string
+	client *http.Client
+}
+
+func NewDeltaClient(cfg config.EdgeConfig) *DeltaClient {
+	return &DeltaClient{
+		ApiUrl: strings.TrimSuffix(cfg.Delta.ApiUrl, "/"),
+		ApiKey: cfg.Delta.ApiKey,
+		client: &http.Client{Timeout: time.Minute},
+	}
+}
+
+// SubmitImportDeals submits import deals and returns the response of delta for every request, in the same order.
+func (c *DeltaClient) SubmitImportDeals(reqs []DeltaDealRequest) ([]DeltaDealResponse, error) {
+	body, err := json.Marshal(reqs)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, c.ApiUrl+"/api/v1/deal/imports", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+c.ApiKey)
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to reach delta at %s: %w", c.ApiUrl, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("delta rejected the deal request (%d): %s", resp.StatusCode, string(respBody))
+	}
+
+	var dealResps []DeltaDealResponse
+	if err := json.Unmarshal(respBody, &dealResps); err != nil {
+		return nil, xerrors.Errorf("unexpected delta response: %w", err)
+	}
+	if len(dealResps) != len(reqs) {
+		return nil, xerrors.Errorf("delta answered %d deal requests out of %d", len(dealResps), len(reqs))
+	}
+	return dealResps, nil
+}
+
+// PublicUrl returns the base url other parties reach the node at.
+func (ln *LightNode) PublicUrl() string {
+	if ln.Config.Node.PublicUrl != "" {
+		return strings.TrimSuffix(ln.Config.Node.PublicUrl, "/")
+	}
+	host := ln.Config.Node.GwHost
+	if ln.PublicIp != "" {
+		host = ln.PublicIp
+	}
+	return fmt.Sprintf("http://%s:%d", host, ln.Config.Node.Port)
+}
@@ -0,0 +1,102 @@
+package core_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/application-research/edge-ur/core"
+	"github.com/application-research/edge-ur/core/deltatest"
+)
+
+// newTestDelta returns a client of a stand-in delta node that answers the deal imports with answer.
+func newTestDelta(t *testing.T, answer func(reqs []core.DeltaDealRequest) (int, interface{})) (*core.DeltaClient, *deltatest.Server) {
+	t.Helper()
+	srv := deltatest.NewServer(t)
+	srv.Answer = answer
+
+	var cfg config.EdgeConfig
+	cfg.Delta.ApiUrl = srv.URL + "/"
+	cfg.Delta.ApiKey = "delta-key"
+	return core.NewDeltaClient(cfg), srv
+}
+
+func TestSubmitImportDeals(t *testing.T) {
+	delta, srv := newTestDelta(t, func(reqs []core.DeltaDealRequest) (int, interface{}) {
+		return http.StatusOK, []map[string]interface{}{
+			{"status": "success", "message": "accepted", "content_id": 41},
+			{"status": "success", "message": "accepted", "content_id": 42},
+		}
+	})
+
+	req := core.DeltaDealRequest{
+		Cid:             "bafy-dir",
+		Miner:           "f01000",
+		Size:            2048,
+		DurationInDays:  540,
+		ConnectionMode:  "import",
+		AutoRetry:       true,
+		Label:           "bucket-uuid",
+		DealVerifyState: "verified",
+	}
+	req.PieceCommitment.PieceCid = "baga-piece"
+	req.PieceCommitment.PaddedPieceSize = 4096
+	req.TransferParameters.URL = "http://edge/piece/baga-piece"
+	second := req
+	second.Miner = ""
+
+	resps, err := delta.SubmitImportDeals([]core.DeltaDealRequest{req, second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := srv.Headers()
+	if len(headers) != 1 || headers[0].Get("Authorization") != "Bearer delta-key" || headers[0].Get("Content-Type") != "application/json" {
+		t.Fatalf("sent the headers %v", headers)
+	}
+	got := srv.Submissions()
+	if len(got) != 1 || len(got[0]) != 2 || got[0][0] != req || got[0][1] != second {
+		t.Fatalf("delta got the deal requests %+v", got)
+	}
+	if len(resps) != 2 || resps[0].ContentId != 41 || resps[1].ContentId != 42 || resps[0].Status != "success" || resps[1].Message != "accepted" {
+		t.Fatalf("mapped the responses to %+v", resps)
+	}
+}
+
+func TestSubmitImportDealsErrors(t *testing.T) {
+	for name, answer := range map[string]func(reqs []core.DeltaDealRequest) (int, interface{}){
+		"rejected": func(reqs []core.DeltaDealRequest) (int, interface{}) {
+			return http.StatusUnauthorized, map[string]string{"error": "invalid api key"}
+		},
+		"short": func(reqs []core.DeltaDealRequest) (int, interface{}) {
+			return http.StatusOK, []map[string]interface{}{{"status": "success", "content_id": 1}}
+		},
+		"malformed": func(reqs []core.DeltaDealRequest) (int, interface{}) {
+			return http.StatusOK, map[string]string{"status": "success"}
+		},
+	} {
+		delta, _ := newTestDelta(t, answer)
+		if _, err := delta.SubmitImportDeals(make([]core.DeltaDealRequest, 2)); err == nil {
+			t.Fatalf("%s: the submission succeeded", name)
+		}
+	}
+}
+
+func TestPublicUrl(t *testing.T) {
+	var cfg config.EdgeConfig
+	cfg.Node.GwHost = "localhost"
+	cfg.Node.Port = 1313
+	ln := &core.LightNode{Config: &cfg}
+
+	// without a public ip the node is reached at its gateway host, nothing is looked up
+	if url := ln.PublicUrl(); url != "http://localhost:1313" {
+		t.Fatalf("public url is %s", url)
+	}
+	ln.PublicIp = "203.0.113.7"
+	if url := ln.PublicUrl(); url != "http://203.0.113.7:1313" {
+		t.Fatalf("public url is %s", url)
+	}
+	cfg.Node.PublicUrl = "https://edge.example/"
+	if url := ln.PublicUrl(); url != "https://edge.example" {
+		t.Fatalf("public url is %s", url)
+	}
+}
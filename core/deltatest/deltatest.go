@@ -0,0 +1,90 @@
+// Package deltatest runs a stand-in delta node for the tests of the packages that submit deals.
+package deltatest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/application-research/edge-ur/core"
+)
+
+// Server is a stand-in delta node answering the deal imports. It accepts as many deals of every submission as Accept
+// allows, unless Answer is set to answer the submissions instead. The submissions and the headers they came with are
+// recorded.
+type Server struct {
+	URL    string
+	Accept int
+	Answer func(reqs []core.DeltaDealRequest) (int, interface{})
+
+	lk          sync.Mutex
+	nextId      int64
+	submissions [][]core.DeltaDealRequest
+	headers     []http.Header
+}
+
+// NewServer starts a stand-in delta node that is closed at the end of the test.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	s := &Server{}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	s.URL = srv.URL
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if r.URL.Path != "/api/v1/deal/imports" || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	var reqs []core.DeltaDealRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.submissions = append(s.submissions, reqs)
+	s.headers = append(s.headers, r.Header.Clone())
+
+	status, body := http.StatusOK, interface{}(nil)
+	if s.Answer != nil {
+		status, body = s.Answer(reqs)
+	} else {
+		body = s.accept(reqs)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// accept accepts the first Accept deals of a submission and rejects the others.
+func (s *Server) accept(reqs []core.DeltaDealRequest) []core.DeltaDealResponse {
+	resps := make([]core.DeltaDealResponse, len(reqs))
+	for i := range reqs {
+		if i >= s.Accept {
+			resps[i] = core.DeltaDealResponse{Status: "error", Message: "no storage provider available"}
+			continue
+		}
+		s.nextId++
+		resps[i] = core.DeltaDealResponse{Status: "success", Message: "deal accepted", ContentId: s.nextId}
+	}
+	return resps
+}
+
+// Submissions returns the deal requests of every submission so far.
+func (s *Server) Submissions() [][]core.DeltaDealRequest {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return append([][]core.DeltaDealRequest(nil), s.submissions...)
+}
+
+// Headers returns the headers of every submission so far.
+func (s *Server) Headers() []http.Header {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return append([]http.Header(nil), s.headers...)
+}
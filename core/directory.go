@@ -0,0 +1,114 @@
+package core
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
+	uio "github.com/ipfs/go-unixfs/io"
+	"github.com/multiformats/go-multihash"
+	"golang.org/x/xerrors"
+)
+
+// DirectoryEntry is a file placed at a relative path of a directory.
+type DirectoryEntry struct {
+	Path string
+	Node ipld.Node
+}
+
+// CleanRelativePath normalizes the relative path of a file in a directory upload. Absolute paths and paths leaving
+// the directory are rejected.
+func CleanRelativePath(p string) (string, error) {
+	p = strings.ReplaceAll(p, "\\", "/")
+	if strings.HasPrefix(p, "/") {
+		return "", xerrors.Errorf("path %s is not relative", p)
+	}
+	cleaned := path.Clean(p)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", xerrors.Errorf("path %s is not inside the directory", p)
+	}
+	return cleaned, nil
+}
+
+// BuildDirectory builds the unixfs directory tree holding the entries at their paths, creating the intermediate
+// directories, and returns its root along with every directory node, the root last. The directory nodes are added to
+// the dag service.
+func BuildDirectory(ctx context.Context, dserv ipld.DAGService, entries []DirectoryEntry) (ipld.Node, []ipld.Node, error) {
+	cidBuilder, err := merkledag.PrefixForCidVersion(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	cidBuilder.MhType = uint64(multihash.SHA2_256)
+	cidBuilder.MhLength = -1
+
+	dirs := map[string]uio.Directory{}
+	var getDir func(p string) uio.Directory
+	getDir = func(p string) uio.Directory {
+		if dir, ok := dirs[p]; ok {
+			return dir
+		}
+		dir := uio.NewDirectory(dserv)
+		dir.SetCidBuilder(cidBuilder)
+		dirs[p] = dir
+		if p != "." {
+			getDir(path.Dir(p)) // make sure the parents exist
+		}
+		return dir
+	}
+	getDir(".")
+
+	files := map[string]bool{}
+	for _, entry := range entries {
+		p, err := CleanRelativePath(entry.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if files[p] {
+			return nil, nil, xerrors.Errorf("path %s is used twice", p)
+		}
+		files[p] = true
+		if err := getDir(path.Dir(p)).AddChild(ctx, path.Base(p), entry.Node); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// add the directories to their parents, deepest first so every directory is complete when it is added
+	var dirPaths []string
+	for p := range dirs {
+		if p != "." {
+			if files[p] {
+				return nil, nil, xerrors.Errorf("path %s is both a file and a directory", p)
+			}
+			dirPaths = append(dirPaths, p)
+		}
+	}
+	sort.Slice(dirPaths, func(i, j int) bool {
+		return strings.Count(dirPaths[i], "/") > strings.Count(dirPaths[j], "/")
+	})
+	var dirNodes []ipld.Node
+	for _, p := range dirPaths {
+		dirNode, err := dirs[p].GetNode()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := dserv.Add(ctx, dirNode); err != nil {
+			return nil, nil, err
+		}
+		if err := dirs[path.Dir(p)].AddChild(ctx, path.Base(p), dirNode); err != nil {
+			return nil, nil, err
+		}
+		dirNodes = append(dirNodes, dirNode)
+	}
+
+	root, err := dirs["."].GetNode()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := dserv.Add(ctx, root); err != nil {
+		return nil, nil, err
+	}
+	return root, append(dirNodes, root), nil
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+
+	"github.com/application-research/edge-ur/utils"
+	"github.com/patrickmn/go-cache"
+	"gorm.io/gorm"
+)
+
+// derivedKeys keeps the key encryption keys derived lately, deriving one takes a large amount of memory and time on
+// purpose and ranged gateway reads would otherwise derive it on every request.
+var derivedKeys = cache.New(10*time.Minute, 20*time.Minute)
+
+// NewContentKey creates the data key of a content of plainSize bytes and its envelope, the envelope is recorded along
+// with the content, see CreateContent.
+func NewContentKey(apiKey string, plainSize int64) ([]byte, ContentEncryption, error) {
+	var envelope ContentEncryption
+	dek, err := utils.NewDataKey()
+	if err != nil {
+		return nil, envelope, err
+	}
+	salt := make([]byte, utils.SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, envelope, err
+	}
+	wrapped, err := utils.WrapKey(deriveKey(apiKey, salt), dek)
+	if err != nil {
+		return nil, envelope, err
+	}
+
+	envelope = ContentEncryption{
+		Algorithm:  utils.StreamAlgorithm,
+		KeySalt:    base64.StdEncoding.EncodeToString(salt),
+		WrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+		PlainSize:  plainSize,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	return dek, envelope, nil
+}
+
+// ContentKey unwraps the data key of an encrypted content with the api key it was uploaded with.
+func ContentKey(db *gorm.DB, contentId int64, apiKey string) ([]byte, ContentEncryption, error) {
+	var envelope ContentEncryption
+	if err := db.Model(&ContentEncryption{}).Where("content_id = ?", contentId).First(&envelope).Error; err != nil {
+		return nil, envelope, err
+	}
+	salt, err := base64.StdEncoding.DecodeString(envelope.KeySalt)
+	if err != nil {
+		return nil, envelope, err
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(envelope.WrappedKey)
+	if err != nil {
+		return nil, envelope, err
+	}
+	dek, err := utils.UnwrapKey(deriveKey(apiKey, salt), wrapped)
+	return dek, envelope, err
+}
+
+func deriveKey(apiKey string, salt []byte) []byte {
+	id := sha256.Sum256(append([]byte(apiKey), salt...))
+	cacheKey := base64.StdEncoding.EncodeToString(id[:])
+	if kek, ok := derivedKeys.Get(cacheKey); ok {
+		return kek.([]byte)
+	}
+	kek := utils.DeriveKey([]byte(apiKey), salt)
+	derivedKeys.Set(cacheKey, kek, cache.DefaultExpiration)
+	return kek
+}
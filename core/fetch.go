@@ -0,0 +1,94 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+const (
+	FetchStatusQueued    = "queued"
+	FetchStatusFetching  = "fetching"
+	FetchStatusCompleted = "completed"
+	FetchStatusFailed    = "failed"
+)
+
+// FetchProgress is how much of a dag has been fetched so far.
+type FetchProgress struct {
+	Blocks int64
+	Bytes  int64
+}
+
+// FetchDag fetches every block of the dag under root into the blockstore, the blocks that are already local are not
+// fetched again. Each block has to arrive within blockTimeout. The progress function is called after every block,
+// possibly from several goroutines at once.
+func (ln *LightNode) FetchDag(ctx context.Context, root cid.Cid, blockTimeout time.Duration, progress func(FetchProgress)) (ipld.Node, error) {
+	ng := ln.Node.Session(ctx)
+
+	var blocks, bytes int64
+	var rootNode ipld.Node
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		blockCtx, cancel := context.WithTimeout(ctx, blockTimeout)
+		defer cancel()
+		nd, err := ng.Get(blockCtx, c)
+		if err != nil {
+			return nil, err
+		}
+		if c.Equals(root) {
+			rootNode = nd
+		}
+		if progress != nil {
+			progress(FetchProgress{
+				Blocks: atomic.AddInt64(&blocks, 1),
+				Bytes:  atomic.AddInt64(&bytes, int64(len(nd.RawData()))),
+			})
+		}
+		return nd.Links(), nil
+	}
+
+	var lk sync.Mutex
+	visited := cid.NewSet()
+	visit := func(c cid.Cid) bool {
+		lk.Lock()
+		defer lk.Unlock()
+		return visited.Visit(c)
+	}
+	if err := merkledag.Walk(ctx, getLinks, root, visit, merkledag.Concurrent()); err != nil {
+		return nil, err
+	}
+	return rootNode, nil
+}
+
+// UnixfsSize returns the size of the data under a dag node: the file size of a unixfs file, the block size of a raw
+// leaf, and the cumulative size of the blocks for directories and other dags.
+func UnixfsSize(nd ipld.Node) (int64, error) {
+	switch n := nd.(type) {
+	case *merkledag.RawNode:
+		return int64(len(n.RawData())), nil
+	case *merkledag.ProtoNode:
+		fsNode, err := unixfs.FSNodeFromBytes(n.Data())
+		if err == nil && (fsNode.Type() == unixfs.TFile || fsNode.Type() == unixfs.TRaw) {
+			return int64(fsNode.FileSize()), nil
+		}
+	}
+	size, err := nd.Size()
+	return int64(size), err
+}
+
+// IsUnixfsFile tells if a node is the root of a unixfs file or a raw block, rather than a directory or another dag.
+func IsUnixfsFile(nd ipld.Node) bool {
+	switch n := nd.(type) {
+	case *merkledag.RawNode:
+		return true
+	case *merkledag.ProtoNode:
+		fsNode, err := unixfs.FSNodeFromBytes(n.Data())
+		return err == nil && (fsNode.Type() == unixfs.TFile || fsNode.Type() == unixfs.TRaw)
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+func TestUnixfsFileSize(t *testing.T) {
+	cases := []struct {
+		name string
+		nd   ipld.Node
+		file bool
+		size int64
+	}{
+		{"raw block", merkledag.NewRawNode([]byte("hello")), true, 5},
+		{"unixfs file", merkledag.NodeWithData(unixfs.FilePBData([]byte("hello world"), 11)), true, 11},
+		{"unixfs directory", unixfs.EmptyDirNode(), false, 0},
+	}
+	for _, tc := range cases {
+		if IsUnixfsFile(tc.nd) != tc.file {
+			t.Fatalf("%s: file is %t", tc.name, !tc.file)
+		}
+		if !tc.file {
+			continue
+		}
+		if size, err := UnixfsSize(tc.nd); err != nil || size != tc.size {
+			t.Fatalf("%s: file of %d bytes: %v", tc.name, size, err)
+		}
+	}
+}
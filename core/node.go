@@ -4,8 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/application-research/edge-ur/config"
-	"github.com/ipfs/go-datastore"
-	dsync "github.com/ipfs/go-datastore/sync"
+	logging "github.com/ipfs/go-log/v2"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -36,12 +35,22 @@ import (
 	"gorm.io/gorm"
 )
 
+var log = logging.Logger("core")
+
 type LightNode struct {
-	Node   *whypfs.Node
-	Api    url.URL
-	DB     *gorm.DB
-	Gw     *GatewayHandler
-	Config *config.EdgeConfig
+	Node    *whypfs.Node
+	Api     url.URL
+	DB      *gorm.DB
+	Gw      *GatewayHandler
+	Buckets *BucketAllocator
+	Claims  *BucketClaimer
+	Signer  *UrlSigner
+	Auth    Authenticator
+	Keys    *ApiKeyHasher
+	Quotas  *QuotaKeeper
+	Config  *config.EdgeConfig
+
+	PublicIp string // resolved once at startup, empty for offline nodes or when the lookup failed
 }
 
 type LocalWallet struct {
@@ -64,24 +73,25 @@ func NewEdgeNode(ctx context.Context, cfg config.EdgeConfig) (*LightNode, error)
 
 	db, err := OpenDatabase(cfg)
 	// node
-	publicIp, err := GetPublicIP()
 	newConfig := &whypfs.Config{
-		ListenAddrs: []string{
-			"/ip4/0.0.0.0/tcp/6745",
-			"/ip4/0.0.0.0/udp/6746/quic",
-			"/ip4/" + publicIp + "/tcp/6745",
-		},
-		AnnounceAddrs: []string{
-			"/ip4/0.0.0.0/tcp/6745",
-			"/ip4/" + publicIp + "/tcp/6745",
-		},
+		NoAnnounceContent: cfg.Network.Offline,
+		ListenAddrs:       append([]string{}, cfg.Network.ListenAddrs...),
+		AnnounceAddrs:     append([]string{}, cfg.Network.AnnounceAddrs...),
+	}
+	publicIp, err := ResolvePublicIP(cfg)
+	if err != nil {
+		fmt.Println("Error getting public IP:", err)
+	}
+	if publicIp != "" {
+		newConfig.ListenAddrs = append(newConfig.ListenAddrs, "/ip4/"+publicIp+"/tcp/6745")
+		newConfig.AnnounceAddrs = append(newConfig.AnnounceAddrs, "/ip4/"+publicIp+"/tcp/6745")
 	}
 	fmt.Println("cfg.Node.Repo is: ", cfg.Node.Repo)
 	fmt.Println("cfg.Node.DsRepo is: ", cfg.Node.DsRepo)
-	ds := dsync.MutexWrap(datastore.NewMapDatastore())
-	//ds, err := levelds.NewDatastore(cfg.Node.DsRepo, nil)
+	fmt.Println("cfg.Node.DsType is: ", cfg.Node.DsType)
+	ds, err := OpenDatastore(cfg)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	params := whypfs.NewNodeParams{
 		Ctx:       ctx,
@@ -90,22 +100,62 @@ func NewEdgeNode(ctx context.Context, cfg config.EdgeConfig) (*LightNode, error)
 	}
 
 	params.Config = params.ConfigurationBuilder(newConfig)
-	whypfsPeer, err := whypfs.NewNode(params)
-	if err != nil {
-		panic(err)
+	params.Config.Offline = cfg.Network.Offline // not carried over by the builder
+	var whypfsPeer *whypfs.Node
+	if cfg.Network.Offline {
+		whypfsPeer, err = NewOfflineNode(params)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		whypfsPeer, err = whypfs.NewNode(params)
+		if err != nil {
+			panic(err)
+		}
 	}
 
-	whypfsPeer.BootstrapPeers(config.BootstrapEstuaryPeers())
+	bootstrapPeers, err := config.BootstrapPeers(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(bootstrapPeers) > 0 {
+		whypfsPeer.BootstrapPeers(bootstrapPeers)
+	}
 
 	// gateway
 	gw, err := NewGatewayHandler(whypfsPeer)
 
+	signer, err := NewUrlSigner(urlSigningKeyPath(cfg.Node.UrlSigningKey, cfg.Node.Repo))
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := NewApiKeyHasher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := MigrateApiKeyHashes(db, keys); err != nil {
+		return nil, err
+	}
+	auth, err := NewAuthenticator(cfg, db, keys)
+	if err != nil {
+		return nil, err
+	}
+
 	// create the global light node.
 	return &LightNode{
-		Node:   whypfsPeer,
-		Gw:     gw,
-		DB:     db,
-		Config: &cfg,
+		Node:    whypfsPeer,
+		Gw:      gw,
+		DB:      db,
+		Buckets: NewBucketAllocator(db),
+		Claims:  NewBucketClaimer(db, cfg),
+		Signer:  signer,
+		Auth:    auth,
+		Keys:    keys,
+		Quotas:  NewQuotaKeeper(db, cfg),
+		Config:  &cfg,
+
+		PublicIp: publicIp,
 	}, nil
 }
 
@@ -141,16 +191,28 @@ func (ln *LightNode) GetLocalhostOrigins() []string {
 		origins = append(origins, peerInfo.ID.String())
 	}
 
-	publicIp, err := GetPublicIP()
-	if err != nil {
-		panic(err)
+	if ln.PublicIp == "" {
+		return origins
 	}
-	origins = append(origins, "/ip4/"+publicIp+"/tcp/6745/p2p/"+ln.Node.Host.ID().String())
+	origins = append(origins, "/ip4/"+ln.PublicIp+"/tcp/6745/p2p/"+ln.Node.Host.ID().String())
 	return origins
 }
 
+// ResolvePublicIP returns the configured public ip, or looks it up when none is configured. Offline nodes don't
+// have a public ip. The node resolves it once at startup, use LightNode.PublicIp.
+func ResolvePublicIP(cfg config.EdgeConfig) (string, error) {
+	if cfg.Network.Offline {
+		return "", nil
+	}
+	if cfg.Network.PublicIp != "" {
+		return cfg.Network.PublicIp, nil
+	}
+	return GetPublicIP()
+}
+
 func GetPublicIP() (string, error) {
-	resp, err := http.Get("https://ifconfig.me") // important to get the public ip if possible.
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get("https://ifconfig.me") // important to get the public ip if possible.
 	if err != nil {
 		return "", err
 	}
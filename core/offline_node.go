@@ -0,0 +1,111 @@
+package core
+
+import (
+	crand "crypto/rand"
+	"os"
+	"path/filepath"
+
+	"github.com/application-research/whypfs-core"
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/provider"
+	flatfs "github.com/ipfs/go-ds-flatfs"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"golang.org/x/xerrors"
+)
+
+// NewOfflineNode builds the ipfs node of an offline edge node. whypfs always starts the dht and full routing table
+// clients and maps ports on the router, so the node is put together here without them: its blocks only come from the
+// local blockstore and nothing is announced. It keeps the identity and the blocks of the whypfs repo.
+func NewOfflineNode(params whypfs.NewNodeParams) (*whypfs.Node, error) {
+	if params.Repo == "" {
+		params.Repo = ".whypfs"
+	}
+	if err := os.MkdirAll(params.Repo, 0755); err != nil {
+		return nil, err
+	}
+	cfg := params.Config
+	cfg.Offline = true
+	cfg.Blockstore = ":flatfs:" + filepath.Join(params.Repo, "blocks")
+
+	identity, err := loadIdentity(cfg.Libp2pKeyFile)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load the libp2p key: %w", err)
+	}
+	cmgr, err := connmgr.NewConnManager(cfg.ConnectionManagerConfig.LowWater, cfg.ConnectionManagerConfig.HighWater)
+	if err != nil {
+		return nil, err
+	}
+	h, err := libp2p.New(
+		libp2p.ListenAddrStrings(cfg.ListenAddrs...),
+		libp2p.ConnectionManager(cmgr),
+		libp2p.Identity(identity),
+		libp2p.DefaultTransports,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// the same layout as the whypfs flatfs blockstore
+	shard, err := flatfs.ParseShardFunc("/repo/flatfs/shard/v1/next-to-last/3")
+	if err != nil {
+		return nil, err
+	}
+	blocksDir := filepath.Join(params.Repo, "blocks")
+	ds, err := flatfs.CreateOrOpen(blocksDir, shard, false)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := blockstore.CachedBlockstore(params.Ctx, blockstore.NewBlockstore(ds, blockstore.NoPrefix()), blockstore.CacheOpts{
+		HasARCCacheSize: 8 << 20,
+	})
+	if err != nil {
+		return nil, err
+	}
+	exch := offline.Exchange(bs)
+	bsvc := blockservice.New(bs, exch)
+
+	node := &whypfs.Node{
+		Ctx:          params.Ctx,
+		Config:       cfg,
+		Host:         h,
+		StorageDir:   blocksDir,
+		DAGService:   merkledag.NewDAGService(bsvc),
+		Blockstore:   bs,
+		Blockservice: bsvc,
+		Datastore:    params.Datastore,
+		System:       provider.NewNoopProvider(),
+		Exchange:     exch,
+	}
+	go func() {
+		<-params.Ctx.Done()
+		bsvc.Close()
+		h.Close()
+	}()
+	return node, nil
+}
+
+// loadIdentity reads the libp2p key of the node, the way whypfs does, so the node keeps its peer id when it goes
+// offline. A key is created when there is none.
+func loadIdentity(keyFile string) (crypto.PrivKey, error) {
+	data, err := os.ReadFile(keyFile)
+	if err == nil {
+		return crypto.UnmarshalPrivateKey(data)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	k, _, err := crypto.GenerateEd25519Key(crand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	data, err = crypto.MarshalPrivateKey(k)
+	if err != nil {
+		return nil, err
+	}
+	return k, os.WriteFile(keyFile, data, 0600)
+}
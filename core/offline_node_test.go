@@ -0,0 +1,52 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/application-research/whypfs-core"
+)
+
+func TestNewOfflineNode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	repo := t.TempDir()
+	params := whypfs.NewNodeParams{Ctx: ctx, Repo: repo}
+	params.Config = params.ConfigurationBuilder(&whypfs.Config{
+		Libp2pKeyFile: filepath.Join(repo, "libp2p.key"),
+		ListenAddrs:   []string{"/ip4/127.0.0.1/tcp/0"},
+	})
+
+	node, err := NewOfflineNode(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Dht != nil || node.FullRt != nil || node.FilDht != nil || node.Bitswap != nil {
+		t.Fatal("the offline node started network clients")
+	}
+
+	nd, err := node.AddPinFile(ctx, bytes.NewReader([]byte("offline data")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := node.GetFile(ctx, nd.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil || string(data) != "offline data" {
+		t.Fatalf("read back %q: %v", data, err)
+	}
+
+	// the node keeps its peer id
+	again, err := NewOfflineNode(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Host.ID() != node.Host.ID() {
+		t.Fatalf("the peer id changed from %s to %s", node.Host.ID(), again.Host.ID())
+	}
+}
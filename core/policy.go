@@ -0,0 +1,104 @@
+package core
+
+import (
+	"math/bits"
+	"time"
+
+	"github.com/application-research/edge-ur/config"
+	"golang.org/x/xerrors"
+)
+
+// PolicyForCollection returns the policy of a collection. Collections without a policy get one with the bucket sizes
+// and deal parameters of the node config.
+func (ln *LightNode) PolicyForCollection(name string) (Policy, error) {
+	var policy Policy
+	err := ln.DB.Where(Policy{Name: name}).Attrs(Policy{
+		BucketSize:         ln.Config.Common.BucketAggregateSize,
+		SplitSize:          ln.Config.Common.SplitSize,
+		DealDurationInDays: ln.Config.Delta.DealDurationInDays,
+		DealVerifyState:    ln.Config.Delta.DealVerifyState,
+		ReplicationFactor:  ln.Config.Common.ReplicationFactor,
+		CreatedAt:          time.Now(),
+		UpdatedAt:          time.Now(),
+	}).FirstOrCreate(&policy).Error
+	if err != nil {
+		return Policy{}, xerrors.Errorf("failed to load the policy of collection %s: %w", name, err)
+	}
+	return policy, nil
+}
+
+// Validate checks that the settings of the policy are consistent with each other.
+func (p Policy) Validate() error {
+	if p.Name == "" {
+		return xerrors.New("policy name is required")
+	}
+	if p.MinBucketSize < 0 || p.BucketSize < 0 || p.MaxBucketAge < 0 || p.TargetPieceSize < 0 || p.SplitSize < 0 {
+		return xerrors.New("policy sizes and age can not be negative")
+	}
+	if p.BucketSize == 0 {
+		return xerrors.New("bucket size is required")
+	}
+	if p.MinBucketSize > p.BucketSize {
+		return xerrors.Errorf("min bucket size %d is larger than the bucket size %d", p.MinBucketSize, p.BucketSize)
+	}
+	if p.DealDurationInDays < 0 {
+		return xerrors.New("deal duration can not be negative")
+	}
+	if p.ReplicationFactor < 0 {
+		return xerrors.New("replication factor can not be negative")
+	}
+	if p.DealVerifyState != "" && p.DealVerifyState != "verified" && p.DealVerifyState != "unverified" {
+		return xerrors.Errorf("deal verify state must be verified or unverified, got %s", p.DealVerifyState)
+	}
+	if p.TargetPieceSize != 0 {
+		if bits.OnesCount64(uint64(p.TargetPieceSize)) != 1 {
+			return xerrors.Errorf("target piece size %d is not a power of two", p.TargetPieceSize)
+		}
+		// the car of a full bucket has to fit in the padded piece, fr32 padding takes 1/128 of it.
+		if p.BucketSize > p.TargetPieceSize/128*127 {
+			return xerrors.Errorf("bucket size %d does not fit in the target piece size %d", p.BucketSize, p.TargetPieceSize)
+		}
+	}
+	return nil
+}
+
+// DealAutoRetry tells if delta retries the failed deals of the buckets of the policy, policies that don't say follow
+// the node config.
+func (p Policy) DealAutoRetry(cfg config.EdgeConfig) bool {
+	if p.AutoRetry == nil {
+		return cfg.Delta.AutoRetry
+	}
+	return *p.AutoRetry
+}
+
+// DealRemoveUnsealedCopy tells if the storage providers drop the unsealed copy of the pieces of the policy, policies
+// that don't say follow the node config.
+func (p Policy) DealRemoveUnsealedCopy(cfg config.EdgeConfig) bool {
+	if p.RemoveUnsealedCopy == nil {
+		return cfg.Delta.RemoveUnsealedCopy
+	}
+	return *p.RemoveUnsealedCopy
+}
+
+// Replicas returns the number of storage providers a bucket of the policy is handed to.
+func (p Policy) Replicas() int {
+	if p.ReplicationFactor <= 0 {
+		return 1
+	}
+	return p.ReplicationFactor
+}
+
+// ShouldSeal tells if a bucket holding totalSize bytes has to be sealed. Buckets are sealed once they reach the bucket
+// size, or once they are older than the max bucket age and hold at least the min bucket size.
+func (p Policy) ShouldSeal(bucket Bucket, totalSize int64, now time.Time) bool {
+	if totalSize <= 0 {
+		return false
+	}
+	if p.BucketSize > 0 && totalSize >= p.BucketSize {
+		return true
+	}
+	if p.MaxBucketAge > 0 && totalSize >= p.MinBucketSize {
+		return now.Sub(bucket.CreatedAt) >= time.Duration(p.MaxBucketAge)*time.Second
+	}
+	return false
+}
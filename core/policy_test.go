@@ -0,0 +1,24 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/application-research/edge-ur/config"
+)
+
+func TestPolicyDealDefaults(t *testing.T) {
+	var cfg config.EdgeConfig
+	cfg.Delta.AutoRetry = true
+	cfg.Delta.RemoveUnsealedCopy = true
+
+	var policy Policy
+	if !policy.DealAutoRetry(cfg) || !policy.DealRemoveUnsealedCopy(cfg) {
+		t.Fatal("a policy that doesn't say didn't follow the config")
+	}
+	no := false
+	policy.AutoRetry = &no
+	policy.RemoveUnsealedCopy = &no
+	if policy.DealAutoRetry(cfg) || policy.DealRemoveUnsealedCopy(cfg) {
+		t.Fatal("the config overrode the policy")
+	}
+}
@@ -0,0 +1,233 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/application-research/edge-ur/config"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrQuotaBytes   = xerrors.New("the api key reached its storage quota")
+	ErrQuotaObjects = xerrors.New("the api key reached its object quota")
+	ErrQuotaEgress  = xerrors.New("the api key reached its daily gateway egress quota")
+)
+
+// egressWindow is how long gateway egress is counted before the counter starts over.
+const egressWindow = 24 * time.Hour
+
+// QuotaLimits are the limits that apply to an api key, 0 means no limit.
+type QuotaLimits struct {
+	MaxBytes          int64 `json:"max_bytes"`
+	MaxObjects        int64 `json:"max_objects"`
+	UploadsPerMinute  int64 `json:"uploads_per_minute"`
+	EgressBytesPerDay int64 `json:"egress_bytes_per_day"`
+}
+
+// RateLimit is the state of the upload rate limit of an api key, for the rate limit headers.
+type RateLimit struct {
+	Limit     int64
+	Remaining int64
+	Reset     time.Time
+}
+
+// QuotaKeeper keeps the usage of the api keys against their limits. Storage and egress are counted in the key_quota
+// table as they change, uploads per minute only in memory.
+type QuotaKeeper struct {
+	db       *gorm.DB
+	defaults QuotaLimits
+
+	lk      sync.Mutex
+	windows map[string]*uploadWindow // per key hash
+}
+
+type uploadWindow struct {
+	start time.Time
+	count int64
+}
+
+func NewQuotaKeeper(db *gorm.DB, cfg config.EdgeConfig) *QuotaKeeper {
+	return &QuotaKeeper{
+		db: db,
+		defaults: QuotaLimits{
+			MaxBytes:          cfg.Common.CapacityLimitPerKeyInBytes,
+			MaxObjects:        cfg.Quota.MaxObjects,
+			UploadsPerMinute:  cfg.Quota.UploadsPerMinute,
+			EgressBytesPerDay: cfg.Quota.EgressBytesPerDay,
+		},
+		windows: make(map[string]*uploadWindow),
+	}
+}
+
+// Quota returns the quota record of a key. The record is created on first use, with the usage of the contents the
+// key already has.
+func (q *QuotaKeeper) Quota(keyHash string) (KeyQuota, error) {
+	var quota KeyQuota
+	if err := q.db.Model(&KeyQuota{}).Where("api_key_hash = ?", keyHash).Find(&quota).Error; err != nil {
+		return quota, err
+	}
+	if quota.ID != 0 {
+		return quota, nil
+	}
+
+	quota = KeyQuota{
+		ApiKeyHash: keyHash,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	// the splits of a large content are not counted again
+	err := q.db.Model(&Content{}).Where("api_key_hash = ? and parent_content_id = 0", keyHash).
+		Select("COALESCE(SUM(size), 0) as used_bytes, COUNT(*) as used_objects").
+		Row().Scan(&quota.UsedBytes, &quota.UsedObjects)
+	if err != nil {
+		return quota, err
+	}
+	// another request may have created the record in between
+	if err := q.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&quota).Error; err != nil {
+		return quota, err
+	}
+	err = q.db.Model(&KeyQuota{}).Where("api_key_hash = ?", keyHash).First(&quota).Error
+	return quota, err
+}
+
+// Limits returns the limits that apply to a quota record, the limits the record doesn't set come from the config.
+func (q *QuotaKeeper) Limits(quota KeyQuota) QuotaLimits {
+	limits := q.defaults
+	if quota.MaxBytes != nil {
+		limits.MaxBytes = *quota.MaxBytes
+	}
+	if quota.MaxObjects != nil {
+		limits.MaxObjects = *quota.MaxObjects
+	}
+	if quota.UploadsPerMinute != nil {
+		limits.UploadsPerMinute = *quota.UploadsPerMinute
+	}
+	if quota.EgressBytesPerDay != nil {
+		limits.EgressBytesPerDay = *quota.EgressBytesPerDay
+	}
+	return limits
+}
+
+// CheckStorage fails when the key can't add a content of size bytes, a size of 0 is for contents whose size is not
+// known up front and only fails when the key is already at its quota.
+func (q *QuotaKeeper) CheckStorage(keyHash string, size int64) error {
+	quota, err := q.Quota(keyHash)
+	if err != nil {
+		return err
+	}
+	limits := q.Limits(quota)
+	if limits.MaxBytes > 0 && (quota.UsedBytes >= limits.MaxBytes || quota.UsedBytes+size > limits.MaxBytes) {
+		return ErrQuotaBytes
+	}
+	if limits.MaxObjects > 0 && quota.UsedObjects >= limits.MaxObjects {
+		return ErrQuotaObjects
+	}
+	return nil
+}
+
+// AddStorage counts contents recorded for a key.
+func (q *QuotaKeeper) AddStorage(keyHash string, bytes int64, objects int64) error {
+	result := q.db.Model(&KeyQuota{}).Where("api_key_hash = ?", keyHash).UpdateColumns(map[string]interface{}{
+		"used_bytes":   gorm.Expr("used_bytes + ?", bytes),
+		"used_objects": gorm.Expr("used_objects + ?", objects),
+		"updated_at":   time.Now(),
+	})
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+	// a new record counts the contents already recorded, this one included
+	_, err := q.Quota(keyHash)
+	return err
+}
+
+// AllowUpload counts an upload of the key in the current minute, the upload is not allowed when the key already made
+// as many uploads as its limit.
+func (q *QuotaKeeper) AllowUpload(keyHash string) (RateLimit, bool, error) {
+	quota, err := q.Quota(keyHash)
+	if err != nil {
+		return RateLimit{}, false, err
+	}
+	limit := q.Limits(quota).UploadsPerMinute
+	if limit <= 0 {
+		return RateLimit{}, true, nil
+	}
+
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	now := time.Now()
+	window, ok := q.windows[keyHash]
+	if !ok || now.Sub(window.start) >= time.Minute {
+		window = &uploadWindow{start: now}
+		q.windows[keyHash] = window
+		q.dropExpiredWindows(now)
+	}
+	state := RateLimit{Limit: limit, Reset: window.start.Add(time.Minute)}
+	if window.count >= limit {
+		return state, false, nil
+	}
+	window.count++
+	state.Remaining = limit - window.count
+	return state, true, nil
+}
+
+func (q *QuotaKeeper) dropExpiredWindows(now time.Time) {
+	for keyHash, window := range q.windows {
+		if now.Sub(window.start) >= time.Minute {
+			delete(q.windows, keyHash)
+		}
+	}
+}
+
+// CheckEgress fails when the key already got its daily gateway egress, the time returned is when the egress starts
+// over.
+func (q *QuotaKeeper) CheckEgress(keyHash string) (time.Time, error) {
+	quota, err := q.Quota(keyHash)
+	if err != nil {
+		return time.Time{}, err
+	}
+	reset := quota.EgressWindowStart.Add(egressWindow)
+	if time.Now().After(reset) {
+		return time.Time{}, nil
+	}
+	limit := q.Limits(quota).EgressBytesPerDay
+	if limit > 0 && quota.EgressBytes >= limit {
+		return reset, ErrQuotaEgress
+	}
+	return reset, nil
+}
+
+// AddEgress counts bytes the gateway served for a key, a new day of egress starts when the last one is over.
+func (q *QuotaKeeper) AddEgress(keyHash string, bytes int64) error {
+	quota, err := q.Quota(keyHash)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	updates := map[string]interface{}{
+		"egress_bytes": gorm.Expr("egress_bytes + ?", bytes),
+		"updated_at":   now,
+	}
+	if now.Sub(quota.EgressWindowStart) >= egressWindow {
+		updates["egress_bytes"] = bytes
+		updates["egress_window_start"] = now
+	}
+	return q.db.Model(&KeyQuota{}).Where("id = ?", quota.ID).UpdateColumns(updates).Error
+}
+
+// SetLimits replaces the limits of a key, nil limits fall back to the config.
+func (q *QuotaKeeper) SetLimits(keyHash string, maxBytes *int64, maxObjects *int64, uploadsPerMinute *int64, egressBytesPerDay *int64) (KeyQuota, error) {
+	quota, err := q.Quota(keyHash)
+	if err != nil {
+		return quota, err
+	}
+	quota.MaxBytes = maxBytes
+	quota.MaxObjects = maxObjects
+	quota.UploadsPerMinute = uploadsPerMinute
+	quota.EgressBytesPerDay = egressBytesPerDay
+	quota.UpdatedAt = time.Now()
+	err = q.db.Model(&KeyQuota{}).Where("id = ?", quota.ID).Select("max_bytes", "max_objects", "uploads_per_minute", "egress_bytes_per_day", "updated_at").Updates(&quota).Error
+	return quota, err
+}
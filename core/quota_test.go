@@ -0,0 +1,155 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/google/uuid"
+)
+
+func TestQuotaBackfillSkipsSplits(t *testing.T) {
+	db := newTestDB(t)
+
+	// a content split by an older node, its splits only know their bucket
+	parent := Content{Name: "large.bin", Size: 100, Cid: "bafy-large", ApiKeyHash: "key"}
+	db.Create(&parent)
+	for i, c := range []string{"bafy-split-0", "bafy-split-1"} {
+		db.Create(&Content{Name: string(rune(i)) + "split-" + c, Size: 50, Cid: c, ApiKeyHash: "key", BucketUuid: SplitBucketUuid(parent.ID)})
+	}
+	db.Create(&Content{Name: "small.txt", Size: 10, Cid: "bafy-small", ApiKeyHash: "key", BucketUuid: "other-bucket"})
+
+	// a content split by the oldest nodes, its splits are in a bucket with a random uuid
+	legacy := Content{Name: "legacy.bin", Size: 80, Cid: "bafy-legacy", ApiKeyHash: "key", CollectionName: "default", CreatedAt: time.Now().Add(-time.Hour)}
+	db.Create(&legacy)
+	db.Create(&Content{Name: "other.bin", Size: 80, Cid: "bafy-other", ApiKeyHash: "key", CollectionName: "default", CreatedAt: time.Now()})
+	legacyBucket := Bucket{Uuid: uuid.NewString(), Name: "default", CreatedAt: time.Now().Add(-time.Minute)}
+	db.Create(&legacyBucket)
+	for i, c := range []string{"bafy-legacy-0", "bafy-legacy-1"} {
+		db.Create(&Content{Name: string(rune(i)) + "split-" + c, Size: 40, Cid: c, ApiKeyHash: "key", CollectionName: "default", BucketUuid: legacyBucket.Uuid})
+	}
+	if err := MigrateSplitParents(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var splits int64
+	db.Model(&Content{}).Where("parent_content_id = ?", parent.ID).Count(&splits)
+	if splits != 2 {
+		t.Fatalf("linked %d splits to their content", splits)
+	}
+	db.Model(&Content{}).Where("parent_content_id = ?", legacy.ID).Count(&splits)
+	if splits != 2 {
+		t.Fatalf("linked %d splits in a random bucket to their content", splits)
+	}
+	var unlinked int64
+	db.Model(&Content{}).Where("bucket_uuid = ? and parent_content_id = 0", "other-bucket").Count(&unlinked)
+	if unlinked != 1 {
+		t.Fatal("linked a content that is not a split")
+	}
+
+	quota, err := NewQuotaKeeper(db, config.EdgeConfig{}).Quota("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota.UsedBytes != 270 || quota.UsedObjects != 4 {
+		t.Fatalf("backfilled %d bytes in %d objects, expected 270 bytes in 4 objects", quota.UsedBytes, quota.UsedObjects)
+	}
+}
+
+func TestCheckStorage(t *testing.T) {
+	db := newTestDB(t)
+	var cfg config.EdgeConfig
+	cfg.Common.CapacityLimitPerKeyInBytes = 100
+	cfg.Quota.MaxObjects = 3
+	quotas := NewQuotaKeeper(db, cfg)
+	if _, err := quotas.Quota("key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := quotas.AddStorage("key", 60, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := quotas.CheckStorage("key", 40); err != nil {
+		t.Fatalf("a content filling the quota was refused: %s", err)
+	}
+	if err := quotas.CheckStorage("key", 41); err != ErrQuotaBytes {
+		t.Fatalf("a content going past the quota got %v", err)
+	}
+	if err := quotas.CheckStorage("key", 0); err != nil {
+		t.Fatalf("a content of unknown size was refused below the quota: %s", err)
+	}
+	quotas.AddStorage("key", 40, 1)
+	if err := quotas.CheckStorage("key", 0); err != ErrQuotaBytes {
+		t.Fatalf("a content of unknown size at the quota got %v", err)
+	}
+
+	maxBytes := int64(0)
+	if _, err := quotas.SetLimits("key", &maxBytes, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := quotas.CheckStorage("key", 1000); err != nil {
+		t.Fatalf("a key without a storage limit got %v", err)
+	}
+	quotas.AddStorage("key", 1, 1)
+	if err := quotas.CheckStorage("key", 1); err != ErrQuotaObjects {
+		t.Fatalf("a key at its object quota got %v", err)
+	}
+}
+
+func TestAllowUpload(t *testing.T) {
+	var cfg config.EdgeConfig
+	cfg.Quota.UploadsPerMinute = 2
+	quotas := NewQuotaKeeper(newTestDB(t), cfg)
+
+	for i := int64(1); i <= 2; i++ {
+		rateLimit, allowed, err := quotas.AllowUpload("key")
+		if err != nil || !allowed || rateLimit.Limit != 2 || rateLimit.Remaining != 2-i {
+			t.Fatalf("upload %d allowed %t with %+v: %v", i, allowed, rateLimit, err)
+		}
+	}
+	rateLimit, allowed, err := quotas.AllowUpload("key")
+	if err != nil || allowed || rateLimit.Remaining != 0 || rateLimit.Reset.Before(time.Now()) {
+		t.Fatalf("upload past the limit allowed %t with %+v: %v", allowed, rateLimit, err)
+	}
+	if _, allowed, _ := quotas.AllowUpload("other-key"); !allowed {
+		t.Fatal("the limit of a key applied to another key")
+	}
+
+	// the window starts over a minute after it started
+	quotas.windows["key"].start = time.Now().Add(-time.Minute)
+	rateLimit, allowed, err = quotas.AllowUpload("key")
+	if err != nil || !allowed || rateLimit.Remaining != 1 || rateLimit.Reset.Before(time.Now().Add(59*time.Second)) {
+		t.Fatalf("upload in a new window allowed %t with %+v: %v", allowed, rateLimit, err)
+	}
+}
+
+func TestEgressWindow(t *testing.T) {
+	db := newTestDB(t)
+	var cfg config.EdgeConfig
+	cfg.Quota.EgressBytesPerDay = 100
+	quotas := NewQuotaKeeper(db, cfg)
+
+	if err := quotas.AddEgress("key", 100); err != nil {
+		t.Fatal(err)
+	}
+	reset, err := quotas.CheckEgress("key")
+	if err != ErrQuotaEgress || reset.Before(time.Now().Add(23*time.Hour)) {
+		t.Fatalf("egress at the quota got %v until %s", err, reset)
+	}
+
+	// a day later the egress starts over
+	db.Model(&KeyQuota{}).Where("api_key_hash = ?", "key").Update("egress_window_start", time.Now().Add(-egressWindow-time.Minute))
+	if _, err := quotas.CheckEgress("key"); err != nil {
+		t.Fatalf("egress of the last day counted: %v", err)
+	}
+	if err := quotas.AddEgress("key", 10); err != nil {
+		t.Fatal(err)
+	}
+	quota, err := quotas.Quota("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota.EgressBytes != 10 || time.Since(quota.EgressWindowStart) > time.Minute {
+		t.Fatalf("egress is %d bytes since %s after the window rolled over", quota.EgressBytes, quota.EgressWindowStart)
+	}
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"time"
+
+	"github.com/application-research/edge-ur/utils"
+	"gorm.io/gorm"
+)
+
+const ReplicaStatusClaimed = "claimed"
+
+// heldReplica matches the replicas of storage providers that still hold, or are about to hold, the piece.
+var heldReplica = "content_replications.status not in ('" + utils.STATUS_DEAL_FAILED + "', '" + utils.STATUS_DEAL_SLASHED + "')"
+
+// FailedDealGrace is how long a failed deal keeps its replica slot when delta retries the failed deals of its policy,
+// the backend makes a new deal for the same content which replaces the failed one.
+const FailedDealGrace = 24 * time.Hour
+
+// liveReplicaCount is the sql counting the replica slots taken for the bucket whose uuid is in the bucket column: the
+// leases that are not confirmed yet, the replicas of the confirmed claims, and the deals that hold the piece or may
+// still get to. Failed deals are counted for FailedDealGrace when the autoRetry expression is true. Its arguments are
+// given by liveReplicaArgs.
+func liveReplicaCount(bucket string, autoRetry string) string {
+	return "((select count(*) from bucket_claims where bucket_claims.bucket_uuid = " + bucket + " and " + leasedClaim + ")" +
+		" + (select count(*) from content_replications where content_replications.bucket_uuid = " + bucket +
+		" and content_replications.content_deal_id = 0 and " + heldReplica + ")" +
+		" + (select count(*) from content_deals where content_deals.bucket_uuid = " + bucket +
+		" and content_deals.slashed = ? and (content_deals.failed = ? or (" + autoRetry + " and content_deals.failed_at > ?))))"
+}
+
+func liveReplicaArgs(now time.Time, autoRetry interface{}) []interface{} {
+	return []interface{}{now, false, false, autoRetry, now.Add(-FailedDealGrace)}
+}
+
+// LiveReplicas counts the replica slots of a bucket that are taken, by storage providers that claimed the bucket or
+// by deals. Deal makers and bucket claims both go through it so together they never go past the replication factor.
+func LiveReplicas(db *gorm.DB, bucketUuid string, autoRetry bool, now time.Time) int64 {
+	var replicas int64
+	db.Model(&Bucket{}).Select(liveReplicaCount("buckets.uuid", "?"), liveReplicaArgs(now, autoRetry)...).
+		Where("buckets.uuid = ?", bucketUuid).Scan(&replicas)
+	return replicas
+}
+
+// OfferedBucketStatuses are the statuses of the buckets that have a piece, a bucket in one of them is offered to
+// storage providers until it has as many replicas as its policy asks for.
+var OfferedBucketStatuses = []string{
+	"ready",
+	utils.STATUS_DEAL_PROPOSED,
+	utils.STATUS_DEAL_ON_CHAIN,
+	utils.STATUS_DEAL_SEALED,
+	utils.STATUS_DEAL_FAILED,
+	utils.STATUS_DEAL_SLASHED,
+}
+
+// ReplicationStatus is how far the piece of a bucket is replicated.
+type ReplicationStatus struct {
+	ReplicationFactor int                  `json:"replication_factor"`
+	ReplicaCount      int64                `json:"replica_count"`
+	Replicas          []ContentReplication `json:"replicas"`
+}
+
+// RecordReplica creates or updates the replica of a storage provider. Replicas of a deal follow the deal, even when
+// delta moved it to another storage provider, other replicas are unique per bucket and miner.
+func RecordReplica(tx *gorm.DB, replica ContentReplication) error {
+	var existing ContentReplication
+	query := tx.Model(&ContentReplication{})
+	if replica.ContentDealID != 0 {
+		query = query.Where("content_deal_id = ?", replica.ContentDealID)
+	} else {
+		query = query.Where("bucket_uuid = ? and miner = ? and content_deal_id = 0", replica.BucketUuid, replica.Miner)
+	}
+	if err := query.Limit(1).Find(&existing).Error; err != nil {
+		return err
+	}
+
+	replica.UpdatedAt = time.Now()
+	if existing.ID == 0 {
+		replica.CreatedAt = replica.UpdatedAt
+		return tx.Create(&replica).Error
+	}
+	return tx.Model(&ContentReplication{}).Where("id = ?", existing.ID).Updates(map[string]interface{}{
+		"miner":                    replica.Miner,
+		"piece_cid":                replica.PieceCid,
+		"status":                   replica.Status,
+		"replica_delta_content_id": replica.ReplicaDeltaContentID,
+		"updated_at":               replica.UpdatedAt,
+	}).Error
+}
+
+// BucketReplication returns the replicas of a bucket against the replication factor of its policy.
+func (ln *LightNode) BucketReplication(bucket Bucket) ReplicationStatus {
+	var policy Policy
+	ln.DB.Model(&Policy{}).Where("id = ?", bucket.PolicyId).Find(&policy)
+
+	status := ReplicationStatus{ReplicationFactor: policy.Replicas()}
+	ln.DB.Model(&ContentReplication{}).Where("bucket_uuid = ?", bucket.Uuid).Order("id asc").Find(&status.Replicas)
+	for _, replica := range status.Replicas {
+		if replica.Status != utils.STATUS_DEAL_FAILED && replica.Status != utils.STATUS_DEAL_SLASHED {
+			status.ReplicaCount++
+		}
+	}
+	return status
+}
+
+// FillReplicaCounts sets the number of storage providers holding the piece of the bucket of each content.
+func (ln *LightNode) FillReplicaCounts(contents []Content) {
+	var bucketUuids []string
+	for _, content := range contents {
+		if content.BucketUuid != "" {
+			bucketUuids = append(bucketUuids, content.BucketUuid)
+		}
+	}
+	if len(bucketUuids) == 0 {
+		return
+	}
+
+	var counts []struct {
+		BucketUuid string
+		Replicas   int64
+	}
+	ln.DB.Model(&ContentReplication{}).
+		Select("bucket_uuid, count(*) as replicas").
+		Where("bucket_uuid in ? and "+heldReplica, bucketUuids).
+		Group("bucket_uuid").
+		Scan(&counts)
+
+	replicas := make(map[string]int64)
+	for _, count := range counts {
+		replicas[count.BucketUuid] = count.Replicas
+	}
+	for i := range contents {
+		contents[i].Replicas = replicas[contents[i].BucketUuid]
+	}
+}
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/pem"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/application-research/edge-ur/utils"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSignedUrlInvalid = xerrors.New("the signed url is invalid")
+	ErrSignedUrlExpired = xerrors.New("the signed url is expired")
+	ErrSignedUrlRevoked = xerrors.New("the signed url is revoked")
+)
+
+// SignedUrlMessage is what the node signs for a signed gateway url. The id of the ContentSignatureMeta is part of it
+// so a signature can't be moved to another issued url.
+type SignedUrlMessage struct {
+	Id                  int64     `json:"id"`
+	Message             string    `json:"message"`
+	CurrentTimestamp    time.Time `json:"current_timestamp"`
+	ExpirationTimestamp time.Time `json:"expiration_timestamp"`
+	EdgeContentId       int64     `json:"edge_content_id"`
+}
+
+// UrlSigner issues and verifies the signed gateway urls of contents with the ECDSA key of the node.
+type UrlSigner struct {
+	key          *ecdsa.PrivateKey
+	publicKeyPEM string
+}
+
+// NewUrlSigner loads the signing key from its pem file, a new P-521 key is created when the file does not exist yet.
+func NewUrlSigner(keyPath string) (*UrlSigner, error) {
+	key, err := loadSigningKey(keyPath)
+	if os.IsNotExist(err) {
+		key, err = createSigningKey(keyPath)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("failed to load the url signing key %s: %w", keyPath, err)
+	}
+	publicKeyPEM, err := utils.PublicKeyPEM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &UrlSigner{key: key, publicKeyPEM: publicKeyPEM}, nil
+}
+
+// urlSigningKeyPath returns the pem file of the url signing key, in the repo unless configured otherwise.
+func urlSigningKeyPath(configured string, repo string) string {
+	if configured != "" {
+		return configured
+	}
+	return filepath.Join(repo, "url-signing-key.pem")
+}
+
+func loadSigningKey(keyPath string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, xerrors.New("no pem block")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+func createSigningKey(keyPath string) (*ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Issue records a signed url for the content that expires at expiration, baseUrl is the public url of the node.
+func (s *UrlSigner) Issue(db *gorm.DB, content Content, keyHash string, expiration time.Time, message string, baseUrl string) (ContentSignatureMeta, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	meta := ContentSignatureMeta{
+		ContentId:           content.ID,
+		ApiKeyHash:          keyHash,
+		CurrentTimestamp:    now,
+		ExpirationTimestamp: expiration.UTC().Truncate(time.Second),
+		Message:             message,
+		CreatedAt:           time.Now(),
+		UpdatedAt:           time.Now(),
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&meta).Error; err != nil {
+			return err
+		}
+		signature, err := utils.SignEcdsaSha512(signedUrlMessage(meta), s.key)
+		if err != nil {
+			return err
+		}
+		meta.Signature = signature
+		query := url.Values{}
+		query.Set("sid", strconv.FormatInt(meta.ID, 10))
+		query.Set("expires", strconv.FormatInt(meta.ExpirationTimestamp.Unix(), 10))
+		query.Set("sig", signature)
+		meta.SignedUrl = baseUrl + "/gw/content/" + strconv.FormatInt(content.ID, 10) + "?" + query.Encode()
+		return tx.Model(&ContentSignatureMeta{}).Where("id = ?", meta.ID).Updates(map[string]interface{}{
+			"signature":  meta.Signature,
+			"signed_url": meta.SignedUrl,
+		}).Error
+	})
+	return meta, err
+}
+
+// Verify checks the signed url query of a gateway request for a content: the url has to be issued for the content,
+// carry the signature of the node, and be neither expired nor revoked at now.
+func (s *UrlSigner) Verify(db *gorm.DB, contentId string, query url.Values, now time.Time) (ContentSignatureMeta, error) {
+	var meta ContentSignatureMeta
+	db.Model(&ContentSignatureMeta{}).Where("id = ?", query.Get("sid")).Find(&meta)
+	if meta.ID == 0 || strconv.FormatInt(meta.ContentId, 10) != contentId {
+		return meta, ErrSignedUrlInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(meta.Signature), []byte(query.Get("sig"))) != 1 {
+		return meta, ErrSignedUrlInvalid
+	}
+	valid, err := utils.VerifyEcdsaSha512Signature(signedUrlMessage(meta), meta.Signature, s.publicKeyPEM)
+	if err != nil || !valid {
+		return meta, ErrSignedUrlInvalid
+	}
+	if meta.RevokedAt != nil {
+		return meta, ErrSignedUrlRevoked
+	}
+	if now.Before(meta.CurrentTimestamp) || !now.Before(meta.ExpirationTimestamp) {
+		return meta, ErrSignedUrlExpired
+	}
+	return meta, nil
+}
+
+// RevokeSignedUrl makes a signed url unusable before it expires.
+func RevokeSignedUrl(db *gorm.DB, meta *ContentSignatureMeta) error {
+	now := time.Now()
+	meta.RevokedAt = &now
+	return db.Model(&ContentSignatureMeta{}).Where("id = ?", meta.ID).Updates(map[string]interface{}{
+		"revoked_at": now,
+		"updated_at": now,
+	}).Error
+}
+
+// signedUrlMessage is the message of a signed url, the timestamps are normalized so the message signed when the url
+// is issued is the one verified after a round trip through the database.
+func signedUrlMessage(meta ContentSignatureMeta) SignedUrlMessage {
+	return SignedUrlMessage{
+		Id:                  meta.ID,
+		Message:             meta.Message,
+		CurrentTimestamp:    meta.CurrentTimestamp.UTC().Truncate(time.Second),
+		ExpirationTimestamp: meta.ExpirationTimestamp.UTC().Truncate(time.Second),
+		EdgeContentId:       meta.ContentId,
+	}
+}
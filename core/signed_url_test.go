@@ -0,0 +1,107 @@
+package core
+
+import (
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func issueTestUrl(t *testing.T, db *gorm.DB, signer *UrlSigner, content Content, expiration time.Time) (ContentSignatureMeta, url.Values) {
+	t.Helper()
+	meta, err := signer.Issue(db, content, "key", expiration, "shared with bob", "http://edge.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedUrl, err := url.Parse(meta.SignedUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signedUrl.Path != "/gw/content/"+strconv.FormatInt(content.ID, 10) {
+		t.Fatalf("signed url is %s", meta.SignedUrl)
+	}
+	return meta, signedUrl.Query()
+}
+
+func TestSignedUrlVerify(t *testing.T) {
+	db := newTestDB(t)
+	keyPath := filepath.Join(t.TempDir(), "url-signing-key.pem")
+	signer, err := NewUrlSigner(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := Content{Name: "a", Cid: "bafy-a"}
+	b := Content{Name: "b", Cid: "bafy-b"}
+	db.Create(&a)
+	db.Create(&b)
+
+	// an expiry off the second and in another zone than the node signs in
+	expiration := time.Now().In(time.FixedZone("UTC+5", 5*3600)).Add(time.Hour + 123*time.Millisecond)
+	metaA, queryA := issueTestUrl(t, db, signer, a, expiration)
+	_, queryB := issueTestUrl(t, db, signer, b, expiration)
+	idA, idB := strconv.FormatInt(a.ID, 10), strconv.FormatInt(b.ID, 10)
+	now := time.Now()
+
+	// the signer loads the same key again, the timestamps read back from the database verify
+	reloaded, err := NewUrlSigner(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := reloaded.Verify(db, idA, queryA, now)
+	if err != nil || meta.ID != metaA.ID {
+		t.Fatalf("the signed url did not verify: %v", err)
+	}
+
+	withParam := func(query url.Values, key string, value string) url.Values {
+		changed := url.Values{}
+		for k, v := range query {
+			changed[k] = v
+		}
+		changed.Set(key, value)
+		return changed
+	}
+	cases := []struct {
+		name      string
+		contentId string
+		query     url.Values
+		now       time.Time
+		err       error
+	}{
+		{"signature of another url", idA, withParam(queryA, "sig", queryB.Get("sig")), now, ErrSignedUrlInvalid},
+		{"tampered signature", idA, withParam(queryA, "sig", queryA.Get("sig")[1:]), now, ErrSignedUrlInvalid},
+		{"no signature", idA, withParam(queryA, "sig", ""), now, ErrSignedUrlInvalid},
+		{"sid of another url", idA, withParam(queryA, "sid", queryB.Get("sid")), now, ErrSignedUrlInvalid},
+		{"unknown sid", idA, withParam(queryA, "sid", "1000"), now, ErrSignedUrlInvalid},
+		{"another content", idB, queryA, now, ErrSignedUrlInvalid},
+		{"url of another content", idA, queryB, now, ErrSignedUrlInvalid},
+		{"expired", idA, queryA, expiration.Add(time.Second), ErrSignedUrlExpired},
+		{"at expiry", idA, queryA, expiration.Truncate(time.Second), ErrSignedUrlExpired},
+		{"before issue", idA, queryA, metaA.CurrentTimestamp.Add(-time.Second), ErrSignedUrlExpired},
+		{"before expiry", idA, queryA, expiration.Add(-time.Second), nil},
+	}
+	for _, tc := range cases {
+		if _, err := signer.Verify(db, tc.contentId, tc.query, tc.now); err != tc.err {
+			t.Fatalf("%s: got %v, expected %v", tc.name, err, tc.err)
+		}
+	}
+
+	// the signature only verifies the message it was made for
+	db.Model(&ContentSignatureMeta{}).Where("id = ?", metaA.ID).Update("message", "shared with eve")
+	if _, err := signer.Verify(db, idA, queryA, now); err != ErrSignedUrlInvalid {
+		t.Fatalf("a changed message got %v", err)
+	}
+	db.Model(&ContentSignatureMeta{}).Where("id = ?", metaA.ID).Update("message", "shared with bob")
+
+	if err := RevokeSignedUrl(db, &metaA); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signer.Verify(db, idA, queryA, now); err != ErrSignedUrlRevoked {
+		t.Fatalf("a revoked url got %v", err)
+	}
+	if _, err := signer.Verify(db, idB, queryB, now); err != nil {
+		t.Fatalf("revoking a url revoked another: %v", err)
+	}
+}
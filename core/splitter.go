@@ -2,11 +2,16 @@ package core
 
 // function to split a file into chunks
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/ipfs/boxo/ipld/merkledag"
+	"gorm.io/gorm"
 	"io"
 	"os"
+	"strconv"
+	"strings"
 )
 
 var defaultChuckSize int64 = 1024 * 1024
@@ -32,6 +37,84 @@ type SplitChunk struct {
 	Index int
 }
 
+// SplitBucketUuid returns the uuid of the bucket holding the splits of a content. It is derived from the content so a
+// rerun of the splitter picks up the same bucket.
+func SplitBucketUuid(contentId int64) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte("split-"+strconv.FormatInt(contentId, 10))).String()
+}
+
+// MigrateSplitParents links the splits made by older nodes, which only named them after their cid, to the content they
+// were cut from. Contents that were split are not in a bucket. The splits are found in the bucket of their content, or
+// for the oldest nodes, which put them in a bucket with a random uuid, in a bucket holding only splits whose sizes add
+// up to the size of a content of the same collection and key, without splits yet, made before the bucket.
+func MigrateSplitParents(db *gorm.DB) error {
+	var parents []int64
+	if err := db.Model(&Content{}).Where("bucket_uuid = ''").Pluck("id", &parents).Error; err != nil {
+		return err
+	}
+	for _, parent := range parents {
+		err := db.Model(&Content{}).Where("bucket_uuid = ? and parent_content_id = 0", SplitBucketUuid(parent)).
+			UpdateColumn("parent_content_id", parent).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	// the name of a split starts with its index as a rune, which can be a NUL the database doesn't compare, so the
+	// splits are picked out here
+	var contents []Content
+	err := db.Model(&Content{}).Select("id, name, cid, bucket_uuid, collection_name, api_key_hash, size").
+		Where("bucket_uuid <> '' and parent_content_id = 0").Find(&contents).Error
+	if err != nil {
+		return err
+	}
+	type splitBucket struct {
+		BucketUuid     string
+		CollectionName string
+		ApiKeyHash     string
+		Size           int64
+	}
+	var splitBuckets []*splitBucket
+	byUuid := map[string]*splitBucket{}
+	notSplits := map[string]bool{}
+	for _, content := range contents {
+		if !strings.HasSuffix(content.Name, "split-"+content.Cid) {
+			notSplits[content.BucketUuid] = true
+			continue
+		}
+		sb, ok := byUuid[content.BucketUuid]
+		if !ok {
+			sb = &splitBucket{BucketUuid: content.BucketUuid, CollectionName: content.CollectionName, ApiKeyHash: content.ApiKeyHash}
+			byUuid[content.BucketUuid] = sb
+			splitBuckets = append(splitBuckets, sb)
+		}
+		sb.Size += content.Size
+	}
+	for _, sb := range splitBuckets {
+		if notSplits[sb.BucketUuid] {
+			continue
+		}
+		linked := db.Model(&Content{}).Select("parent_content_id").Where("parent_content_id <> 0")
+		parentQuery := db.Model(&Content{}).
+			Where("bucket_uuid = '' and collection_name = ? and api_key_hash = ? and size = ?", sb.CollectionName, sb.ApiKeyHash, sb.Size).
+			Where("id not in (?)", linked)
+		var bucket Bucket
+		if db.Model(&Bucket{}).Where("uuid = ?", sb.BucketUuid).First(&bucket).Error == nil {
+			parentQuery = parentQuery.Where("created_at <= ?", bucket.CreatedAt)
+		}
+		var parent Content
+		if parentQuery.Order("created_at desc").First(&parent).Error != nil {
+			continue
+		}
+		err := db.Model(&Content{}).Where("bucket_uuid = ? and parent_content_id = 0", sb.BucketUuid).
+			UpdateColumn("parent_content_id", parent.ID).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func NewFileSplitter(param SplitterParam) FileSplitter {
 	if param.ChuckSize == 0 {
 		param.ChuckSize = defaultChuckSize
@@ -41,50 +124,62 @@ func NewFileSplitter(param SplitterParam) FileSplitter {
 	}
 }
 
-func (c FileSplitter) SplitFileFromReaderIntoBlockstore(fileFromReader io.Reader) ([]SplitChunk, error) {
-	// Read the file into a buffer
-	buf := make([]byte, c.ChuckSize)
-	//var chunks [][]byte
-	var splitChunks []SplitChunk
-	var i = 0
-	for {
-
-		n, err := fileFromReader.Read(buf)
-		if n == 0 {
-			break
+// SplitReader cuts the data of the reader into chunks of ChuckSize bytes, only the last one may be shorter, and hands
+// them to fn one at a time so the file is never held in memory. Short reads don't cut a chunk short, and a chunk fn
+// doesn't read to its end is skipped over.
+func (c FileSplitter) SplitReader(fileFromReader io.Reader, fn func(index int, chunk io.Reader) error) error {
+	chunkSize := c.ChuckSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChuckSize
+	}
+	br := bufio.NewReader(fileFromReader)
+	for i := 0; ; i++ {
+		if _, err := br.Peek(1); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Error reading file: %v", err)
 		}
-		if err != nil {
-			return nil, fmt.Errorf("Error reading file: %v", err)
+		chunk := io.LimitReader(br, chunkSize)
+		if err := fn(i, chunk); err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, chunk); err != nil {
+			return fmt.Errorf("Error reading file: %v", err)
 		}
+	}
+}
+
+// readChunks reads the whole reader in chunks of ChuckSize bytes, every chunk in its own buffer.
+func (c FileSplitter) readChunks(fileFromReader io.Reader) ([][]byte, error) {
+	var chunks [][]byte
+	err := c.SplitReader(fileFromReader, func(index int, chunk io.Reader) error {
+		b, err := io.ReadAll(chunk)
+		chunks = append(chunks, b)
+		return err
+	})
+	return chunks, err
+}
 
-		rawNode := merkledag.NewRawNode(buf[:n])
+func (c FileSplitter) SplitFileFromReaderIntoBlockstore(fileFromReader io.Reader) ([]SplitChunk, error) {
+	chunks, err := c.readChunks(fileFromReader)
+	if err != nil {
+		return nil, err
+	}
+	var splitChunks []SplitChunk
+	for i, chunk := range chunks {
+		rawNode := merkledag.NewRawNode(chunk)
 		c.LightNode.Node.Add(context.Background(), rawNode)
 		splitChunks = append(splitChunks, SplitChunk{
-			//Chunk: buf[:n],
 			Index: i,
-			Size:  n,
+			Size:  len(chunk),
 			Cid:   rawNode.Cid().String(),
 		})
-		i++
 	}
 	return splitChunks, nil
 }
-func (c FileSplitter) SplitFileFromReader(fileFromReader io.Reader) ([][]byte, error) {
 
-	// Read the file into a buffer
-	buf := make([]byte, c.ChuckSize)
-	var chunks [][]byte
-	for {
-		n, err := fileFromReader.Read(buf)
-		if n == 0 {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("Error reading file: %v", err)
-		}
-		chunks = append(chunks, buf[:n])
-	}
-	return chunks, nil
+func (c FileSplitter) SplitFileFromReader(fileFromReader io.Reader) ([][]byte, error) {
+	return c.readChunks(fileFromReader)
 }
 
 func (c FileSplitter) SplitFile(filePath string) ([][]byte, error) {
@@ -93,19 +188,5 @@ func (c FileSplitter) SplitFile(filePath string) ([][]byte, error) {
 		return nil, fmt.Errorf("Error opening file: %v", err)
 	}
 	defer file.Close()
-
-	// Read the file into a buffer
-	buf := make([]byte, c.ChuckSize)
-	var chunks [][]byte
-	for {
-		n, err := file.Read(buf)
-		if n == 0 {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("Error reading file: %v", err)
-		}
-		chunks = append(chunks, buf[:n])
-	}
-	return chunks, nil
+	return c.readChunks(file)
 }
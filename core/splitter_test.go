@@ -0,0 +1,70 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSplitReaderReassembles(t *testing.T) {
+	data := make([]byte, 10*1000+123)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	splitter := NewFileSplitter(SplitterParam{ChuckSize: 1000})
+
+	// the reader hands out a few bytes at a time like a dag reader does, the chunks still have the split size
+	var chunks [][]byte
+	err := splitter.SplitReader(iotest.HalfReader(bytes.NewReader(data)), func(index int, chunk io.Reader) error {
+		if index != len(chunks) {
+			t.Fatalf("chunk %d handed out as %d", len(chunks), index)
+		}
+		b, err := io.ReadAll(chunk)
+		chunks = append(chunks, b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 11 {
+		t.Fatalf("file split into %d chunks, expected 11", len(chunks))
+	}
+	for i, chunk := range chunks[:len(chunks)-1] {
+		if len(chunk) != 1000 {
+			t.Fatalf("chunk %d has %d bytes, expected 1000", i, len(chunk))
+		}
+	}
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("the chunks don't reassemble into the file")
+	}
+
+	// every chunk has its own buffer
+	chunks, err = splitter.SplitFileFromReader(iotest.OneByteReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 11 || !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatalf("file split into %d chunks that don't reassemble into the file", len(chunks))
+	}
+}
+
+func TestSplitReaderSkipsUnreadChunk(t *testing.T) {
+	data := bytes.Repeat([]byte("abcd"), 5)
+	var firsts []byte
+	err := NewFileSplitter(SplitterParam{ChuckSize: 4}).SplitReader(bytes.NewReader(data), func(index int, chunk io.Reader) error {
+		b := make([]byte, 1)
+		if _, err := io.ReadFull(chunk, b); err != nil {
+			return err
+		}
+		firsts = append(firsts, b[0])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(firsts) != "aaaaa" {
+		t.Fatalf("chunks start with %q, expected every chunk to start at a multiple of the split size", firsts)
+	}
+}
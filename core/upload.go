@@ -0,0 +1,44 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	UploadStatusUploading  = "uploading"
+	UploadStatusProcessing = "processing"
+	UploadStatusCompleted  = "completed"
+	UploadStatusFailed     = "failed"
+)
+
+// UploadPath returns where the data of a resumable upload is staged.
+func (ln *LightNode) UploadPath(uploadUuid string) string {
+	return filepath.Join(ln.Config.Node.StagingDir, "uploads", "resumable", uploadUuid)
+}
+
+// ExpireUploads removes the unfinished uploads that did not receive data before their expiry, along with their
+// staged data, and returns how many were removed.
+func (ln *LightNode) ExpireUploads(now time.Time) (int, error) {
+	var uploads []Upload
+	if err := ln.DB.Model(&Upload{}).Where("status = ? and expires_at <= ?", UploadStatusUploading, now).Find(&uploads).Error; err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, upload := range uploads {
+		result := ln.DB.Where("id = ? and status = ? and expires_at <= ?", upload.ID, UploadStatusUploading, now).Delete(&Upload{})
+		if result.Error != nil {
+			return expired, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue // data arrived in the meantime
+		}
+		if err := os.Remove(ln.UploadPath(upload.Uuid)); err != nil && !os.IsNotExist(err) {
+			log.Warnf("failed to remove the staged data of upload %s: %s", upload.Uuid, err)
+		}
+		expired++
+	}
+	return expired, nil
+}
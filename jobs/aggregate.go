@@ -1,6 +1,7 @@
 package jobs
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -15,7 +16,12 @@ type BucketAggregator struct {
 	Processor
 }
 
-func NewBucketAggregator(ln *core.LightNode, bucket *core.Bucket) IProcessor {
+// BucketJobPayload is the job queue payload of the processors that work on a single bucket.
+type BucketJobPayload struct {
+	BucketUuid string `json:"bucket_uuid"`
+}
+
+func NewBucketAggregator(ln *core.LightNode, bucket *core.Bucket) IQueuedProcessor {
 	return &BucketAggregator{
 		Bucket: bucket,
 		Processor: Processor{
@@ -24,13 +30,41 @@ func NewBucketAggregator(ln *core.LightNode, bucket *core.Bucket) IProcessor {
 	}
 }
 
+// newBucketAggregatorFromPayload loads the bucket when the job runs, so the aggregator sees its latest size and status.
+func newBucketAggregatorFromPayload(ln *core.LightNode, payload []byte) (IProcessor, error) {
+	var p BucketJobPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	var bucket core.Bucket
+	if err := ln.DB.Model(&core.Bucket{}).Where("uuid = ?", p.BucketUuid).First(&bucket).Error; err != nil {
+		return nil, err
+	}
+	return NewBucketAggregator(ln, &bucket), nil
+}
+
 func (r *BucketAggregator) Info() error {
 	panic("implement me")
 }
 
+func (r *BucketAggregator) Type() string {
+	return JobTypeBucketAggregator
+}
+
+func (r *BucketAggregator) Key() string {
+	return r.Bucket.Uuid
+}
+
+func (r *BucketAggregator) Payload() interface{} {
+	return BucketJobPayload{BucketUuid: r.Bucket.Uuid}
+}
+
 // Run is the main function of the BucketAggregator struct. It is responsible for aggregating the contents of a bucket
 func (r *BucketAggregator) Run() error {
 	// check if there are open bucket. if there are, generate the car file for the bucket.
+	if r.Bucket.Status != "open" {
+		return nil // already picked up by an earlier run
+	}
 
 	//var buckets []core.Bucket
 	//r.LightNode.DB.Model(&core.Bucket{}).Where("status = ?", "open").Find(&buckets)
@@ -50,33 +84,38 @@ func (r *BucketAggregator) Run() error {
 	var policy core.Policy
 	r.LightNode.DB.Model(&core.Policy{}).Where("id = ?", r.Bucket.PolicyId).Find(&policy)
 
-	// if policy is not set, create a default policy
+	// buckets created without a policy follow the policy of their collection
 	if policy.ID == 0 {
-		// create a default policy
-		newPolicy := core.Policy{
-			Name:       r.Bucket.Name,
-			BucketSize: r.LightNode.Config.Common.BucketAggregateSize,
-			SplitSize:  r.LightNode.Config.Common.SplitSize,
-			CreatedAt:  time.Now(),
-			UpdatedAt:  time.Now(),
+		collectionPolicy, err := r.LightNode.PolicyForCollection(r.Bucket.Name)
+		if err != nil {
+			return err
 		}
-		r.LightNode.DB.Save(&newPolicy)
-		r.Bucket.PolicyId = newPolicy.ID
-		policy = newPolicy
+		r.Bucket.PolicyId = collectionPolicy.ID
+		r.LightNode.DB.Model(&core.Bucket{}).Where("id = ?", r.Bucket.ID).Update("policy_id", collectionPolicy.ID)
+		policy = collectionPolicy
 	}
 
-	// check if total size is greater than the bucket size
+	// seal the bucket once it is full or too old
 	fmt.Println("Total size: ", totalSize, " Policy Bucket size: ", policy.BucketSize)
-	if totalSize > policy.BucketSize {
+	if policy.ShouldSeal(*r.Bucket, totalSize, time.Now()) {
 		fmt.Println("Generating car file for bucket: ", r.Bucket.Uuid)
+		// only flip the status, the allocator may still be adding to the size of the bucket.
+		res := r.LightNode.DB.Model(&core.Bucket{}).Where("id = ? and status = ?", r.Bucket.ID, "open").Updates(map[string]interface{}{
+			"status":     "processing",
+			"updated_at": time.Now(),
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil // sealed by another run
+		}
 		r.Bucket.Status = "processing"
-		r.LightNode.DB.Save(&r.Bucket)
 
 		// process the car generator
-		job := CreateNewDispatcher()
-		genCar := NewBucketCarGenerator(r.LightNode, *r.Bucket)
-		job.AddJob(genCar)
-		job.Start(1)
+		if err := Enqueue(r.LightNode, NewBucketCarGenerator(r.LightNode, *r.Bucket)); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -1,8 +1,9 @@
 package jobs
 
 import (
-	"bytes"
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/application-research/edge-ur/core"
 	commcid "github.com/filecoin-project/go-fil-commcid"
@@ -15,6 +16,8 @@ import (
 	uio "github.com/ipfs/go-unixfs/io"
 	"github.com/ipld/go-car"
 	"io"
+	"os"
+	"path/filepath"
 )
 
 // The log constant is a logging.Logger that is used to log messages for the jobs package.
@@ -23,6 +26,10 @@ var log = logging.Logger("jobs")
 // The maxTraversalLinks constant is an int that represents the maximum number of traversal links.
 const maxTraversalLinks = 32 * (1 << 20)
 
+// The carBufSize constant is the write buffer used when streaming a car into commP, a multiple of the 127 byte
+// fr32 unpadded chunk.
+const carBufSize = (4 << 20) / 128 * 127
+
 // The BucketCarGenerator type has a Bucket field and implements the Processor interface.
 // @property Bucket - The `Bucket` property is a field of type `core.Bucket`. It is likely used to store or retrieve data
 // related to cars, such as their make, model, year, and other attributes. The `BucketCarGenerator` struct likely
@@ -40,6 +47,9 @@ func (g BucketCarGenerator) Info() error {
 // The Run method of the BucketCarGenerator struct takes no parameters and returns an error. It is used to run the
 // BucketCarGenerator struct.
 func (g BucketCarGenerator) Run() error {
+	if g.Bucket.Status != "processing" {
+		return nil // already generated by an earlier run
+	}
 	if err := g.GenerateCarForBucket(g.Bucket.Uuid); err != nil {
 		log.Errorf("error generating car for bucket: %s", err)
 		return err
@@ -49,7 +59,7 @@ func (g BucketCarGenerator) Run() error {
 
 // NewBucketCarGenerator is a function that takes a LightNode and a bucketToProcess as parameters and returns a
 // BucketCarGenerator struct. It is used to create a new BucketCarGenerator struct.
-func NewBucketCarGenerator(ln *core.LightNode, bucketToProcess core.Bucket) IProcessor {
+func NewBucketCarGenerator(ln *core.LightNode, bucketToProcess core.Bucket) IQueuedProcessor {
 	return &BucketCarGenerator{
 		bucketToProcess,
 		Processor{
@@ -58,6 +68,31 @@ func NewBucketCarGenerator(ln *core.LightNode, bucketToProcess core.Bucket) IPro
 	}
 }
 
+// newBucketCarGeneratorFromPayload loads the bucket of a queued car generator job.
+func newBucketCarGeneratorFromPayload(ln *core.LightNode, payload []byte) (IProcessor, error) {
+	var p BucketJobPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	var bucket core.Bucket
+	if err := ln.DB.Model(&core.Bucket{}).Where("uuid = ?", p.BucketUuid).First(&bucket).Error; err != nil {
+		return nil, err
+	}
+	return NewBucketCarGenerator(ln, bucket), nil
+}
+
+func (g BucketCarGenerator) Type() string {
+	return JobTypeBucketCarGenerator
+}
+
+func (g BucketCarGenerator) Key() string {
+	return g.Bucket.Uuid
+}
+
+func (g BucketCarGenerator) Payload() interface{} {
+	return BucketJobPayload{BucketUuid: g.Bucket.Uuid}
+}
+
 // GenerateCarForBucket is a method of the BucketCarGenerator struct. It takes a bucketUuid string as a parameter and
 // returns nothing. It is used to generate a car with aggregated contents for a bucket
 func (r *BucketCarGenerator) GenerateCarForBucket(bucketUuid string) error {
@@ -72,7 +107,6 @@ func (r *BucketCarGenerator) GenerateCarForBucket(bucketUuid string) error {
 	// for each content, generate a node and a raw
 	dir := uio.NewDirectory(r.LightNode.Node.DAGService)
 	dir.SetCidBuilder(GetCidBuilderDefault())
-	buf := new(bytes.Buffer)
 	for _, cAgg := range updateContentsForAgg {
 		fmt.Println("aggregating file: ", cAgg.Cid, bucketUuid)
 		cCidAgg, err := cid.Decode(cAgg.Cid)
@@ -86,12 +120,6 @@ func (r *BucketCarGenerator) GenerateCarForBucket(bucketUuid string) error {
 			return errCData
 		}
 
-		_, err = io.Copy(buf, bytes.NewReader(cDataAgg.RawData()))
-		if err != nil {
-			panic(err)
-		}
-
-		//aggReaders = append(aggReaders, cDataAgg)
 		dir.AddChild(context.Background(), cAgg.Cid, cDataAgg)
 	}
 	dirNode, err := dir.GetNode()
@@ -99,74 +127,141 @@ func (r *BucketCarGenerator) GenerateCarForBucket(bucketUuid string) error {
 		log.Errorf("error getting directory node: %s", err)
 		return err
 	}
-	r.LightNode.Node.Blockstore.Put(context.Background(), dirNode)
+	err = r.LightNode.Node.Blockstore.Put(context.Background(), dirNode)
 	if err != nil {
 		log.Errorf("error adding file: %s", err)
 		return err
 	}
 
-	pieceCid, carSize, unpaddedPieceSize, bufFile, err := GeneratePieceCommitment(context.Background(), dirNode.Cid(), r.LightNode.Node.Blockstore)
-	bufFileN, err := r.LightNode.Node.AddPinFile(context.Background(), &bufFile, nil)
+	var policy core.Policy
+	r.LightNode.DB.Model(&core.Policy{}).Where("id = ?", bucket.PolicyId).Find(&policy)
 
+	// write the piece to the staging dir and compute the piece commitment while at it.
+	carPath := BucketCarPath(r.LightNode, bucketUuid)
+	var pieceCid cid.Cid
+	var carSize uint64
+	var pieceSize abi.PaddedPieceSize
+	if r.LightNode.Config.Common.DataSegmentAggregation {
+		pieceCid, carSize, pieceSize, err = r.generateDataSegmentAggregate(bucketUuid, updateContentsForAgg, policy, carPath)
+	} else {
+		pieceCid, carSize, pieceSize, err = r.generateBucketCar(bucketUuid, dirNode.Cid(), policy, carPath)
+	}
 	if err != nil {
 		log.Errorf("error generating piece commitment: %s", err)
+		bucket.LastMessage = err.Error()
+		r.LightNode.DB.Save(&bucket)
+		return err
 	}
+
+	// the piece is served from the staged car, the blocks in it are already in the blockstore
 	bucket.PieceCid = pieceCid.String()
-	bucket.PieceSize = int64(unpaddedPieceSize.Padded())
+	bucket.PieceSize = int64(pieceSize)
 	bucket.DirCid = dirNode.Cid().String()
 	bucket.Size = int64(carSize)
-	bucket.Cid = bufFileN.Cid().String()
+	bucket.Cid = dirNode.Cid().String()
 	bucket.Status = "ready"
 	r.LightNode.DB.Save(&bucket)
 
+	if r.LightNode.Config.Delta.Enabled {
+		return Enqueue(r.LightNode, NewDealMakerProcessor(r.LightNode, bucket))
+	}
 	return nil
 }
 
-func GeneratePieceCommitment(ctx context.Context, payloadCid cid.Cid, bstore blockstore.Blockstore) (cid.Cid, uint64, abi.UnpaddedPieceSize, bytes.Buffer, error) {
+// generateBucketCar writes the car of the bucket directory, padding its piece up to the target piece size of the
+// policy. The storage provider pads the car with zeros.
+func (r *BucketCarGenerator) generateBucketCar(bucketUuid string, dirCid cid.Cid, policy core.Policy, carPath string) (cid.Cid, uint64, abi.PaddedPieceSize, error) {
+	pieceCid, carSize, unpaddedPieceSize, err := GeneratePieceCommitment(context.Background(), dirCid, r.LightNode.Node.Blockstore, carPath)
+	if err != nil {
+		return cid.Undef, 0, 0, err
+	}
+
+	pieceSize := unpaddedPieceSize.Padded()
+	if target := abi.PaddedPieceSize(policy.TargetPieceSize); target > pieceSize {
+		pieceCid, err = PadPieceCommitment(pieceCid, pieceSize, target)
+		if err != nil {
+			return cid.Undef, 0, 0, err
+		}
+		pieceSize = target
+	} else if target != 0 && target < pieceSize {
+		log.Warnf("bucket %s does not fit in the target piece size %d, using piece size %d", bucketUuid, target, pieceSize)
+	}
+	return pieceCid, carSize, pieceSize, nil
+}
+
+// BucketCarPath returns the location of the car file generated for a bucket.
+func BucketCarPath(ln *core.LightNode, bucketUuid string) string {
+	return filepath.Join(ln.Config.Node.StagingDir, bucketUuid+".car")
+}
+
+// GeneratePieceCommitment writes the car for the payload cid to carPath and computes the piece commitment in the same
+// pass, so the car never has to be held in memory. The file is written next to carPath first and only moved in place
+// once it is complete.
+func GeneratePieceCommitment(ctx context.Context, payloadCid cid.Cid, bstore blockstore.Blockstore, carPath string) (cid.Cid, uint64, abi.UnpaddedPieceSize, error) {
 	selectiveCar := car.NewSelectiveCar(
-		context.Background(),
+		ctx,
 		bstore,
 		[]car.Dag{{Root: payloadCid, Selector: shared.AllSelector()}},
 		car.MaxTraversalLinks(maxTraversalLinks),
 		car.TraverseLinksOnlyOnce(),
 	)
 
-	buf := new(bytes.Buffer)
-	blockCount := 0
-	var oneStepBlocks []car.Block
-	err := selectiveCar.Write(buf, func(block car.Block) error {
-		oneStepBlocks = append(oneStepBlocks, block)
-		blockCount++
-		return nil
-	})
+	if err := os.MkdirAll(filepath.Dir(carPath), 0755); err != nil {
+		return cid.Undef, 0, 0, err
+	}
+	tmpPath := carPath + ".tmp"
+	carFile, err := os.Create(tmpPath)
 	if err != nil {
-		return cid.Undef, 0, 0, *buf, err
+		return cid.Undef, 0, 0, err
 	}
+	defer os.Remove(tmpPath) // no-op once renamed
+	defer carFile.Close()
 
-	preparedCar, err := selectiveCar.Prepare()
-	if err != nil {
-		return cid.Undef, 0, 0, *buf, err
+	cp := new(commp.Calc)
+	writer := bufio.NewWriterSize(io.MultiWriter(carFile, cp), carBufSize)
+	if err := selectiveCar.Write(writer); err != nil {
+		return cid.Undef, 0, 0, err
+	}
+	if err := writer.Flush(); err != nil {
+		return cid.Undef, 0, 0, err
+	}
+	if err := carFile.Sync(); err != nil {
+		return cid.Undef, 0, 0, err
 	}
 
-	writer := new(commp.Calc)
-	carWriter := &bytes.Buffer{}
-	err = preparedCar.Dump(ctx, writer)
+	stat, err := carFile.Stat()
 	if err != nil {
-		return cid.Undef, 0, 0, *buf, err
+		return cid.Undef, 0, 0, err
 	}
-	commpc, size, err := writer.Digest()
+
+	commpc, size, err := cp.Digest()
 	if err != nil {
-		return cid.Undef, 0, 0, *buf, err
+		return cid.Undef, 0, 0, err
 	}
-	err = preparedCar.Dump(ctx, carWriter)
+	commCid, err := commcid.DataCommitmentV1ToCID(commpc)
 	if err != nil {
-		return cid.Undef, 0, 0, *buf, err
+		return cid.Undef, 0, 0, err
 	}
 
-	commCid, err := commcid.DataCommitmentV1ToCID(commpc)
-	if err != nil {
-		return cid.Undef, 0, 0, *buf, err
+	if err := carFile.Close(); err != nil {
+		return cid.Undef, 0, 0, err
+	}
+	if err := os.Rename(tmpPath, carPath); err != nil {
+		return cid.Undef, 0, 0, err
 	}
 
-	return commCid, preparedCar.Size(), abi.PaddedPieceSize(size).Unpadded(), *buf, nil
+	return commCid, uint64(stat.Size()), abi.PaddedPieceSize(size).Unpadded(), nil
+}
+
+// PadPieceCommitment returns the piece commitment of the piece padded with zeros up to the target size.
+func PadPieceCommitment(pieceCid cid.Cid, pieceSize abi.PaddedPieceSize, target abi.PaddedPieceSize) (cid.Cid, error) {
+	commP, err := commcid.CIDToDataCommitmentV1(pieceCid)
+	if err != nil {
+		return cid.Undef, err
+	}
+	padded, err := commp.PadCommP(commP, uint64(pieceSize), uint64(target))
+	if err != nil {
+		return cid.Undef, err
+	}
+	return commcid.DataCommitmentV1ToCID(padded)
 }
@@ -0,0 +1,43 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/application-research/edge-ur/core"
+)
+
+// BucketClaimReaper periodically expires the bucket claims whose lease ran out, so their buckets are offered to other
+// storage providers again.
+type BucketClaimReaper struct {
+	LightNode *core.LightNode
+	interval  time.Duration
+}
+
+func NewBucketClaimReaper(ln *core.LightNode) *BucketClaimReaper {
+	return &BucketClaimReaper{
+		LightNode: ln,
+		interval:  time.Minute,
+	}
+}
+
+// Start expires the leases on every tick until the context is done.
+func (r *BucketClaimReaper) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			expired, err := r.LightNode.Claims.ExpireLeases(time.Now())
+			if err != nil {
+				log.Errorf("failed to expire bucket claims: %s", err)
+			} else if expired > 0 {
+				log.Infof("expired %d bucket claims", expired)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
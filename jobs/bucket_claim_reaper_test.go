@@ -0,0 +1,43 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/application-research/edge-ur/core"
+)
+
+func TestBucketClaimReaperExpiresLeases(t *testing.T) {
+	ln := newTestNode(t, config.EdgeConfig{})
+	ln.Claims = core.NewBucketClaimer(ln.DB, *ln.Config)
+	ln.DB.Create(&core.Bucket{Uuid: "bucket-uuid", Name: "default", Status: "ready", PieceCid: "baga-piece"})
+	claim, _, err := ln.Claims.Claim("f01000", "bucket-uuid", "key", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reaper := NewBucketClaimReaper(ln)
+	reaper.interval = 10 * time.Millisecond
+	reaper.Start(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var status string
+		ln.DB.Model(&core.BucketClaim{}).Where("id = ?", claim.ID).Pluck("status", &status)
+		if status == core.ClaimStatusExpired {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("lease is still %s", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, _, err := ln.Claims.Claim("f02000", "bucket-uuid", "key", time.Hour); err != nil {
+		t.Fatalf("the bucket was not offered again: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/application-research/edge-ur/core"
+)
+
+// BucketSealer periodically queues the aggregator for open buckets that went past the max bucket age of their
+// policy. Without it an aged bucket is only sealed when the next upload to its collection triggers the aggregator.
+type BucketSealer struct {
+	LightNode *core.LightNode
+	interval  time.Duration
+}
+
+func NewBucketSealer(ln *core.LightNode) *BucketSealer {
+	interval := time.Duration(ln.Config.Jobs.SealInterval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &BucketSealer{
+		LightNode: ln,
+		interval:  interval,
+	}
+}
+
+// Start checks the open buckets on every tick until the context is done.
+func (s *BucketSealer) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			s.check(time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *BucketSealer) check(now time.Time) {
+	var buckets []core.Bucket
+	s.LightNode.DB.Model(&core.Bucket{}).
+		Joins("join policies on policies.id = buckets.policy_id").
+		Where("buckets.status = ? and policies.max_bucket_age > 0", "open").
+		Find(&buckets)
+
+	policies := make(map[int64]core.Policy)
+	for _, bucket := range buckets {
+		policy, ok := policies[bucket.PolicyId]
+		if !ok {
+			s.LightNode.DB.Model(&core.Policy{}).Where("id = ?", bucket.PolicyId).First(&policy)
+			policies[bucket.PolicyId] = policy
+		}
+		if !policy.ShouldSeal(bucket, bucket.Size, now) {
+			continue
+		}
+
+		// the aggregator recounts the contents and does the actual sealing
+		bucket := bucket
+		if err := Enqueue(s.LightNode, NewBucketAggregator(s.LightNode, &bucket)); err != nil {
+			log.Errorf("failed to queue the aggregator for bucket %s: %s", bucket.Uuid, err)
+		}
+	}
+}
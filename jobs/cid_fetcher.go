@@ -0,0 +1,156 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/application-research/edge-ur/core"
+	"github.com/application-research/edge-ur/utils"
+	"github.com/ipfs/go-cid"
+)
+
+// The fetchProgressInterval constant is how often the progress of a fetch is written to the database.
+const fetchProgressInterval = 5 * time.Second
+
+// The CidFetcherProcessor fetches the whole dag of a cid requested with fetch-cids and adds it to its collection.
+type CidFetcherProcessor struct {
+	Fetch core.CidFetch
+	Processor
+}
+
+// CidFetchJobPayload is the job queue payload of the cid fetcher.
+type CidFetchJobPayload struct {
+	FetchID int64 `json:"fetch_id"`
+}
+
+func NewCidFetcherProcessor(ln *core.LightNode, fetch core.CidFetch) IQueuedProcessor {
+	return &CidFetcherProcessor{
+		Fetch: fetch,
+		Processor: Processor{
+			LightNode: ln,
+		},
+	}
+}
+
+// newCidFetcherFromPayload loads the fetch of a queued cid fetcher job.
+func newCidFetcherFromPayload(ln *core.LightNode, payload []byte) (IProcessor, error) {
+	var p CidFetchJobPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	var fetch core.CidFetch
+	if err := ln.DB.Model(&core.CidFetch{}).Where("id = ?", p.FetchID).First(&fetch).Error; err != nil {
+		return nil, err
+	}
+	return NewCidFetcherProcessor(ln, fetch), nil
+}
+
+func (r *CidFetcherProcessor) Info() error {
+	panic("implement me")
+}
+
+func (r *CidFetcherProcessor) Type() string {
+	return JobTypeCidFetcher
+}
+
+func (r *CidFetcherProcessor) Key() string {
+	return strconv.FormatInt(r.Fetch.ID, 10)
+}
+
+func (r *CidFetcherProcessor) Payload() interface{} {
+	return CidFetchJobPayload{FetchID: r.Fetch.ID}
+}
+
+// Run fetches the dag of the cid, sizes it from its unixfs metadata and registers it as a content of the collection
+// of the fetch. A fetch that runs out of time is marked failed, the blocks fetched so far are kept so asking for the
+// cid again picks up where it stopped.
+func (r *CidFetcherProcessor) Run() error {
+	if r.Fetch.Status == core.FetchStatusCompleted || r.Fetch.Status == core.FetchStatusFailed {
+		return nil
+	}
+
+	root, err := cid.Decode(r.Fetch.Cid)
+	if err != nil {
+		return r.fail(err)
+	}
+	r.update(map[string]interface{}{"status": core.FetchStatusFetching})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.LightNode.Config.Common.FetchTimeout)*time.Minute)
+	defer cancel()
+
+	// keep the latest progress and write it down every few seconds
+	var lk sync.Mutex
+	var last core.FetchProgress
+	lastUpdate := time.Now()
+	rootNode, err := r.LightNode.FetchDag(ctx, root, time.Duration(r.LightNode.Config.Common.FetchBlockTimeout)*time.Second, func(p core.FetchProgress) {
+		lk.Lock()
+		defer lk.Unlock()
+		if p.Blocks > last.Blocks {
+			last = p
+		}
+		if time.Since(lastUpdate) >= fetchProgressInterval {
+			lastUpdate = time.Now()
+			r.update(map[string]interface{}{"blocks_fetched": last.Blocks, "bytes_fetched": last.Bytes})
+		}
+	})
+	if err != nil {
+		r.update(map[string]interface{}{"blocks_fetched": last.Blocks, "bytes_fetched": last.Bytes})
+		return r.fail(err)
+	}
+
+	size, err := core.UnixfsSize(rootNode)
+	if err != nil {
+		return r.fail(err)
+	}
+	policy, err := r.LightNode.PolicyForCollection(r.Fetch.CollectionName)
+	if err != nil {
+		return err
+	}
+
+	newContent := core.Content{
+		Name:           r.Fetch.Cid,
+		Size:           size,
+		Cid:            r.Fetch.Cid,
+		ApiKeyHash:     r.Fetch.ApiKeyHash,
+		Status:         utils.STATUS_PINNED,
+		CollectionName: r.Fetch.CollectionName,
+		MakeDeal:       true,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+	if core.IsUnixfsFile(rootNode) {
+		newContent, err = AddContentToCollection(r.LightNode, newContent, policy, "")
+	} else {
+		// the splitter only splits files, a large directory goes into a bucket whole
+		newContent, err = AddContentToBucket(r.LightNode, newContent, policy)
+	}
+	if err != nil {
+		return err
+	}
+
+	return r.update(map[string]interface{}{
+		"status":         core.FetchStatusCompleted,
+		"blocks_fetched": last.Blocks,
+		"bytes_fetched":  last.Bytes,
+		"size":           size,
+		"content_id":     newContent.ID,
+		"last_message":   "",
+	})
+}
+
+func (r *CidFetcherProcessor) update(values map[string]interface{}) error {
+	values["updated_at"] = time.Now()
+	return r.LightNode.DB.Model(&core.CidFetch{}).Where("id = ?", r.Fetch.ID).Updates(values).Error
+}
+
+// fail marks a fetch that could not be finished, e.g. because the cid is not found on the network in time.
+func (r *CidFetcherProcessor) fail(err error) error {
+	r.update(map[string]interface{}{
+		"status":       core.FetchStatusFailed,
+		"last_message": err.Error(),
+	})
+	return nil
+}
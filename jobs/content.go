@@ -0,0 +1,50 @@
+package jobs
+
+import (
+	"github.com/application-research/edge-ur/core"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+// AddContentToCollection records a pinned content in its collection. A content larger than the max split size is
+// split first, the splitter reads the file from stagedPath when it is set and removes it once done. Other contents
+// are added to the open bucket of the collection and the aggregator is queued for the bucket.
+func AddContentToCollection(ln *core.LightNode, content core.Content, policy core.Policy, stagedPath string) (core.Content, error) {
+	if content.Size > ln.Config.Common.MaxSizeToSplit {
+		if err := ln.DB.Transaction(func(tx *gorm.DB) error { return core.CreateContent(tx, &content) }); err != nil {
+			return content, err
+		}
+		countContent(ln, content)
+
+		// split the file and use the same tag policies
+		if err := Enqueue(ln, NewSplitterProcessor(ln, content, stagedPath)); err != nil {
+			return content, xerrors.Errorf("failed to queue the splitter: %w", err)
+		}
+		return content, nil
+	}
+	return AddContentToBucket(ln, content, policy)
+}
+
+// AddContentToBucket records a content in the open bucket of its collection whatever its size, and queues the
+// aggregator for the bucket. It is used directly for contents the splitter can't split, like directories.
+func AddContentToBucket(ln *core.LightNode, content core.Content, policy core.Policy) (core.Content, error) {
+	bucket, err := ln.Buckets.Allocate(policy, &content)
+	if err != nil {
+		return content, xerrors.Errorf("failed to add the content to a bucket: %w", err)
+	}
+	countContent(ln, content)
+	if err := Enqueue(ln, NewBucketAggregator(ln, &bucket)); err != nil {
+		return content, xerrors.Errorf("failed to queue the aggregator: %w", err)
+	}
+	return content, nil
+}
+
+// countContent adds a recorded content to the storage usage of its api key.
+func countContent(ln *core.LightNode, content core.Content) {
+	if content.ApiKeyHash == "" {
+		return
+	}
+	if err := ln.Quotas.AddStorage(content.ApiKeyHash, content.Size, 1); err != nil {
+		log.Errorf("failed to count content %d in the quota of its api key: %s", content.ID, err)
+	}
+}
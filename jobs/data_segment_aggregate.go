@@ -0,0 +1,169 @@
+package jobs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/application-research/edge-ur/core"
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+)
+
+// generateDataSegmentAggregate builds the piece of the bucket as a FRC-0058 aggregate. Every content gets its own car
+// and sub-piece, and the inclusion proof of the sub-piece is stored on the content so its owner can prove the content
+// is part of the deal.
+func (r *BucketCarGenerator) generateDataSegmentAggregate(bucketUuid string, contents []core.Content, policy core.Policy, aggPath string) (cid.Cid, uint64, abi.PaddedPieceSize, error) {
+	subPieceDir := filepath.Join(r.LightNode.Config.Node.StagingDir, bucketUuid)
+	defer os.RemoveAll(subPieceDir)
+
+	subPieces := make([]core.SubPiece, len(contents))
+	carPaths := make([]string, len(contents))
+	for i, content := range contents {
+		contentCid, err := cid.Decode(content.Cid)
+		if err != nil {
+			return cid.Undef, 0, 0, err
+		}
+		carPaths[i] = filepath.Join(subPieceDir, fmt.Sprintf("%d.car", content.ID))
+		pieceCid, _, unpaddedPieceSize, err := GeneratePieceCommitment(context.Background(), contentCid, r.LightNode.Node.Blockstore, carPaths[i])
+		if err != nil {
+			return cid.Undef, 0, 0, xerrors.Errorf("failed to generate the sub-piece of content %d: %w", content.ID, err)
+		}
+		subPieces[i] = core.SubPiece{PieceCid: pieceCid, Size: uint64(unpaddedPieceSize.Padded())}
+	}
+
+	agg, err := core.NewDataSegmentAggregate(subPieces, uint64(policy.TargetPieceSize))
+	if err != nil {
+		return cid.Undef, 0, 0, err
+	}
+	if policy.TargetPieceSize != 0 && agg.DealSize > uint64(policy.TargetPieceSize) {
+		log.Warnf("bucket %s does not fit in the target piece size %d, using piece size %d", bucketUuid, policy.TargetPieceSize, agg.DealSize)
+	}
+	pieceCid, err := agg.PieceCid()
+	if err != nil {
+		return cid.Undef, 0, 0, err
+	}
+
+	// the commitment of the bytes on disk has to match the aggregate tree, otherwise the proofs are worthless.
+	writtenCid, size, err := writeDataSegmentAggregate(agg, carPaths, aggPath)
+	if err != nil {
+		return cid.Undef, 0, 0, err
+	}
+	if !writtenCid.Equals(pieceCid) {
+		return cid.Undef, 0, 0, xerrors.Errorf("aggregate piece %s does not match the data segment tree %s", writtenCid, pieceCid)
+	}
+
+	for i, content := range contents {
+		proof, err := json.Marshal(agg.InclusionProof(i))
+		if err != nil {
+			return cid.Undef, 0, 0, err
+		}
+		r.LightNode.DB.Model(&core.Content{}).Where("id = ?", content.ID).Updates(map[string]interface{}{
+			"piece_cid":       subPieces[i].PieceCid.String(),
+			"piece_size":      int64(subPieces[i].Size),
+			"piece_offset":    int64(agg.Offsets[i]),
+			"inclusion_proof": string(proof),
+		})
+	}
+
+	return pieceCid, size, abi.PaddedPieceSize(agg.DealSize), nil
+}
+
+// writeDataSegmentAggregate writes the sub-piece cars at their offsets, zeros in between and the data segment index at
+// the end, and returns the piece commitment of what was written.
+func writeDataSegmentAggregate(agg *core.DataSegmentAggregate, carPaths []string, aggPath string) (cid.Cid, uint64, error) {
+	order := make([]int, len(carPaths))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return agg.Offsets[order[a]] < agg.Offsets[order[b]]
+	})
+
+	tmpPath := aggPath + ".tmp"
+	aggFile, err := os.Create(tmpPath)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	defer os.Remove(tmpPath) // no-op once renamed
+	defer aggFile.Close()
+
+	cp := new(commp.Calc)
+	writer := bufio.NewWriterSize(io.MultiWriter(aggFile, cp), carBufSize)
+
+	var written uint64
+	padTo := func(paddedOffset uint64) error {
+		offset := uint64(abi.PaddedPieceSize(paddedOffset).Unpadded())
+		if offset < written {
+			return xerrors.Errorf("sub-piece overflows into offset %d", paddedOffset)
+		}
+		n, err := io.CopyN(writer, zeroReader{}, int64(offset-written))
+		written += uint64(n)
+		return err
+	}
+
+	for _, i := range order {
+		if err := padTo(agg.Offsets[i]); err != nil {
+			return cid.Undef, 0, err
+		}
+		carFile, err := os.Open(carPaths[i])
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+		n, err := io.Copy(writer, carFile)
+		carFile.Close()
+		written += uint64(n)
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+	}
+
+	if err := padTo(agg.IndexStartOffset()); err != nil {
+		return cid.Undef, 0, err
+	}
+	n, err := writer.Write(agg.IndexData())
+	written += uint64(n)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	if err := writer.Flush(); err != nil {
+		return cid.Undef, 0, err
+	}
+	if err := aggFile.Sync(); err != nil {
+		return cid.Undef, 0, err
+	}
+
+	commpc, _, err := cp.Digest()
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+	pieceCid, err := commcid.DataCommitmentV1ToCID(commpc)
+	if err != nil {
+		return cid.Undef, 0, err
+	}
+
+	if err := aggFile.Close(); err != nil {
+		return cid.Undef, 0, err
+	}
+	if err := os.Rename(tmpPath, aggPath); err != nil {
+		return cid.Undef, 0, err
+	}
+	return pieceCid, written, nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
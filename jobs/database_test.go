@@ -0,0 +1,23 @@
+package jobs
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/application-research/edge-ur/core"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestNode returns a node with a migrated sqlite database and the given config, without the ipfs node.
+func newTestNode(t *testing.T, cfg config.EdgeConfig) *core.LightNode {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "edge-urid.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	core.ConfigureModels(db)
+	return &core.LightNode{DB: db, Config: &cfg}
+}
@@ -0,0 +1,185 @@
+package jobs
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/application-research/edge-ur/core"
+	"github.com/application-research/edge-ur/utils"
+	"golang.org/x/xerrors"
+)
+
+// The DealMakerProcessor submits a ready bucket to delta as an import deal. The storage provider pulls the piece
+// from the piece endpoint of this node.
+type DealMakerProcessor struct {
+	Bucket core.Bucket
+	Delta  *core.DeltaClient
+	Processor
+}
+
+func NewDealMakerProcessor(ln *core.LightNode, bucket core.Bucket) IQueuedProcessor {
+	return &DealMakerProcessor{
+		Bucket: bucket,
+		Delta:  core.NewDeltaClient(*ln.Config),
+		Processor: Processor{
+			LightNode: ln,
+		},
+	}
+}
+
+// newDealMakerProcessorFromPayload loads the bucket of a queued deal maker job.
+func newDealMakerProcessorFromPayload(ln *core.LightNode, payload []byte) (IProcessor, error) {
+	var p BucketJobPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	var bucket core.Bucket
+	if err := ln.DB.Model(&core.Bucket{}).Where("uuid = ?", p.BucketUuid).First(&bucket).Error; err != nil {
+		return nil, err
+	}
+	return NewDealMakerProcessor(ln, bucket), nil
+}
+
+func (r *DealMakerProcessor) Info() error {
+	panic("implement me")
+}
+
+func (r *DealMakerProcessor) Type() string {
+	return JobTypeDealMaker
+}
+
+func (r *DealMakerProcessor) Key() string {
+	return r.Bucket.Uuid
+}
+
+func (r *DealMakerProcessor) Payload() interface{} {
+	return BucketJobPayload{BucketUuid: r.Bucket.Uuid}
+}
+
+// Run submits as many deals for the bucket as the replication factor of its policy asks for, and records the delta
+// content id of each deal. It runs for a bucket whatever state its deals moved it to, so deals that failed or were
+// slashed are replaced. Failed submissions are retried by the job queue.
+func (r *DealMakerProcessor) Run() error {
+	if r.Bucket.PieceCid == "" || r.Bucket.Status == "deleted" {
+		return nil
+	}
+
+	policy, err := bucketPolicy(r.LightNode, r.Bucket)
+	if err != nil {
+		return err
+	}
+
+	replicas := liveReplicas(r.LightNode, r.Bucket, policy)
+	missing := policy.Replicas() - int(replicas)
+	if missing <= 0 {
+		return nil // already submitted
+	}
+
+	dealReqs := make([]core.DeltaDealRequest, missing)
+	for i := range dealReqs {
+		dealReqs[i] = r.dealRequest(policy)
+		if i > 0 || replicas > 0 {
+			dealReqs[i].Miner = "" // the miner of the bucket gets one deal, delta picks the other storage providers
+		}
+	}
+	dealResps, err := r.Delta.SubmitImportDeals(dealReqs)
+	if err != nil {
+		r.LightNode.DB.Model(&core.Bucket{}).Where("id = ?", r.Bucket.ID).Update("last_message", err.Error())
+		return err
+	}
+
+	var submitErr error
+	var message string
+	for i, dealResp := range dealResps {
+		if dealResp.Status != "success" || dealResp.ContentId == 0 {
+			submitErr = xerrors.Errorf("delta did not accept the deal for bucket %s: %s", r.Bucket.Uuid, dealResp.Message)
+			continue
+		}
+
+		deal := core.ContentDeal{
+			ContentId:  dealResp.ContentId,
+			BucketUuid: r.Bucket.Uuid,
+			Miner:      dealReqs[i].Miner,
+			Status:     utils.STATUS_UPLOADED_TO_DELTA,
+			Verified:   dealReqs[i].DealVerifyState == "verified",
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+		}
+		if err := r.LightNode.DB.Create(&deal).Error; err != nil {
+			return err
+		}
+		message = dealResp.Message
+	}
+	if submitErr != nil {
+		r.LightNode.DB.Model(&core.Bucket{}).Where("id = ?", r.Bucket.ID).Update("last_message", submitErr.Error())
+		return submitErr // the job is retried for the deals that were not accepted
+	}
+
+	r.LightNode.DB.Model(&core.Bucket{}).Where("id = ?", r.Bucket.ID).Update("last_message", message)
+	if replicas > 0 {
+		return nil // the contents keep the state the other replicas already moved them to
+	}
+	r.LightNode.DB.Model(&core.Content{}).Where("bucket_uuid = ?", r.Bucket.Uuid).Updates(map[string]interface{}{
+		"status":       utils.STATUS_UPLOADED_TO_DELTA,
+		"last_message": message,
+		"updated_at":   time.Now(),
+	})
+	return nil
+}
+
+// isDataSegmentAggregate tells if the piece of the bucket was built as a data segment aggregate, whose contents carry
+// an inclusion proof.
+func (r *DealMakerProcessor) isDataSegmentAggregate() bool {
+	var proofs int64
+	r.LightNode.DB.Model(&core.Content{}).Where("bucket_uuid = ? and inclusion_proof <> ''", r.Bucket.Uuid).Count(&proofs)
+	return proofs > 0
+}
+
+func bucketPolicy(ln *core.LightNode, bucket core.Bucket) (core.Policy, error) {
+	var policy core.Policy
+	ln.DB.Model(&core.Policy{}).Where("id = ?", bucket.PolicyId).Find(&policy)
+	if policy.ID != 0 {
+		return policy, nil
+	}
+	return ln.PolicyForCollection(bucket.Name)
+}
+
+// liveReplicas counts the replica slots of a bucket that are taken by deals or by storage providers that claimed it.
+// Failed and slashed deals are not counted, except that with auto retry delta replaces a failed deal itself, so a deal
+// that failed lately still is.
+func liveReplicas(ln *core.LightNode, bucket core.Bucket, policy core.Policy) int64 {
+	return core.LiveReplicas(ln.DB, bucket.Uuid, policy.DealAutoRetry(*ln.Config), time.Now())
+}
+
+// dealRequest builds the import deal of the bucket, deal parameters missing from the policy fall back to the node
+// config.
+func (r *DealMakerProcessor) dealRequest(policy core.Policy) core.DeltaDealRequest {
+	cfg := r.LightNode.Config.Delta
+	req := core.DeltaDealRequest{
+		Cid:                r.Bucket.DirCid,
+		Miner:              r.Bucket.Miner,
+		Size:               r.Bucket.Size,
+		DurationInDays:     policy.DealDurationInDays,
+		StartEpochInDays:   cfg.StartEpochInDays,
+		ConnectionMode:     "import",
+		RemoveUnsealedCopy: policy.DealRemoveUnsealedCopy(*r.LightNode.Config),
+		AutoRetry:          policy.DealAutoRetry(*r.LightNode.Config),
+		Label:              r.Bucket.Uuid,
+		DealVerifyState:    policy.DealVerifyState,
+	}
+	if req.DurationInDays == 0 {
+		req.DurationInDays = cfg.DealDurationInDays
+	}
+	if req.DealVerifyState == "" {
+		req.DealVerifyState = cfg.DealVerifyState
+	}
+	if r.isDataSegmentAggregate() {
+		// the aggregate is not the car of the bucket directory, the deal is made for the piece alone
+		req.Cid = ""
+		req.Size = 0
+	}
+	req.PieceCommitment.PieceCid = r.Bucket.PieceCid
+	req.PieceCommitment.PaddedPieceSize = r.Bucket.PieceSize
+	req.TransferParameters.URL = r.LightNode.PublicUrl() + "/piece/" + r.Bucket.PieceCid
+	return req
+}
@@ -0,0 +1,186 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/application-research/edge-ur/core"
+	"github.com/application-research/edge-ur/core/deltatest"
+)
+
+func newDealMakerTest(t *testing.T, replicas int, autoRetry bool) (*core.LightNode, *deltatest.Server, core.Bucket) {
+	t.Helper()
+	delta := deltatest.NewServer(t)
+	delta.Accept = replicas
+
+	var cfg config.EdgeConfig
+	cfg.Node.PublicUrl = "http://edge.example"
+	cfg.Delta.ApiUrl = delta.URL
+	cfg.Delta.DealDurationInDays = 540
+	cfg.Delta.DealVerifyState = "verified"
+	ln := newTestNode(t, cfg)
+
+	policy := core.Policy{Name: "default", BucketSize: 1 << 20, ReplicationFactor: replicas, AutoRetry: &autoRetry}
+	ln.DB.Create(&policy)
+	bucket := core.Bucket{
+		Uuid:      "bucket-uuid",
+		Name:      "default",
+		Status:    "ready",
+		PolicyId:  policy.ID,
+		Miner:     "f01000",
+		DirCid:    "bafy-dir",
+		PieceCid:  "baga-piece",
+		PieceSize: 2048,
+		Size:      1500,
+	}
+	ln.DB.Create(&bucket)
+	ln.DB.Create(&core.Content{Name: "a", BucketUuid: bucket.Uuid, Status: "pinned"})
+	return ln, delta, bucket
+}
+
+func runDealMaker(t *testing.T, ln *core.LightNode, bucketUuid string) error {
+	t.Helper()
+	var bucket core.Bucket
+	ln.DB.Model(&core.Bucket{}).Where("uuid = ?", bucketUuid).First(&bucket)
+	return NewDealMakerProcessor(ln, bucket).Run()
+}
+
+func TestDealMakerSubmitsBucket(t *testing.T) {
+	ln, delta, bucket := newDealMakerTest(t, 2, false)
+	if err := runDealMaker(t, ln, bucket.Uuid); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(delta.Submissions()) != 1 || len(delta.Submissions()[0]) != 2 {
+		t.Fatalf("delta got the submissions %+v", delta.Submissions())
+	}
+	req := delta.Submissions()[0][0]
+	if req.Cid != "bafy-dir" || req.Miner != "f01000" || req.ConnectionMode != "import" || req.Label != bucket.Uuid ||
+		req.DurationInDays != 540 || req.DealVerifyState != "verified" ||
+		req.PieceCommitment.PieceCid != "baga-piece" || req.PieceCommitment.PaddedPieceSize != 2048 ||
+		req.TransferParameters.URL != "http://edge.example/piece/baga-piece" {
+		t.Fatalf("the deal request is %+v", req)
+	}
+	if delta.Submissions()[0][1].Miner != "" {
+		t.Fatal("the second replica went to the miner of the bucket")
+	}
+
+	var deals []core.ContentDeal
+	ln.DB.Model(&core.ContentDeal{}).Where("bucket_uuid = ?", bucket.Uuid).Order("id").Find(&deals)
+	if len(deals) != 2 || deals[0].ContentId != 1 || deals[1].ContentId != 2 || !deals[0].Verified {
+		t.Fatalf("recorded the deals %+v", deals)
+	}
+	var content core.Content
+	ln.DB.Model(&core.Content{}).Where("bucket_uuid = ?", bucket.Uuid).First(&content)
+	if content.Status != "uploaded-to-delta" || content.LastMessage != "deal accepted" {
+		t.Fatalf("the content is %s: %s", content.Status, content.LastMessage)
+	}
+
+	// the replication factor is met, nothing more is submitted
+	if err := runDealMaker(t, ln, bucket.Uuid); err != nil || len(delta.Submissions()) != 1 {
+		t.Fatalf("submitted again: %v", err)
+	}
+}
+
+func TestDealMakerRetriesRejectedDeals(t *testing.T) {
+	ln, delta, bucket := newDealMakerTest(t, 3, false)
+	delta.Accept = 1
+	if err := runDealMaker(t, ln, bucket.Uuid); err == nil {
+		t.Fatal("a partly rejected submission succeeded, the job would not be retried")
+	}
+	var deals int64
+	ln.DB.Model(&core.ContentDeal{}).Where("bucket_uuid = ?", bucket.Uuid).Count(&deals)
+	if deals != 1 {
+		t.Fatalf("recorded %d deals for one accepted deal", deals)
+	}
+
+	// the retry only submits the deals that were rejected
+	delta.Accept = 2
+	if err := runDealMaker(t, ln, bucket.Uuid); err != nil {
+		t.Fatal(err)
+	}
+	if len(delta.Submissions()) != 2 || len(delta.Submissions()[1]) != 2 {
+		t.Fatalf("the retry submitted %+v", delta.Submissions()[1:])
+	}
+	ln.DB.Model(&core.ContentDeal{}).Where("bucket_uuid = ?", bucket.Uuid).Count(&deals)
+	if deals != 3 {
+		t.Fatalf("recorded %d deals for three accepted deals", deals)
+	}
+}
+
+func TestDealMakerReplacesLostDeals(t *testing.T) {
+	ln, delta, bucket := newDealMakerTest(t, 2, false)
+	if err := runDealMaker(t, ln, bucket.Uuid); err != nil {
+		t.Fatal(err)
+	}
+	ln.DB.Model(&core.Bucket{}).Where("id = ?", bucket.ID).Update("status", "on-chain")
+	ln.DB.Model(&core.Content{}).Where("bucket_uuid = ?", bucket.Uuid).Update("status", "on-chain")
+	ln.DB.Model(&core.ContentDeal{}).Where("content_id = ?", 2).Updates(map[string]interface{}{"failed": true, "failed_at": time.Now()})
+
+	if err := runDealMaker(t, ln, bucket.Uuid); err != nil {
+		t.Fatal(err)
+	}
+	if len(delta.Submissions()) != 2 || len(delta.Submissions()[1]) != 1 {
+		t.Fatalf("the failed deal was replaced by %+v", delta.Submissions()[1:])
+	}
+	var content core.Content
+	ln.DB.Model(&core.Content{}).Where("bucket_uuid = ?", bucket.Uuid).First(&content)
+	if content.Status == "uploaded-to-delta" {
+		t.Fatal("the replacement moved the contents back")
+	}
+}
+
+func TestDealMakerLeavesFailedDealsToAutoRetry(t *testing.T) {
+	ln, delta, bucket := newDealMakerTest(t, 1, true)
+	if err := runDealMaker(t, ln, bucket.Uuid); err != nil {
+		t.Fatal(err)
+	}
+	ln.DB.Model(&core.ContentDeal{}).Where("content_id = ?", 1).Updates(map[string]interface{}{"failed": true, "failed_at": time.Now()})
+	if err := runDealMaker(t, ln, bucket.Uuid); err != nil || len(delta.Submissions()) != 1 {
+		t.Fatalf("a deal delta is still retrying was replaced: %v", err)
+	}
+
+	ln.DB.Model(&core.ContentDeal{}).Where("content_id = ?", 1).Update("failed_at", time.Now().Add(-2*failedDealGrace))
+	if err := runDealMaker(t, ln, bucket.Uuid); err != nil || len(delta.Submissions()) != 2 {
+		t.Fatalf("a deal delta gave up on was not replaced: %v", err)
+	}
+}
+
+func TestDealMakerSubmitsAggregateForPieceOnly(t *testing.T) {
+	ln, delta, bucket := newDealMakerTest(t, 1, false)
+	ln.DB.Model(&core.Content{}).Where("bucket_uuid = ?", bucket.Uuid).Update("inclusion_proof", `{"proof_subtree":{},"proof_index":{}}`)
+	if err := runDealMaker(t, ln, bucket.Uuid); err != nil {
+		t.Fatal(err)
+	}
+	req := delta.Submissions()[0][0]
+	if req.Cid != "" || req.Size != 0 {
+		t.Fatalf("the aggregate deal was made for the payload %s of %d bytes", req.Cid, req.Size)
+	}
+	if req.PieceCommitment.PieceCid != "baga-piece" || req.PieceCommitment.PaddedPieceSize != 2048 {
+		t.Fatalf("the deal request is %+v", req)
+	}
+}
+
+func TestDealMakerCountsClaims(t *testing.T) {
+	ln, delta, bucket := newDealMakerTest(t, 2, false)
+	claims := core.NewBucketClaimer(ln.DB, *ln.Config)
+	claim, _, err := claims.Claim("f05000", bucket.Uuid, "key", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := claims.Confirm(&claim); err != nil {
+		t.Fatal(err)
+	}
+
+	// the storage provider that claimed the bucket holds one replica, the deal maker only submits the other
+	if err := runDealMaker(t, ln, bucket.Uuid); err != nil {
+		t.Fatal(err)
+	}
+	if len(delta.Submissions()) != 1 || len(delta.Submissions()[0]) != 1 || delta.Submissions()[0][0].Miner != "" {
+		t.Fatalf("delta got the submissions %+v", delta.Submissions())
+	}
+	if _, _, err := claims.Claim("f06000", bucket.Uuid, "key", time.Hour); err != core.ErrBucketFullyClaimed {
+		t.Fatalf("claiming a bucket with a claim and a deal got %v", err)
+	}
+}
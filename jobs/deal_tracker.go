@@ -0,0 +1,182 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/application-research/edge-ur/core"
+	"github.com/application-research/edge-ur/utils"
+)
+
+// The failedDealGrace constant is how long failed deals keep being polled. With auto retry the backend makes a new
+// deal for the same content, which replaces the failed one.
+const failedDealGrace = core.FailedDealGrace
+
+// DealTracker periodically asks the deal-making backend for the status of the submitted deals and moves the buckets
+// and their contents through the deal lifecycle.
+type DealTracker struct {
+	LightNode *core.LightNode
+	Provider  core.DealStatusProvider
+	interval  time.Duration
+}
+
+func NewDealTracker(ln *core.LightNode) (*DealTracker, error) {
+	provider, err := core.NewDealStatusProvider(*ln.Config)
+	if err != nil {
+		return nil, err
+	}
+	interval := time.Duration(ln.Config.Delta.StatusPollInterval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	return &DealTracker{
+		LightNode: ln,
+		Provider:  provider,
+		interval:  interval,
+	}, nil
+}
+
+// Start polls the deals on every tick until the context is done.
+func (t *DealTracker) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		for {
+			t.poll(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// poll asks for the status of the deals that can still change. Sealed and slashed deals are done, failed deals are
+// polled until failedDealGrace is over in case the backend replaces them.
+func (t *DealTracker) poll(ctx context.Context) {
+	var deals []core.ContentDeal
+	t.LightNode.DB.Model(&core.ContentDeal{}).
+		Where("bucket_uuid <> '' and slashed = ? and (failed = ? or failed_at > ?)", false, false, time.Now().Add(-failedDealGrace)).
+		Where("status not in ? and (sealed_at is null or sealed_at <= ?)", []string{utils.STATUS_DEAL_SEALED, utils.STATUS_DEAL_SLASHED}, time.Time{}).
+		Find(&deals)
+
+	changed := make(map[string]bool)
+	for _, deal := range deals {
+		status, err := t.Provider.DealStatus(ctx, deal)
+		if err != nil {
+			log.Warnf("failed to get the status of deal %d (delta content %d): %s", deal.ID, deal.ContentId, err)
+			continue
+		}
+
+		applyDealStatus(&deal, status)
+		if err := t.LightNode.DB.Save(&deal).Error; err != nil {
+			log.Errorf("failed to save deal %d: %s", deal.ID, err)
+			continue
+		}
+		changed[deal.BucketUuid] = true
+	}
+
+	for bucketUuid := range changed {
+		t.updateBucket(bucketUuid)
+	}
+	t.replaceLostDeals()
+}
+
+// replaceLostDeals queues the deal maker for the buckets whose failed or slashed deals left them short of the
+// replication factor of their policy.
+func (t *DealTracker) replaceLostDeals() {
+	var buckets []core.Bucket
+	t.LightNode.DB.Model(&core.Bucket{}).
+		Where("status <> ? and uuid in (?)", "deleted", t.LightNode.DB.Model(&core.ContentDeal{}).Where("failed = ? or slashed = ?", true, true).Select("bucket_uuid")).
+		Find(&buckets)
+	for _, bucket := range buckets {
+		policy, err := bucketPolicy(t.LightNode, bucket)
+		if err != nil {
+			log.Errorf("failed to load the policy of bucket %s: %s", bucket.Uuid, err)
+			continue
+		}
+		if int(liveReplicas(t.LightNode, bucket, policy)) >= policy.Replicas() {
+			continue
+		}
+		if err := Enqueue(t.LightNode, NewDealMakerProcessor(t.LightNode, bucket)); err != nil {
+			log.Errorf("failed to queue the deal maker for bucket %s: %s", bucket.Uuid, err)
+		}
+	}
+}
+
+// updateBucket records the storage providers of the deals as replicas of the bucket, and moves the bucket and its
+// contents to the state of the best deal of the bucket.
+func (t *DealTracker) updateBucket(bucketUuid string) {
+	var deals []core.ContentDeal
+	t.LightNode.DB.Model(&core.ContentDeal{}).Where("bucket_uuid = ?", bucketUuid).Find(&deals)
+
+	var bucket core.Bucket
+	t.LightNode.DB.Model(&core.Bucket{}).Where("uuid = ?", bucketUuid).Find(&bucket)
+	for _, deal := range deals {
+		if deal.Miner == "" {
+			continue // delta did not pick a storage provider yet
+		}
+		err := core.RecordReplica(t.LightNode.DB, core.ContentReplication{
+			BucketUuid:            bucketUuid,
+			PieceCid:              bucket.PieceCid,
+			Miner:                 deal.Miner,
+			Status:                core.DealState(deal),
+			ContentDealID:         deal.ID,
+			ReplicaDeltaContentID: deal.ContentId,
+		})
+		if err != nil {
+			log.Errorf("failed to record the replica of deal %d: %s", deal.ID, err)
+		}
+	}
+
+	state := core.BucketDealState(deals)
+	if state == "" || state == utils.STATUS_UPLOADED_TO_DELTA {
+		return // nothing happened yet, the bucket stays ready
+	}
+
+	var best core.ContentDeal
+	for _, deal := range deals {
+		if core.DealState(deal) == state {
+			best = deal
+			break
+		}
+	}
+
+	t.LightNode.DB.Model(&core.Bucket{}).Where("uuid = ? and status <> ?", bucketUuid, "deleted").Updates(map[string]interface{}{
+		"status":       state,
+		"last_message": best.LastMessage,
+		"updated_at":   time.Now(),
+	})
+	t.LightNode.DB.Model(&core.Content{}).Where("bucket_uuid = ?", bucketUuid).Updates(map[string]interface{}{
+		"status":       state,
+		"miner":        best.Miner,
+		"last_message": best.LastMessage,
+		"updated_at":   time.Now(),
+	})
+}
+
+// applyDealStatus copies the status reported by the backend onto the deal. When the backend does not have a deal yet
+// only the message is kept, so what is known about the deal is not wiped.
+func applyDealStatus(deal *core.ContentDeal, status core.DealStatus) {
+	if status.Message != "" {
+		deal.LastMessage = status.Message
+	}
+	if status.DealUUID != "" || status.PropCid != "" {
+		deal.PropCid = status.PropCid
+		deal.DealUUID = status.DealUUID
+		deal.DealID = status.DealID
+		if status.Miner != "" {
+			deal.Miner = status.Miner
+		}
+		deal.Failed = status.Failed
+		deal.Slashed = status.Slashed
+		deal.FailedAt = status.FailedAt
+		deal.TransferStarted = status.TransferStarted
+		deal.TransferFinished = status.TransferFinished
+		deal.OnChainAt = status.OnChainAt
+		deal.SealedAt = status.SealedAt
+	}
+	deal.Status = core.DealState(*deal)
+	deal.UpdatedAt = time.Now()
+}
@@ -0,0 +1,164 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/application-research/edge-ur/core"
+	"github.com/application-research/edge-ur/utils"
+)
+
+// failingProvider fails the deals of the given delta contents and hands the others to the mock backend.
+type failingProvider struct {
+	core.MockDealStatusProvider
+	failed map[int64]bool
+}
+
+func (p *failingProvider) DealStatus(ctx context.Context, deal core.ContentDeal) (core.DealStatus, error) {
+	if !p.failed[deal.ContentId] {
+		return p.MockDealStatusProvider.DealStatus(ctx, deal)
+	}
+	return core.DealStatus{
+		DealUUID: "failed-deal",
+		Miner:    deal.Miner,
+		Failed:   true,
+		FailedAt: time.Now(),
+		Message:  "storage provider rejected the deal",
+	}, nil
+}
+
+func newDealTrackerTest(t *testing.T, provider core.DealStatusProvider, miners ...string) (*DealTracker, core.Bucket) {
+	t.Helper()
+	ln := newTestNode(t, config.EdgeConfig{})
+
+	policy := core.Policy{Name: "default", BucketSize: 1 << 20, ReplicationFactor: len(miners)}
+	ln.DB.Create(&policy)
+	bucket := core.Bucket{Uuid: "bucket-uuid", Name: "default", Status: "ready", PolicyId: policy.ID, PieceCid: "baga-piece"}
+	ln.DB.Create(&bucket)
+	ln.DB.Create(&core.Content{Name: "a", BucketUuid: bucket.Uuid, Status: utils.STATUS_UPLOADED_TO_DELTA})
+	for i, miner := range miners {
+		ln.DB.Create(&core.ContentDeal{ContentId: int64(i + 1), BucketUuid: bucket.Uuid, Miner: miner})
+	}
+	return &DealTracker{LightNode: ln, Provider: provider}, bucket
+}
+
+func assertBucketState(t *testing.T, ln *core.LightNode, bucketUuid string, state string) {
+	t.Helper()
+	var bucket core.Bucket
+	ln.DB.Model(&core.Bucket{}).Where("uuid = ?", bucketUuid).First(&bucket)
+	if bucket.Status != state {
+		t.Fatalf("bucket is %s, expected %s", bucket.Status, state)
+	}
+	var contents []core.Content
+	ln.DB.Model(&core.Content{}).Where("bucket_uuid = ?", bucketUuid).Find(&contents)
+	for _, content := range contents {
+		if content.Status != state {
+			t.Fatalf("content %d is %s, expected %s", content.ID, content.Status, state)
+		}
+	}
+}
+
+func TestApplyDealStatus(t *testing.T) {
+	deal := core.ContentDeal{DealUUID: "deal-1", Miner: "f01000", TransferStarted: time.Now()}
+
+	// the backend has no deal for the content yet, what is known about the deal is kept
+	applyDealStatus(&deal, core.DealStatus{Message: "waiting for a storage provider"})
+	if deal.DealUUID != "deal-1" || deal.Miner != "f01000" || deal.LastMessage != "waiting for a storage provider" {
+		t.Fatalf("a status without a deal changed the deal: %+v", deal)
+	}
+	if deal.Status != utils.STATUS_DEAL_PROPOSED {
+		t.Fatalf("deal is %s, expected %s", deal.Status, utils.STATUS_DEAL_PROPOSED)
+	}
+
+	applyDealStatus(&deal, core.DealStatus{DealUUID: "deal-1", DealID: 42, OnChainAt: time.Now()})
+	if deal.Status != utils.STATUS_DEAL_ON_CHAIN || deal.DealID != 42 || deal.Miner != "f01000" {
+		t.Fatalf("deal is %s with id %d and miner %s after going on chain", deal.Status, deal.DealID, deal.Miner)
+	}
+
+	applyDealStatus(&deal, core.DealStatus{DealUUID: "deal-1", Miner: "f02000", Failed: true, FailedAt: time.Now()})
+	if deal.Status != utils.STATUS_DEAL_FAILED || deal.Miner != "f02000" {
+		t.Fatalf("deal is %s with miner %s after failing", deal.Status, deal.Miner)
+	}
+}
+
+func TestDealTrackerMovesBucketThroughLifecycle(t *testing.T) {
+	tracker, bucket := newDealTrackerTest(t, &core.MockDealStatusProvider{}, "f01000")
+	ctx := context.Background()
+
+	for _, state := range []string{utils.STATUS_DEAL_PROPOSED, utils.STATUS_DEAL_ON_CHAIN, utils.STATUS_DEAL_SEALED} {
+		tracker.poll(ctx)
+		assertBucketState(t, tracker.LightNode, bucket.Uuid, state)
+	}
+
+	var replicas []core.ContentReplication
+	tracker.LightNode.DB.Model(&core.ContentReplication{}).Where("bucket_uuid = ?", bucket.Uuid).Find(&replicas)
+	if len(replicas) != 1 || replicas[0].Miner != "f01000" || replicas[0].Status != utils.STATUS_DEAL_SEALED {
+		t.Fatalf("expected a sealed replica with f01000, got %+v", replicas)
+	}
+}
+
+func TestDealTrackerFailsBucketOnlyWhenAllDealsFail(t *testing.T) {
+	provider := &failingProvider{failed: map[int64]bool{1: true}}
+	tracker, bucket := newDealTrackerTest(t, provider, "f01000", "f02000")
+	ctx := context.Background()
+
+	// one deal fails and the other is proposed, the bucket is as far as its best deal
+	tracker.poll(ctx)
+	assertBucketState(t, tracker.LightNode, bucket.Uuid, utils.STATUS_DEAL_PROPOSED)
+
+	var deal core.ContentDeal
+	tracker.LightNode.DB.Model(&core.ContentDeal{}).Where("content_id = ?", 1).First(&deal)
+	if deal.Status != utils.STATUS_DEAL_FAILED || deal.LastMessage != "storage provider rejected the deal" {
+		t.Fatalf("deal is %s (%s), expected it to fail", deal.Status, deal.LastMessage)
+	}
+
+	provider.failed[2] = true
+	tracker.poll(ctx)
+	assertBucketState(t, tracker.LightNode, bucket.Uuid, utils.STATUS_DEAL_FAILED)
+
+	var bucketNow core.Bucket
+	tracker.LightNode.DB.Model(&core.Bucket{}).Where("uuid = ?", bucket.Uuid).First(&bucketNow)
+	if bucketNow.LastMessage != "storage provider rejected the deal" {
+		t.Fatalf("bucket message is %q", bucketNow.LastMessage)
+	}
+}
+
+// countingProvider counts the deals it is asked about.
+type countingProvider struct {
+	core.MockDealStatusProvider
+	polled map[int64]int
+}
+
+func (p *countingProvider) DealStatus(ctx context.Context, deal core.ContentDeal) (core.DealStatus, error) {
+	p.polled[deal.ContentId]++
+	return p.MockDealStatusProvider.DealStatus(ctx, deal)
+}
+
+func TestDealTrackerSkipsDealsInAFinalState(t *testing.T) {
+	provider := &countingProvider{polled: make(map[int64]int)}
+	tracker, bucket := newDealTrackerTest(t, provider, "f01000")
+	ctx := context.Background()
+	ln := tracker.LightNode
+
+	ln.DB.Create(&core.ContentDeal{ContentId: 2, BucketUuid: bucket.Uuid, Miner: "f02000", DealUUID: "sealed", SealedAt: time.Now()})
+	ln.DB.Create(&core.ContentDeal{ContentId: 3, BucketUuid: bucket.Uuid, Miner: "f03000", DealUUID: "sealed-status", Status: utils.STATUS_DEAL_SEALED})
+	ln.DB.Create(&core.ContentDeal{ContentId: 4, BucketUuid: bucket.Uuid, Miner: "f04000", DealUUID: "slashed", Status: utils.STATUS_DEAL_SLASHED})
+	ln.DB.Create(&core.ContentDeal{ContentId: 5, BucketUuid: bucket.Uuid, Miner: "f05000", DealUUID: "failed", Failed: true, FailedAt: time.Now().Add(-2 * failedDealGrace)})
+
+	tracker.poll(ctx)
+	for contentId := int64(2); contentId <= 5; contentId++ {
+		if provider.polled[contentId] != 0 {
+			t.Fatalf("polled the deal of content %d that is in a final state", contentId)
+		}
+	}
+
+	// the open deal is polled until it is sealed, and not after
+	for i := 0; i < 5; i++ {
+		tracker.poll(ctx)
+	}
+	if provider.polled[1] != 3 {
+		t.Fatalf("polled the deal %d times, expected it to stop once sealed after 3", provider.polled[1])
+	}
+}
@@ -0,0 +1,126 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/application-research/edge-ur/core"
+	"github.com/application-research/edge-ur/utils"
+	"github.com/google/uuid"
+	ipld "github.com/ipfs/go-ipld-format"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+// DirectoryFile is a pinned file of a directory upload.
+type DirectoryFile struct {
+	Path string
+	Size int64
+	Node ipld.Node
+}
+
+// DirectoryUpload is the result of adding a directory to a collection. Root is the content of the directory nodes.
+type DirectoryUpload struct {
+	Collection core.Collection
+	Root       core.Content
+	Contents   []core.Content
+	Refs       []core.CollectionRef
+}
+
+// AddDirectoryToCollection builds the unixfs directory of the files and records it as a core.Collection. Every file
+// is added to the collection as its own content, and a core.CollectionRef keeps the path of the file in the directory.
+// The directory nodes are recorded as a content of their own, and all of them go into one bucket so the root cid can
+// be retrieved from its deals, which is why the files of a directory are not split. Nothing is recorded when any of
+// it fails.
+func AddDirectoryToCollection(ln *core.LightNode, files []DirectoryFile, collectionName string, keyHash string, policy core.Policy) (DirectoryUpload, error) {
+	var upload DirectoryUpload
+
+	entries := make([]core.DirectoryEntry, len(files))
+	for i, file := range files {
+		entries[i] = core.DirectoryEntry{Path: file.Path, Node: file.Node}
+	}
+	root, dirNodes, err := core.BuildDirectory(context.Background(), ln.Node.DAGService, entries)
+	if err != nil {
+		return upload, err
+	}
+
+	collectionUuid, err := uuid.NewUUID()
+	if err != nil {
+		return upload, err
+	}
+	upload.Collection = core.Collection{
+		UUID:       collectionUuid.String(),
+		Name:       collectionName,
+		ApiKeyHash: keyHash,
+		Cid:        root.Cid().String(),
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+
+	var dirSize int64
+	for _, dirNode := range dirNodes {
+		dirSize += int64(len(dirNode.RawData()))
+	}
+	upload.Root = newDirectoryContent(root.Cid().String(), dirSize, collectionName, keyHash)
+	upload.Contents = make([]core.Content, len(files))
+	contents := []*core.Content{&upload.Root}
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i], _ = core.CleanRelativePath(file.Path) // checked when the directory was built
+		upload.Contents[i] = newDirectoryContent(file.Node.Cid().String(), file.Size, collectionName, keyHash)
+		upload.Contents[i].Name = paths[i]
+		contents = append(contents, &upload.Contents[i])
+	}
+
+	bucket, err := ln.Buckets.AllocateAll(policy, contents, func(tx *gorm.DB) error {
+		if err := tx.Create(&upload.Collection).Error; err != nil {
+			return err
+		}
+		upload.Refs = make([]core.CollectionRef, len(files))
+		for i := range files {
+			upload.Refs[i] = core.CollectionRef{
+				Collection: upload.Collection.ID,
+				Content:    uint64(upload.Contents[i].ID),
+				Path:       &paths[i],
+				CreatedAt:  time.Now(),
+				UpdatedAt:  time.Now(),
+			}
+			if err := tx.Create(&upload.Refs[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return DirectoryUpload{}, xerrors.Errorf("failed to add the directory to a bucket: %w", err)
+	}
+	// counted at once, a key without a quota record yet would count the contents already recorded twice
+	var size int64
+	for _, content := range contents {
+		size += content.Size
+	}
+	if keyHash != "" {
+		if err := ln.Quotas.AddStorage(keyHash, size, int64(len(contents))); err != nil {
+			log.Errorf("failed to count directory %s in the quota of its api key: %s", upload.Collection.UUID, err)
+		}
+	}
+	if err := Enqueue(ln, NewBucketAggregator(ln, &bucket)); err != nil {
+		return upload, xerrors.Errorf("failed to queue the aggregator: %w", err)
+	}
+	return upload, nil
+}
+
+// newDirectoryContent returns the pinned content of a node of a directory upload.
+func newDirectoryContent(cid string, size int64, collectionName string, keyHash string) core.Content {
+	return core.Content{
+		Name:           cid,
+		Size:           size,
+		Cid:            cid,
+		ApiKeyHash:     keyHash,
+		Status:         utils.STATUS_PINNED,
+		CollectionName: collectionName,
+		MakeDeal:       true,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+}
@@ -0,0 +1,109 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/application-research/edge-ur/core"
+	"github.com/application-research/whypfs-core"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	mdutils "github.com/ipfs/boxo/ipld/merkledag/test"
+	"github.com/ipfs/go-cid"
+)
+
+// newDirectoryTest returns a node with a dag service and the pinned files of a directory upload.
+func newDirectoryTest(t *testing.T) (*core.LightNode, []DirectoryFile) {
+	t.Helper()
+	ln := newTestNode(t, config.EdgeConfig{})
+	ln.Node = &whypfs.Node{DAGService: mdutils.Mock()}
+	ln.Buckets = core.NewBucketAllocator(ln.DB)
+	ln.Quotas = core.NewQuotaKeeper(ln.DB, *ln.Config)
+
+	var files []DirectoryFile
+	for path, data := range map[string]string{"a.txt": "file a", "docs/b.txt": "file b", "docs/more/c.txt": "file c"} {
+		nd := merkledag.NewRawNode([]byte(data))
+		if err := ln.Node.DAGService.Add(context.Background(), nd); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, DirectoryFile{Path: path, Size: int64(len(data)), Node: nd})
+	}
+	return ln, files
+}
+
+func TestAddDirectoryToCollectionKeepsTheDirectoryInOneBucket(t *testing.T) {
+	ln, files := newDirectoryTest(t)
+
+	upload, err := AddDirectoryToCollection(ln, files, "default", "key", core.Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.Root.ID == 0 || upload.Root.Cid != upload.Collection.Cid {
+		t.Fatalf("the directory nodes were recorded as %+v, the root is %s", upload.Root, upload.Collection.Cid)
+	}
+	if upload.Root.BucketUuid == "" {
+		t.Fatal("the directory nodes are not in a bucket")
+	}
+	for _, content := range upload.Contents {
+		if content.BucketUuid != upload.Root.BucketUuid {
+			t.Fatalf("file %s is in bucket %s, the directory nodes in bucket %s", content.Name, content.BucketUuid, upload.Root.BucketUuid)
+		}
+	}
+	if len(upload.Refs) != len(files) {
+		t.Fatalf("recorded %d refs for %d files", len(upload.Refs), len(files))
+	}
+
+	// the bucket car walks the dag of every content, the root reaches every file through the directory nodes
+	root, err := cid.Decode(upload.Root.Cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reached := cid.NewSet()
+	err = merkledag.Walk(context.Background(), merkledag.GetLinksWithDAG(ln.Node.DAGService), root, reached.Visit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if !reached.Has(file.Node.Cid()) {
+			t.Fatalf("file %s is not reachable from the root", file.Path)
+		}
+	}
+
+	var bucket core.Bucket
+	ln.DB.Model(&core.Bucket{}).Where("uuid = ?", upload.Root.BucketUuid).First(&bucket)
+	size := upload.Root.Size
+	for _, content := range upload.Contents {
+		size += content.Size
+	}
+	if bucket.Size != size {
+		t.Fatalf("bucket size is %d, the directory takes %d", bucket.Size, size)
+	}
+	quota, err := ln.Quotas.Quota("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota.UsedBytes != size || quota.UsedObjects != int64(len(files))+1 {
+		t.Fatalf("counted %d bytes and %d objects, expected %d bytes and %d objects", quota.UsedBytes, quota.UsedObjects, size, len(files)+1)
+	}
+}
+
+func TestAddDirectoryToCollectionLeavesNothingOnFailure(t *testing.T) {
+	ln, files := newDirectoryTest(t)
+
+	// the refs are recorded last
+	if err := ln.DB.Migrator().DropTable(&core.CollectionRef{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddDirectoryToCollection(ln, files, "default", "key", core.Policy{}); err == nil {
+		t.Fatal("added the directory without its refs")
+	}
+
+	var contents, collections, buckets, jobs int64
+	ln.DB.Model(&core.Content{}).Count(&contents)
+	ln.DB.Model(&core.Collection{}).Count(&collections)
+	ln.DB.Model(&core.Bucket{}).Count(&buckets)
+	ln.DB.Model(&core.Job{}).Count(&jobs)
+	if contents != 0 || collections != 0 || buckets != 0 || jobs != 0 {
+		t.Fatalf("left %d contents, %d collections, %d buckets and %d jobs behind", contents, collections, buckets, jobs)
+	}
+}
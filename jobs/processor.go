@@ -15,6 +15,23 @@ type IProcessor interface {
 	Run() error
 }
 
+const (
+	JobTypeBucketAggregator   = "bucket-aggregator"
+	JobTypeBucketCarGenerator = "bucket-car-generator"
+	JobTypeSplitter           = "splitter"
+	JobTypeDealMaker          = "deal-maker"
+	JobTypeUploadFinalizer    = "upload-finalizer"
+	JobTypeCidFetcher         = "cid-fetcher"
+)
+
+// IQueuedProcessor is a processor that can be persisted in the job queue and rebuilt from its payload.
+type IQueuedProcessor interface {
+	IProcessor
+	Type() string
+	Key() string
+	Payload() interface{}
+}
+
 type ProcessorInfo struct {
 	Name string
 }
@@ -0,0 +1,248 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/application-research/edge-ur/core"
+	"gorm.io/gorm"
+)
+
+const (
+	JobStatusQueued  = "queued"
+	JobStatusRunning = "running"
+	JobStatusDone    = "done"
+	JobStatusFailed  = "failed"
+)
+
+// The pollInterval constant is how often idle workers look for jobs that became runnable, e.g. after a backoff.
+const pollInterval = 5 * time.Second
+
+// The maxBackoff constant caps the delay between two attempts of a failing job.
+const maxBackoff = time.Hour
+
+// queueWake is signalled whenever a job is enqueued so an idle worker picks it up right away.
+var queueWake = make(chan struct{}, 1)
+
+// processorFactories rebuilds a processor from the payload persisted in the job table.
+var processorFactories = map[string]func(ln *core.LightNode, payload []byte) (IProcessor, error){
+	JobTypeBucketAggregator:   newBucketAggregatorFromPayload,
+	JobTypeBucketCarGenerator: newBucketCarGeneratorFromPayload,
+	JobTypeSplitter:           newSplitterProcessorFromPayload,
+	JobTypeDealMaker:          newDealMakerProcessorFromPayload,
+	JobTypeUploadFinalizer:    newUploadFinalizerFromPayload,
+	JobTypeCidFetcher:         newCidFetcherFromPayload,
+}
+
+// Enqueue persists a processor in the job table so it is run by the job queue. A job that is already waiting with
+// the same type and key is not queued again.
+func Enqueue(ln *core.LightNode, p IQueuedProcessor) error {
+	return EnqueueTx(ln, ln.DB, p)
+}
+
+// EnqueueTx is Enqueue within a transaction, for jobs that must not be queued without the records they work on.
+func EnqueueTx(ln *core.LightNode, tx *gorm.DB, p IQueuedProcessor) error {
+	payload, err := json.Marshal(p.Payload())
+	if err != nil {
+		return err
+	}
+
+	var waiting int64
+	tx.Model(&core.Job{}).Where("type = ? and job_key = ? and status = ?", p.Type(), p.Key(), JobStatusQueued).Count(&waiting)
+	if waiting > 0 {
+		return nil
+	}
+
+	job := core.Job{
+		Type:        p.Type(),
+		JobKey:      p.Key(),
+		Payload:     string(payload),
+		Status:      JobStatusQueued,
+		MaxAttempts: ln.Config.Jobs.MaxAttempts,
+		RunAt:       time.Now(),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := tx.Create(&job).Error; err != nil {
+		return err
+	}
+
+	select {
+	case queueWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// JobQueue runs the jobs from the job table on a bounded pool of workers, with a concurrency limit per job type.
+type JobQueue struct {
+	LightNode *core.LightNode
+	workers   int
+	limits    map[string]int
+
+	lk      sync.Mutex
+	running map[string]int
+}
+
+// NewJobQueue creates the job queue using the worker and concurrency settings of the node.
+func NewJobQueue(ln *core.LightNode) *JobQueue {
+	workers := ln.Config.Jobs.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	return &JobQueue{
+		LightNode: ln,
+		workers:   workers,
+		limits:    parseTypeConcurrency(ln.Config.Jobs.TypeConcurrency),
+		running:   make(map[string]int),
+	}
+}
+
+// Start requeues the jobs that were running when the node went down and starts the workers.
+func (q *JobQueue) Start(ctx context.Context) {
+	q.LightNode.DB.Model(&core.Job{}).Where("status = ?", JobStatusRunning).Updates(map[string]interface{}{
+		"status":     JobStatusQueued,
+		"updated_at": time.Now(),
+	})
+
+	for i := 0; i < q.workers; i++ {
+		go q.worker(ctx)
+	}
+}
+
+func (q *JobQueue) worker(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		for job := q.claim(); job != nil; job = q.claim() {
+			q.run(job)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-queueWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim marks the oldest runnable job as running. The status check in the update makes sure a job is only handed
+// to a single worker.
+func (q *JobQueue) claim() *core.Job {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	var saturated []string
+	for jobType, limit := range q.limits {
+		if q.running[jobType] >= limit {
+			saturated = append(saturated, jobType)
+		}
+	}
+
+	query := q.LightNode.DB.Model(&core.Job{}).Where("status = ? and run_at <= ?", JobStatusQueued, time.Now())
+	if len(saturated) > 0 {
+		query = query.Where("type not in ?", saturated)
+	}
+	var job core.Job
+	if err := query.Order("id asc").Limit(1).Find(&job).Error; err != nil || job.ID == 0 {
+		return nil
+	}
+
+	res := q.LightNode.DB.Model(&core.Job{}).Where("id = ? and status = ?", job.ID, JobStatusQueued).Updates(map[string]interface{}{
+		"status":     JobStatusRunning,
+		"attempts":   gorm.Expr("attempts + 1"),
+		"updated_at": time.Now(),
+	})
+	if res.Error != nil || res.RowsAffected != 1 {
+		return nil
+	}
+	job.Status = JobStatusRunning
+	job.Attempts++
+	q.running[job.Type]++
+	return &job
+}
+
+func (q *JobQueue) run(job *core.Job) {
+	defer func() {
+		q.lk.Lock()
+		q.running[job.Type]--
+		q.lk.Unlock()
+	}()
+
+	err := q.execute(job)
+	if err == nil {
+		q.LightNode.DB.Model(job).Updates(map[string]interface{}{
+			"status":     JobStatusDone,
+			"last_error": "",
+			"updated_at": time.Now(),
+		})
+		return
+	}
+
+	log.Errorf("job %d (%s) failed on attempt %d: %s", job.ID, job.Type, job.Attempts, err)
+	updates := map[string]interface{}{
+		"status":     JobStatusQueued,
+		"last_error": err.Error(),
+		"run_at":     time.Now().Add(q.backoff(job.Attempts)),
+		"updated_at": time.Now(),
+	}
+	if job.Attempts >= job.MaxAttempts {
+		updates["status"] = JobStatusFailed
+	}
+	q.LightNode.DB.Model(job).Updates(updates)
+}
+
+// execute rebuilds the processor of a job and runs it. Processors still panic on some errors, those are turned into
+// a failed attempt instead of taking the node down.
+func (q *JobQueue) execute(job *core.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("processor panicked: %v", r)
+		}
+	}()
+
+	factory, ok := processorFactories[job.Type]
+	if !ok {
+		return fmt.Errorf("unknown job type: %s", job.Type)
+	}
+	processor, err := factory(q.LightNode, []byte(job.Payload))
+	if err != nil {
+		return err
+	}
+	return processor.Run()
+}
+
+// backoff doubles the retry delay with every attempt.
+func (q *JobQueue) backoff(attempts int) time.Duration {
+	delay := time.Duration(q.LightNode.Config.Jobs.BackoffSeconds) * time.Second
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// parseTypeConcurrency parses the per type limits, formatted as `type:limit,type:limit`.
+func parseTypeConcurrency(s string) map[string]int {
+	limits := make(map[string]int)
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) != 2 {
+			continue
+		}
+		limit, err := strconv.Atoi(parts[1])
+		if err != nil || limit <= 0 {
+			log.Warnf("ignoring invalid job concurrency limit: %s", entry)
+			continue
+		}
+		limits[parts[0]] = limit
+	}
+	return limits
+}
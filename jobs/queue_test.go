@@ -0,0 +1,48 @@
+package jobs
+
+import (
+	"testing"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/application-research/edge-ur/core"
+	"golang.org/x/xerrors"
+	"gorm.io/gorm"
+)
+
+func TestEnqueueTxRollsBackWithTheTransaction(t *testing.T) {
+	ln := newTestNode(t, config.EdgeConfig{})
+
+	err := ln.DB.Transaction(func(tx *gorm.DB) error {
+		fetch := core.CidFetch{Cid: "bafy-cid", Status: core.FetchStatusQueued}
+		if err := tx.Create(&fetch).Error; err != nil {
+			return err
+		}
+		if err := EnqueueTx(ln, tx, NewCidFetcherProcessor(ln, fetch)); err != nil {
+			return err
+		}
+		return xerrors.New("rolled back")
+	})
+	if err == nil {
+		t.Fatal("the transaction was not rolled back")
+	}
+
+	var fetches, jobs int64
+	ln.DB.Model(&core.CidFetch{}).Count(&fetches)
+	ln.DB.Model(&core.Job{}).Count(&jobs)
+	if fetches != 0 || jobs != 0 {
+		t.Fatalf("%d fetches and %d jobs were left behind", fetches, jobs)
+	}
+
+	fetch := core.CidFetch{Cid: "bafy-cid", Status: core.FetchStatusQueued}
+	ln.DB.Create(&fetch)
+	if err := Enqueue(ln, NewCidFetcherProcessor(ln, fetch)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Enqueue(ln, NewCidFetcherProcessor(ln, fetch)); err != nil {
+		t.Fatal(err)
+	}
+	ln.DB.Model(&core.Job{}).Count(&jobs)
+	if jobs != 1 {
+		t.Fatalf("queued %d jobs for one waiting fetch", jobs)
+	}
+}
@@ -1,92 +1,159 @@
 package jobs
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"github.com/application-research/edge-ur/core"
 	"github.com/application-research/edge-ur/utils"
-	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
 	"io"
+	"os"
+	"strconv"
 	"time"
 )
 
 type SplitterProcessor struct {
-	Content core.Content `json:"content"`
-	File    io.Reader    `json:"file"`
+	Content  core.Content `json:"content"`
+	FilePath string       `json:"file_path"`
 	Processor
 }
 
-func NewSplitterProcessor(ln *core.LightNode, contentToProcess core.Content, fileNode io.Reader) IProcessor {
+// SplitterJobPayload is the job queue payload of the splitter. The file is read from FilePath when the upload was
+// staged on disk, otherwise it is read back from the blockstore using the content cid.
+type SplitterJobPayload struct {
+	ContentId int64  `json:"content_id"`
+	FilePath  string `json:"file_path,omitempty"`
+}
+
+func NewSplitterProcessor(ln *core.LightNode, contentToProcess core.Content, filePath string) IQueuedProcessor {
 	return &SplitterProcessor{
 		contentToProcess,
-		fileNode,
+		filePath,
 		Processor{
 			LightNode: ln,
 		},
 	}
 }
 
+// newSplitterProcessorFromPayload loads the content of a queued splitter job.
+func newSplitterProcessorFromPayload(ln *core.LightNode, payload []byte) (IProcessor, error) {
+	var p SplitterJobPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	var content core.Content
+	if err := ln.DB.Model(&core.Content{}).Where("id = ?", p.ContentId).First(&content).Error; err != nil {
+		return nil, err
+	}
+	return NewSplitterProcessor(ln, content, p.FilePath), nil
+}
+
 func (r *SplitterProcessor) Info() error {
 	panic("implement me")
 }
 
+func (r *SplitterProcessor) Type() string {
+	return JobTypeSplitter
+}
+
+func (r *SplitterProcessor) Key() string {
+	return strconv.FormatInt(r.Content.ID, 10)
+}
+
+func (r *SplitterProcessor) Payload() interface{} {
+	return SplitterJobPayload{ContentId: r.Content.ID, FilePath: r.FilePath}
+}
+
+func (r *SplitterProcessor) openFile() (io.ReadCloser, error) {
+	if r.FilePath != "" {
+		return os.Open(r.FilePath)
+	}
+	contentCid, err := cid.Decode(r.Content.Cid)
+	if err != nil {
+		return nil, err
+	}
+	file, err := r.LightNode.Node.GetFile(context.Background(), contentCid)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(file), nil
+}
+
 func (r *SplitterProcessor) Run() error {
 
 	// load the policy
 	var policy core.Policy
 	r.LightNode.DB.Model(&core.Policy{}).Where("name = ?", r.Content.CollectionName).First(&policy)
 
-	// split the file.
-	fileSplitter := new(core.FileSplitter)
-	fileSplitter.ChuckSize = policy.SplitSize
-	arrBts, err := fileSplitter.SplitFileFromReader(r.File) // nice split.
+	file, err := r.openFile()
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer file.Close()
 
-	// create a bucket
-	bucketUuid, err := uuid.NewUUID()
-	bucket := core.Bucket{
-		Status:           "open",
-		Name:             r.Content.CollectionName,
-		RequestingApiKey: r.Content.RequestingApiKey,
-		Uuid:             bucketUuid.String(),
-		Miner:            r.Content.Miner,
-		PolicyId:         policy.ID,
-		CreatedAt:        time.Now(),
-		UpdatedAt:        time.Now(),
+	bucketUuid := core.SplitBucketUuid(r.Content.ID)
+	var bucket core.Bucket
+	r.LightNode.DB.Model(&core.Bucket{}).Where("uuid = ?", bucketUuid).First(&bucket)
+	if bucket.ID == 0 {
+		bucket = core.Bucket{
+			Status:     "open",
+			Name:       r.Content.CollectionName,
+			ApiKeyHash: r.Content.ApiKeyHash,
+			Uuid:       bucketUuid,
+			Miner:      r.Content.Miner,
+			PolicyId:   policy.ID,
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+		}
+		r.LightNode.DB.Create(&bucket)
 	}
-	r.LightNode.DB.Create(&bucket)
 
-	// create a content for each split
-	var i int
-	for _, b := range arrBts {
-		bNd, err := r.LightNode.Node.AddPinFile(context.Background(), bytes.NewReader(b), nil)
+	// pin the splits one at a time straight from the file, and create a content for each
+	fileSplitter := core.NewFileSplitter(core.SplitterParam{ChuckSize: policy.SplitSize})
+	err = fileSplitter.SplitReader(file, func(i int, chunk io.Reader) error {
+		bNd, err := r.LightNode.Node.AddPinFile(context.Background(), chunk, nil)
+		if err != nil {
+			return err
+		}
+		size, err := core.UnixfsSize(bNd)
 		if err != nil {
-			panic(err)
+			return err
+		}
+
+		var existing int64
+		r.LightNode.DB.Model(&core.Content{}).Where("bucket_uuid = ? and cid = ?", bucket.Uuid, bNd.Cid().String()).Count(&existing)
+		if existing > 0 {
+			return nil
 		}
 		newContent := core.Content{
 			Name: string(rune(i)) + "split-" + bNd.Cid().String(),
-			Size: int64(len(b)),
+			Size: size,
 			Cid:  bNd.Cid().String(),
 			//DeltaNodeUrl:     r.Content.DeltaNodeUrl,
-			RequestingApiKey: r.Content.RequestingApiKey,
-			Status:           utils.STATUS_PINNED,
-			Miner:            r.Content.Miner,
-			CollectionName:   r.Content.CollectionName,
-			BucketUuid:       bucket.Uuid,
-			MakeDeal:         true,
-			CreatedAt:        time.Now(),
-			UpdatedAt:        time.Now(),
+			ApiKeyHash:      r.Content.ApiKeyHash,
+			Status:          utils.STATUS_PINNED,
+			Miner:           r.Content.Miner,
+			CollectionName:  r.Content.CollectionName,
+			ParentContentID: r.Content.ID,
+			BucketUuid:      bucket.Uuid,
+			MakeDeal:        true,
+			CreatedAt:       time.Now(),
+			UpdatedAt:       time.Now(),
 		}
-		r.LightNode.DB.Create(&newContent)
-		i++
+		return r.LightNode.DB.Create(&newContent).Error
+	})
+	if err != nil {
+		return err
 	}
 
-	job := CreateNewDispatcher()
-	genCar := NewBucketCarGenerator(r.LightNode, bucket)
-	job.AddJob(genCar)
-	job.Start(1)
+	if err := Enqueue(r.LightNode, NewBucketCarGenerator(r.LightNode, bucket)); err != nil {
+		return err
+	}
+
+	// the staged upload is no longer needed once the splits are pinned
+	if r.FilePath != "" {
+		os.Remove(r.FilePath)
+	}
 
 	return nil
 }
@@ -0,0 +1,141 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/application-research/edge-ur/core"
+	"github.com/application-research/edge-ur/utils"
+)
+
+// The UploadFinalizerProcessor adds a finished resumable upload to IPFS and to its collection, the same way a single
+// request upload is.
+type UploadFinalizerProcessor struct {
+	Upload core.Upload
+	Processor
+}
+
+// UploadJobPayload is the job queue payload of the upload finalizer.
+type UploadJobPayload struct {
+	UploadUuid string `json:"upload_uuid"`
+}
+
+func NewUploadFinalizerProcessor(ln *core.LightNode, upload core.Upload) IQueuedProcessor {
+	return &UploadFinalizerProcessor{
+		Upload: upload,
+		Processor: Processor{
+			LightNode: ln,
+		},
+	}
+}
+
+// newUploadFinalizerFromPayload loads the upload of a queued finalizer job.
+func newUploadFinalizerFromPayload(ln *core.LightNode, payload []byte) (IProcessor, error) {
+	var p UploadJobPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	var upload core.Upload
+	if err := ln.DB.Model(&core.Upload{}).Where("uuid = ?", p.UploadUuid).First(&upload).Error; err != nil {
+		return nil, err
+	}
+	return NewUploadFinalizerProcessor(ln, upload), nil
+}
+
+func (r *UploadFinalizerProcessor) Info() error {
+	panic("implement me")
+}
+
+func (r *UploadFinalizerProcessor) Type() string {
+	return JobTypeUploadFinalizer
+}
+
+func (r *UploadFinalizerProcessor) Key() string {
+	return r.Upload.Uuid
+}
+
+func (r *UploadFinalizerProcessor) Payload() interface{} {
+	return UploadJobPayload{UploadUuid: r.Upload.Uuid}
+}
+
+// Run pins the staged file and registers it as a content of the collection of the upload. The staged file is kept
+// for the splitter when the content is split, and removed otherwise.
+func (r *UploadFinalizerProcessor) Run() error {
+	if r.Upload.Status != core.UploadStatusProcessing || r.Upload.ContentID != 0 {
+		return nil
+	}
+
+	stagedPath := r.LightNode.UploadPath(r.Upload.Uuid)
+	file, err := os.Open(stagedPath)
+	if err != nil {
+		return r.fail(err)
+	}
+	addNode, err := r.LightNode.Node.AddPinFile(context.Background(), file, nil)
+	file.Close()
+	if err != nil {
+		return err // retried by the job queue
+	}
+
+	policy, err := r.LightNode.PolicyForCollection(r.Upload.CollectionName)
+	if err != nil {
+		return err
+	}
+
+	split := r.Upload.Length > r.LightNode.Config.Common.MaxSizeToSplit
+	newContent := r.recordedContent(addNode.Cid().String())
+	if newContent.ID == 0 {
+		newContent = core.Content{
+			Name:           r.Upload.Name,
+			Size:           r.Upload.Length,
+			Cid:            addNode.Cid().String(),
+			ApiKeyHash:     r.Upload.ApiKeyHash,
+			Status:         utils.STATUS_PINNED,
+			CollectionName: r.Upload.CollectionName,
+			MakeDeal:       true,
+			CreatedAt:      time.Now(),
+			UpdatedAt:      time.Now(),
+		}
+		newContent, err = AddContentToCollection(r.LightNode, newContent, policy, stagedPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = r.LightNode.DB.Model(&core.Upload{}).Where("id = ?", r.Upload.ID).Updates(map[string]interface{}{
+		"status":     core.UploadStatusCompleted,
+		"content_id": newContent.ID,
+		"updated_at": time.Now(),
+	}).Error
+	if err != nil {
+		return err // the retry picks the recorded content up again, it still needs the staged file
+	}
+	if !split {
+		os.Remove(stagedPath)
+	}
+	return nil
+}
+
+// recordedContent returns the content a previous run of the finalizer recorded for the upload before it failed to
+// complete the upload, so a retry doesn't add the file twice. It is a content of the file made since the upload was
+// created that no other upload points to.
+func (r *UploadFinalizerProcessor) recordedContent(contentCid string) core.Content {
+	var content core.Content
+	r.LightNode.DB.Model(&core.Content{}).
+		Where("cid = ? and name = ? and api_key_hash = ? and collection_name = ? and parent_content_id = 0 and created_at >= ?",
+			contentCid, r.Upload.Name, r.Upload.ApiKeyHash, r.Upload.CollectionName, r.Upload.CreatedAt).
+		Where("id not in (?)", r.LightNode.DB.Model(&core.Upload{}).Select("content_id").Where("id <> ? and content_id <> 0", r.Upload.ID)).
+		Order("id asc").Limit(1).Find(&content)
+	return content
+}
+
+// fail marks an upload that can not be finished, e.g. because its staged data is gone.
+func (r *UploadFinalizerProcessor) fail(err error) error {
+	r.LightNode.DB.Model(&core.Upload{}).Where("id = ?", r.Upload.ID).Updates(map[string]interface{}{
+		"status":       core.UploadStatusFailed,
+		"last_message": err.Error(),
+		"updated_at":   time.Now(),
+	})
+	return nil
+}
@@ -0,0 +1,35 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/application-research/edge-ur/core"
+)
+
+func TestUploadFinalizerFindsRecordedContent(t *testing.T) {
+	ln := newTestNode(t, config.EdgeConfig{})
+	upload := core.Upload{Uuid: "upload-uuid", Name: "a.txt", CollectionName: "default", ApiKeyHash: "key", Status: core.UploadStatusProcessing, CreatedAt: time.Now().Add(-time.Minute)}
+	ln.DB.Create(&upload)
+	finalizer := NewUploadFinalizerProcessor(ln, upload).(*UploadFinalizerProcessor)
+
+	// the same file uploaded before the upload, and by an upload that completed
+	ln.DB.Create(&core.Content{Name: "a.txt", Cid: "bafy-a", ApiKeyHash: "key", CollectionName: "default", CreatedAt: time.Now().Add(-time.Hour)})
+	other := core.Content{Name: "a.txt", Cid: "bafy-a", ApiKeyHash: "key", CollectionName: "default", CreatedAt: time.Now()}
+	ln.DB.Create(&other)
+	ln.DB.Create(&core.Upload{Uuid: "other-uuid", Name: "a.txt", CollectionName: "default", ApiKeyHash: "key", Status: core.UploadStatusCompleted, ContentID: other.ID})
+	if content := finalizer.recordedContent("bafy-a"); content.ID != 0 {
+		t.Fatalf("picked up content %d that belongs to something else", content.ID)
+	}
+
+	// a run that recorded the content but failed to complete the upload
+	recorded := core.Content{Name: "a.txt", Cid: "bafy-a", ApiKeyHash: "key", CollectionName: "default", CreatedAt: time.Now()}
+	ln.DB.Create(&recorded)
+	if content := finalizer.recordedContent("bafy-a"); content.ID != recorded.ID {
+		t.Fatalf("picked up content %d, expected the recorded content %d", content.ID, recorded.ID)
+	}
+	if content := finalizer.recordedContent("bafy-b"); content.ID != 0 {
+		t.Fatal("picked up the content of another file")
+	}
+}
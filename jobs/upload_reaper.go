@@ -0,0 +1,43 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/application-research/edge-ur/core"
+)
+
+// UploadReaper periodically removes the resumable uploads that expired before they were finished, so their staged
+// data does not fill up the disk.
+type UploadReaper struct {
+	LightNode *core.LightNode
+	interval  time.Duration
+}
+
+func NewUploadReaper(ln *core.LightNode) *UploadReaper {
+	return &UploadReaper{
+		LightNode: ln,
+		interval:  10 * time.Minute,
+	}
+}
+
+// Start removes the expired uploads on every tick until the context is done.
+func (r *UploadReaper) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			expired, err := r.LightNode.ExpireUploads(time.Now())
+			if err != nil {
+				log.Errorf("failed to expire uploads: %s", err)
+			} else if expired > 0 {
+				log.Infof("removed %d expired uploads", expired)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,56 @@
+package jobs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/application-research/edge-ur/config"
+	"github.com/application-research/edge-ur/core"
+)
+
+func TestUploadReaperRemovesExpiredUploads(t *testing.T) {
+	var cfg config.EdgeConfig
+	cfg.Node.StagingDir = t.TempDir()
+	ln := newTestNode(t, cfg)
+
+	uploads := []core.Upload{
+		{Uuid: "expired", Status: core.UploadStatusUploading, ExpiresAt: time.Now().Add(-time.Minute)},
+		{Uuid: "active", Status: core.UploadStatusUploading, ExpiresAt: time.Now().Add(time.Hour)},
+		{Uuid: "processing", Status: core.UploadStatusProcessing, ExpiresAt: time.Now().Add(-time.Minute)},
+	}
+	for _, upload := range uploads {
+		ln.DB.Create(&upload)
+		os.MkdirAll(filepath.Dir(ln.UploadPath(upload.Uuid)), 0755)
+		if err := os.WriteFile(ln.UploadPath(upload.Uuid), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	NewUploadReaper(ln).Start(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var left int64
+		ln.DB.Model(&core.Upload{}).Count(&left)
+		if left == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d uploads are left", left)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(ln.UploadPath("expired")); !os.IsNotExist(err) {
+		t.Fatal("the staged data of the expired upload was kept")
+	}
+	for _, uploadUuid := range []string{"active", "processing"} {
+		if _, err := os.Stat(ln.UploadPath(uploadUuid)); err != nil {
+			t.Fatalf("the staged data of upload %s was removed", uploadUuid)
+		}
+	}
+}
@@ -26,6 +26,7 @@ func main() {
 	// get all the commands
 	var commands []*cli.Command
 	commands = append(commands, cmd.DaemonCmd(&cfg)...)
+	commands = append(commands, cmd.ApiKeyCmd(&cfg)...)
 
 	app := &cli.App{
 		Commands: commands,
@@ -13,3 +13,10 @@ var White = "\033[97m"
 var STATUS_PINNED = "pinned"
 var STATUS_UNPINNED = "unpinned"
 var STATUS_UPLOADED_TO_DELTA = "uploaded-to-delta"
+
+// deal lifecycle of the buckets and their contents, once the bucket is submitted for deal-making
+var STATUS_DEAL_PROPOSED = "deal-proposed"
+var STATUS_DEAL_ON_CHAIN = "on-chain"
+var STATUS_DEAL_SEALED = "sealed"
+var STATUS_DEAL_FAILED = "failed"
+var STATUS_DEAL_SLASHED = "slashed"
@@ -1,9 +1,11 @@
 package utils
 
 import (
+	"bufio"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
@@ -289,3 +291,208 @@ func DecryptWithChacha20poly1305(dek []byte, encryptedFilePath string, decrypted
 
 	return nil
 }
+
+// StreamAlgorithm is the format of the encrypted streams below. The plaintext is cut in chunks of StreamChunkSize
+// bytes, the last one may be shorter, and every chunk is sealed with XChaCha20-Poly1305 under a fresh nonce and
+// written as nonce || ciphertext. The associated data of a chunk is its index and whether it is the last chunk, so chunks can
+// neither be reordered nor dropped from the end. Fixed size chunks let a reader seek without decrypting everything
+// in front.
+const StreamAlgorithm = "xchacha20poly1305-chunked"
+
+// StreamChunkSize is the plaintext size of a chunk of an encrypted stream. It is part of the format, changing it makes
+// the streams written before unreadable.
+const StreamChunkSize = 1024 * 32
+
+// NewDataKey returns a random key for NewEncryptingReader.
+func NewDataKey() ([]byte, error) {
+	dek := make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	return dek, nil
+}
+
+// DeriveKey derives a key encryption key from a secret with argon2id.
+func DeriveKey(secret []byte, salt []byte) []byte {
+	return argon2.IDKey(secret, salt, KeyTime, KeyMemory, KeyThreads, EncryptionKeySize)
+}
+
+// WrapKey seals a data key with a key encryption key, the nonce is prepended to the result.
+func WrapKey(kek []byte, dek []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(dek)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dek, nil), nil
+}
+
+// UnwrapKey opens a data key sealed by WrapKey.
+func UnwrapKey(kek []byte, wrapped []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], nil)
+}
+
+// EncryptedStreamSize returns the size of the encrypted stream of plainSize bytes.
+func EncryptedStreamSize(plainSize int64) int64 {
+	return plainSize + streamChunks(plainSize)*int64(chacha20poly1305.NonceSizeX+chacha20poly1305.Overhead)
+}
+
+// streamChunks returns the number of chunks of a stream, an empty stream still has its last chunk.
+func streamChunks(plainSize int64) int64 {
+	if plainSize == 0 {
+		return 1
+	}
+	return (plainSize + StreamChunkSize - 1) / StreamChunkSize
+}
+
+// streamChunkAd is the associated data of a chunk of a stream.
+func streamChunkAd(index int64, last bool) []byte {
+	ad := make([]byte, 9)
+	binary.BigEndian.PutUint64(ad, uint64(index))
+	if last {
+		ad[8] = 1
+	}
+	return ad
+}
+
+type encryptingReader struct {
+	aead  cipher.AEAD
+	src   *bufio.Reader
+	buf   []byte
+	out   []byte
+	index int64
+	done  bool
+}
+
+// NewEncryptingReader returns a reader of the encrypted stream of r.
+func NewEncryptingReader(dek []byte, r io.Reader) (io.Reader, error) {
+	aead, err := chacha20poly1305.NewX(dek)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptingReader{
+		aead: aead,
+		src:  bufio.NewReaderSize(r, StreamChunkSize),
+		buf:  make([]byte, StreamChunkSize),
+	}, nil
+}
+
+func (er *encryptingReader) Read(p []byte) (int, error) {
+	for len(er.out) == 0 {
+		if er.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(er.src, er.buf)
+		last := false
+		switch err {
+		case nil:
+			// a full chunk is the last one when nothing follows it
+			if _, err := er.src.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return 0, err
+			}
+		case io.EOF, io.ErrUnexpectedEOF:
+			last = true
+		default:
+			return 0, err
+		}
+
+		nonce := make([]byte, er.aead.NonceSize(), er.aead.NonceSize()+n+er.aead.Overhead())
+		if _, err := rand.Read(nonce); err != nil {
+			return 0, err
+		}
+		er.out = er.aead.Seal(nonce, nonce, er.buf[:n], streamChunkAd(er.index, last))
+		er.index++
+		er.done = last
+	}
+	n := copy(p, er.out)
+	er.out = er.out[n:]
+	return n, nil
+}
+
+type decryptingReadSeeker struct {
+	aead       cipher.AEAD
+	src        io.ReadSeeker
+	size       int64
+	pos        int64
+	chunk      []byte
+	chunkIndex int64
+}
+
+// NewDecryptingReadSeeker returns a reader of the plaintext of the encrypted stream in r, which decrypts plainSize
+// bytes. Seeking only decrypts the chunk the new position falls in.
+func NewDecryptingReadSeeker(dek []byte, r io.ReadSeeker, plainSize int64) (io.ReadSeeker, error) {
+	aead, err := chacha20poly1305.NewX(dek)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReadSeeker{aead: aead, src: r, size: plainSize, chunkIndex: -1}, nil
+}
+
+func (dr *decryptingReadSeeker) Read(p []byte) (int, error) {
+	if dr.pos >= dr.size {
+		return 0, io.EOF
+	}
+	index := dr.pos / StreamChunkSize
+	if index != dr.chunkIndex {
+		if err := dr.loadChunk(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, dr.chunk[dr.pos-index*StreamChunkSize:])
+	dr.pos += int64(n)
+	return n, nil
+}
+
+func (dr *decryptingReadSeeker) loadChunk(index int64) error {
+	overhead := int64(dr.aead.NonceSize() + dr.aead.Overhead())
+	if _, err := dr.src.Seek(index*(StreamChunkSize+overhead), io.SeekStart); err != nil {
+		return err
+	}
+	plainLen := dr.size - index*StreamChunkSize
+	if plainLen > StreamChunkSize {
+		plainLen = StreamChunkSize
+	}
+	sealed := make([]byte, plainLen+overhead)
+	if _, err := io.ReadFull(dr.src, sealed); err != nil {
+		return err
+	}
+	last := index == streamChunks(dr.size)-1
+	chunk, err := dr.aead.Open(nil, sealed[:dr.aead.NonceSize()], sealed[dr.aead.NonceSize():], streamChunkAd(index, last))
+	if err != nil {
+		return fmt.Errorf("chunk %d can not be decrypted: %w", index, err)
+	}
+	dr.chunk = chunk
+	dr.chunkIndex = index
+	return nil
+}
+
+func (dr *decryptingReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = dr.pos + offset
+	case io.SeekEnd:
+		pos = dr.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if pos < 0 {
+		return 0, fmt.Errorf("negative position %d", pos)
+	}
+	dr.pos = pos
+	return pos, nil
+}
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const sealedChunkSize = StreamChunkSize + chacha20poly1305.NonceSizeX + chacha20poly1305.Overhead
+
+func encryptStream(t *testing.T, dek []byte, plain []byte) []byte {
+	t.Helper()
+	er, err := NewEncryptingReader(dek, bytes.NewReader(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(er)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(sealed)) != EncryptedStreamSize(int64(len(plain))) {
+		t.Fatalf("encrypted %d bytes to %d bytes, expected %d", len(plain), len(sealed), EncryptedStreamSize(int64(len(plain))))
+	}
+	return sealed
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestEncryptedStreamRoundTrip(t *testing.T) {
+	dek, err := NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{0, 1, StreamChunkSize - 1, StreamChunkSize, StreamChunkSize + 1, 3*StreamChunkSize + 100} {
+		plain := randomBytes(t, size)
+		sealed := encryptStream(t, dek, plain)
+
+		dr, err := NewDecryptingReadSeeker(dek, bytes.NewReader(sealed), int64(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(dr)
+		if err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("size %d: plaintext differs after the round trip", size)
+		}
+	}
+}
+
+func TestEncryptedStreamSeek(t *testing.T) {
+	dek, _ := NewDataKey()
+	plain := randomBytes(t, 3*StreamChunkSize+100)
+	dr, err := NewDecryptingReadSeeker(dek, bytes.NewReader(encryptStream(t, dek, plain)), int64(len(plain)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reads that start in one chunk and end in the next, backwards and forwards
+	for _, offset := range []int64{2*StreamChunkSize - 10, 10, StreamChunkSize - 1, 3 * StreamChunkSize, int64(len(plain)) - 5} {
+		if _, err := dr.Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, 20)
+		n, err := io.ReadFull(dr, got)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			t.Fatalf("offset %d: %s", offset, err)
+		}
+		if !bytes.Equal(got[:n], plain[offset:offset+int64(n)]) {
+			t.Fatalf("offset %d: read the wrong bytes", offset)
+		}
+	}
+
+	if pos, err := dr.Seek(-50, io.SeekEnd); err != nil || pos != int64(len(plain))-50 {
+		t.Fatalf("seek from the end went to %d: %v", pos, err)
+	}
+	if pos, err := dr.Seek(-StreamChunkSize, io.SeekCurrent); err != nil || pos != int64(len(plain))-50-StreamChunkSize {
+		t.Fatalf("seek from the current position went to %d: %v", pos, err)
+	}
+	rest, err := io.ReadAll(dr)
+	if err != nil || !bytes.Equal(rest, plain[len(plain)-50-StreamChunkSize:]) {
+		t.Fatalf("read the wrong bytes after seeking back: %v", err)
+	}
+	if _, err := dr.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("seeking before the start succeeded")
+	}
+}
+
+func TestEncryptedStreamTruncated(t *testing.T) {
+	dek, _ := NewDataKey()
+	plain := randomBytes(t, 2*StreamChunkSize+100)
+	sealed := encryptStream(t, dek, plain)
+
+	// the last chunk is cut short
+	dr, _ := NewDecryptingReadSeeker(dek, bytes.NewReader(sealed[:len(sealed)-10]), int64(len(plain)))
+	if _, err := io.ReadAll(dr); err == nil {
+		t.Fatal("read a stream whose last chunk was cut short")
+	}
+
+	// the last chunk is dropped and the size claims the stream ends at the chunk before
+	dr, _ = NewDecryptingReadSeeker(dek, bytes.NewReader(sealed[:2*sealedChunkSize]), 2*StreamChunkSize)
+	if _, err := io.ReadAll(dr); err == nil {
+		t.Fatal("read a stream whose last chunk was dropped")
+	}
+}
+
+func TestEncryptedStreamReordered(t *testing.T) {
+	dek, _ := NewDataKey()
+	plain := randomBytes(t, 3*StreamChunkSize)
+	sealed := encryptStream(t, dek, plain)
+
+	swapped := make([]byte, 0, len(sealed))
+	swapped = append(swapped, sealed[sealedChunkSize:2*sealedChunkSize]...)
+	swapped = append(swapped, sealed[:sealedChunkSize]...)
+	swapped = append(swapped, sealed[2*sealedChunkSize:]...)
+	dr, _ := NewDecryptingReadSeeker(dek, bytes.NewReader(swapped), int64(len(plain)))
+	if _, err := io.ReadAll(dr); err == nil {
+		t.Fatal("read a stream with reordered chunks")
+	}
+
+	other, _ := NewDataKey()
+	dr, _ = NewDecryptingReadSeeker(other, bytes.NewReader(sealed), int64(len(plain)))
+	if _, err := io.ReadAll(dr); err == nil {
+		t.Fatal("read a stream with the wrong key")
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"crypto/ecdsa"
+	"crypto/rand"
 	"crypto/sha512"
 	"crypto/x509"
 	"encoding/asn1"
@@ -81,3 +82,27 @@ func VerifyEcdsaSha512Signature(message interface{}, signatureBase64 string, pub
 		return false, fmt.Errorf("invalid signature length: %d bytes, expected %d or %d bytes", len(signatureBytes), byteSize, 2*byteSize)
 	}
 }
+
+// SignEcdsaSha512 signs the json of a message the way VerifyEcdsaSha512Signature checks it, the signature is ASN.1
+// encoded and base64 encoded.
+func SignEcdsaSha512(message interface{}, privateKey *ecdsa.PrivateKey) (string, error) {
+	messageBytes, err := json.Marshal(message)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	hash := sha512.Sum512(messageBytes)
+	signature, err := ecdsa.SignASN1(rand.Reader, privateKey, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// PublicKeyPEM encodes the public key of an ECDSA key as PEM.
+func PublicKeyPEM(privateKey *ecdsa.PrivateKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}